@@ -5,8 +5,12 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"path/filepath"
+	"io/fs"
+	"runtime"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/SphereStacking/plexr/internal/config"
@@ -18,11 +22,16 @@ import (
 
 var (
 	// Execute command flags
-	auto     bool
-	dryRun   bool
-	fromStep string
-	platform string
-	only     string
+	auto         bool
+	dryRun       bool
+	fromStep     string
+	dependencies string
+	platform     string
+	only         string
+	tags         string
+	skipTags     string
+	parallel     int
+	onPlanChange string
 )
 
 // executeCmd represents the execute command
@@ -46,8 +55,32 @@ respecting dependencies and skip conditions.`,
   # Start from a specific step
   plexr execute plan.yml --from-step=build
 
-  # Execute only specific steps
-  plexr execute plan.yml --only=test,deploy`,
+  # Start from a step, requiring its dependencies to be completed already
+  plexr execute plan.yml --from-step=build --dependencies=enforce
+
+  # Execute only specific steps (their dependencies are included)
+  plexr execute plan.yml --only=test,deploy
+
+  # Execute the steps tagged tools or db, without the optional ones
+  plexr execute plan.yml --tags=tools,db --skip-tags=optional
+
+  # Execute the steps for another platform
+  plexr execute plan.yml --platform=linux
+
+  # Run up to 4 independent steps at the same time
+  plexr execute plan.yml --parallel=4
+
+  # Override plan variables
+  plexr execute plan.yml --var-file=staging.yml --var db_name=app_test
+
+  # Load database credentials from a .env file
+  plexr execute plan.yml --env-file=.env.staging
+
+  # Keep the progress after the plan version changed, running only new steps
+  plexr execute plan.yml --on-plan-change=new-steps
+
+  # Remove the lock left by a run that was killed
+  plexr execute plan.yml --force-unlock`,
 	Args: cobra.ExactArgs(1),
 	RunE: runExecute,
 }
@@ -58,8 +91,16 @@ func init() {
 	executeCmd.Flags().BoolVarP(&auto, "auto", "a", false, "Skip confirmation prompts")
 	executeCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "Show what would be executed without running")
 	executeCmd.Flags().StringVar(&fromStep, "from-step", "", "Start execution from a specific step")
+	executeCmd.Flags().StringVar(&dependencies, "dependencies", string(core.DependenciesAssume), "How dependencies skipped by --from-step or --skip-tags are treated (assume, enforce)")
 	executeCmd.Flags().StringVarP(&platform, "platform", "p", "", "Override platform detection")
-	executeCmd.Flags().StringVarP(&only, "only", "o", "", "Execute only specific steps")
+	executeCmd.Flags().StringVarP(&only, "only", "o", "", "Execute only specific steps (comma-separated)")
+	executeCmd.Flags().StringVar(&tags, "tags", "", "Execute only steps with any of these tags (comma-separated)")
+	executeCmd.Flags().StringVar(&skipTags, "skip-tags", "", "Never execute steps with any of these tags (comma-separated)")
+	executeCmd.Flags().StringVar(&onPlanChange, "on-plan-change", "", "What to do when the state was recorded for another plan name or version (abort, reset, continue, new-steps)")
+	executeCmd.Flags().IntVarP(&parallel, "parallel", "j", 0, "Maximum number of steps to run concurrently (default: plan max_parallel or 1)")
+	addPlanFlags(executeCmd)
+	addLockFlags(executeCmd)
+	addStateFileFlag(executeCmd)
 }
 
 func runExecute(cmd *cobra.Command, args []string) error {
@@ -68,6 +109,10 @@ func runExecute(cmd *cobra.Command, args []string) error {
 
 	planFile := args[0]
 
+	if parallel < 0 {
+		return fmt.Errorf("--parallel cannot be negative")
+	}
+
 	// Initialize logger
 	err := utils.InitLogger(IsVerbose())
 	if err != nil {
@@ -76,7 +121,8 @@ func runExecute(cmd *cobra.Command, args []string) error {
 
 	// Load execution plan
 	fmt.Printf("Loading execution plan: %s\n", planFile)
-	plan, err := config.LoadExecutionPlan(planFile)
+	// Secrets are not needed to show a dry run
+	plan, err := loadPlan(planFile, !dryRun)
 	if err != nil {
 		return fmt.Errorf("failed to load execution plan: %w", err)
 	}
@@ -86,21 +132,45 @@ func runExecute(cmd *cobra.Command, args []string) error {
 		fmt.Printf("📝 %s\n", plan.Description)
 	}
 
+	opts := core.RunOptions{
+		FromStep:     fromStep,
+		Dependencies: core.DependencyMode(dependencies),
+		Only:         core.ParseStepList(only),
+		Tags:         core.ParseStepList(tags),
+		SkipTags:     core.ParseStepList(skipTags),
+		Platform:     platform,
+		OnPlanChange: core.PlanChangeMode(onPlanChange),
+	}
+
 	if dryRun {
 		fmt.Println("\n🔍 DRY RUN MODE - No changes will be made")
-		showExecutionPlan(plan)
-		return nil
+		return showExecutionPlan(plan, opts, dryRunState(plan, planFile))
 	}
 
 	// Create state file path
-	dir := filepath.Dir(planFile)
-	stateFile := filepath.Join(dir, ".plexr_state.json")
+	stateFile, err := stateFilePath(planFile)
+	if err != nil {
+		return err
+	}
+
+	// Another run of the plan would clobber the state, and a corrupted
+	// state must be recovered before running. A state of another plan
+	// version stops the run before asking to confirm it, unless
+	// --on-plan-change tells the runner what to do with it.
+	if err := prepareState(plan, stateFile, opts.OnPlanChange == ""); err != nil {
+		return err
+	}
 
 	// Create runner
 	runner, err := core.NewRunner(plan, stateFile)
 	if err != nil {
 		return fmt.Errorf("failed to create runner: %w", err)
 	}
+	defer runner.Close()
+	runner.SetMaxParallel(parallel)
+	if err := runner.SetOptions(opts); err != nil {
+		return fmt.Errorf("invalid execution options: %w", err)
+	}
 
 	// Create display
 	displayMode := display.ModeSimple
@@ -121,33 +191,111 @@ func runExecute(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Completed steps that changed but are not run again
+	var changed []string
+
 	// Set up progress callback
 	runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+		fields, _ := data.(map[string]interface{})
 		switch event {
 		case "started":
 			if err := tracker.StepStarted(stepID); err != nil && IsVerbose() {
 				fmt.Printf("Warning: failed to update step started: %v\n", err)
 			}
 		case "completed":
-			if duration, ok := data.(time.Duration); ok {
-				if err := tracker.StepCompleted(stepID, duration); err != nil && IsVerbose() {
-					fmt.Printf("Warning: failed to update step completed: %v\n", err)
-				}
+			duration, _ := fields["duration"].(time.Duration)
+			if err := tracker.StepCompleted(stepID, duration); err != nil && IsVerbose() {
+				fmt.Printf("Warning: failed to update step completed: %v\n", err)
 			}
 		case "failed":
-			if err, ok := data.(error); ok {
-				if trackerErr := tracker.StepFailed(stepID, err); trackerErr != nil && IsVerbose() {
+			if message, ok := fields["error"].(string); ok {
+				if trackerErr := tracker.StepFailed(stepID, errors.New(message)); trackerErr != nil && IsVerbose() {
 					fmt.Printf("Warning: failed to update step failed: %v\n", trackerErr)
 				}
 			}
+		case "failure_ignored":
+			message, _ := fields["error"].(string)
+			if err := tracker.StepFailureIgnored(stepID, errors.New(message)); err != nil && IsVerbose() {
+				fmt.Printf("Warning: failed to update step: %v\n", err)
+			}
+		case "interrupted":
+			if err := tracker.StepInterrupted(stepID); err != nil && IsVerbose() {
+				fmt.Printf("Warning: failed to update step interrupted: %v\n", err)
+			}
+		case "plan_changed":
+			change, _ := fields["change"].(string)
+			message := fmt.Sprintf("⚠️  %s", change)
+			switch core.PlanChangeMode(fmt.Sprint(fields["action"])) {
+			case core.PlanChangeReset:
+				message += "; starting over"
+			case core.PlanChangeContinue:
+				message += "; keeping its progress"
+			case core.PlanChangeNewSteps:
+				steps, _ := fields["steps"].([]string)
+				if len(steps) == 0 {
+					message += "; the plan has no new steps"
+				} else {
+					message += "; running only the new steps " + strings.Join(steps, ", ")
+				}
+			}
+			fmt.Println(message)
+		case "resuming_interrupted":
+			steps, _ := fields["steps"].([]string)
+			message := fmt.Sprintf("⚠️  The previous run was interrupted during %s", strings.Join(steps, ", "))
+			if at, ok := fields["time"].(time.Time); ok {
+				message += " at " + at.Format(time.RFC3339)
+			}
+			fmt.Println(message + "; resuming")
+		case "changed":
+			if rerun, _ := fields["rerun"].(bool); !rerun {
+				changed = append(changed, stepID)
+			} else if err := tracker.Output(stepID, "🔁 Changed since it completed, running it again\n"); err != nil && IsVerbose() {
+				fmt.Printf("Warning: failed to show output: %v\n", err)
+			}
+		case "blocked":
+			blockedBy, _ := fields["blocked_by"].(string)
+			if err := tracker.StepBlocked(stepID, blockedBy); err != nil && IsVerbose() {
+				fmt.Printf("Warning: failed to update step blocked: %v\n", err)
+			}
 		case "skipped":
-			if reason, ok := data.(string); ok {
+			if reason, ok := fields["reason"].(string); ok {
 				if err := tracker.StepSkipped(stepID, reason); err != nil && IsVerbose() {
 					fmt.Printf("Warning: failed to update step skipped: %v\n", err)
 				}
 			}
+		case "retrying":
+			file, _ := fields["file"].(string)
+			attempt, _ := fields["attempt"].(int)
+			maxAttempts, _ := fields["max_attempts"].(int)
+			delay, _ := fields["delay"].(time.Duration)
+			message, _ := fields["error"].(string)
+			if err := tracker.StepRetrying(stepID, file, attempt, maxAttempts, delay, errors.New(message)); err != nil && IsVerbose() {
+				fmt.Printf("Warning: failed to update step retrying: %v\n", err)
+			}
+		case "file_skipped":
+			if err := tracker.Output(stepID, fileSkippedMessage(fields)); err != nil && IsVerbose() {
+				fmt.Printf("Warning: failed to show output: %v\n", err)
+			}
+		case "rolling_back", "rolled_back", "rollback_failed":
+			message := map[string]string{
+				"rolling_back":    "↩️  Rolling back",
+				"rolled_back":     "↩️  Rolled back",
+				"rollback_failed": "❌ Rollback failed",
+			}[event]
+			if reason, ok := fields["error"].(string); ok {
+				message += ": " + reason
+			}
+			if err := tracker.Output(stepID, message+"\n"); err != nil && IsVerbose() {
+				fmt.Printf("Warning: failed to show output: %v\n", err)
+			}
+		case "tool_detected":
+			if IsVerbose() {
+				tool, _ := fields["tool"].(string)
+				version, _ := fields["version"].(string)
+				fmt.Printf("🛠️  Found %s %s\n", tool, version)
+			}
 		case "output":
-			if output, ok := data.(string); ok {
+			if output, ok := fields["output"].(string); ok {
 				if err := tracker.Output(stepID, output); err != nil && IsVerbose() {
 					fmt.Printf("Warning: failed to show output: %v\n", err)
 				}
@@ -171,8 +319,9 @@ func runExecute(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// Execute
-	ctx := context.Background()
+	// Execute, stopping gracefully on Ctrl-C
+	ctx, stop := withInterruptHandler(context.Background())
+	defer stop()
 	fmt.Println("\n🚀 Starting execution...")
 
 	// Start tracking
@@ -188,42 +337,237 @@ func runExecute(cmd *cobra.Command, args []string) error {
 	if err := tracker.Finish(success); err != nil {
 		return fmt.Errorf("failed to finish tracking: %w", err)
 	}
+	if len(changed) > 0 {
+		fmt.Println(colorize(colorYellow, fmt.Sprintf("⚠️  Completed steps changed since they ran: %s", strings.Join(changed, ", "))))
+		fmt.Println(colorize(colorYellow, fmt.Sprintf("   Set rerun_on_change in the plan, or run 'plexr reset %s --step %s' to run them again", planFile, strings.Join(changed, ","))))
+	}
+	if runID := runner.RunID(); runID != "" {
+		show := fmt.Sprintf("plexr history show %s %s", planFile, runID)
+		if stateFileFlag != "" {
+			show += " --state-file " + stateFileFlag
+		}
+		fmt.Println(colorize(colorGray, fmt.Sprintf("📜 Run %s recorded in the run history (%s)", runID, show)))
+	}
 
 	if err != nil {
-		return fmt.Errorf("execution failed: %w", err)
+		var lockedErr *core.LockedError
+		var corrupt *core.CorruptStateError
+		if errors.As(err, &lockedErr) || errors.As(err, &corrupt) {
+			return stateError(lockError(err))
+		}
+		var change *core.PlanChangedError
+		if errors.As(err, &change) {
+			return planChangeError(err)
+		}
+		var runErr *core.RunError
+		if errors.As(err, &runErr) && runErr.WasInterrupted() {
+			fmt.Println("Execution interrupted. Run the same command again to resume.")
+			return &exitError{code: exitInterrupted, err: err}
+		}
+		if errors.As(err, &runErr) && !runErr.Aborted() {
+			return &exitError{code: exitPartialFailure, err: fmt.Errorf("execution completed with failures: %w", err)}
+		}
+		return &exitError{code: exitExecutionFailed, err: fmt.Errorf("execution failed: %w", err)}
 	}
 
 	return nil
 }
 
-func showExecutionPlan(plan *config.ExecutionPlan) {
+// fileSkippedMessage describes a file_skipped event: files of another
+// platform name the platform, files skipped by skip_if their condition
+func fileSkippedMessage(fields map[string]interface{}) string {
+	file, _ := fields["file"].(string)
+	if reason, _ := fields["reason"].(string); reason == "platform" {
+		platform, _ := fields["platform"].(string)
+		return fmt.Sprintf("Skipped %s (platform: %s)\n", file, platform)
+	}
+	condition, _ := fields["condition"].(string)
+	return fmt.Sprintf("Skipped %s (skip_if: %s)\n", file, condition)
+}
+
+// formatRequires formats tool requirements as "go >=1.21, psql"
+func formatRequires(requires map[string]config.ToolRequirement) string {
+	tools := make([]string, 0, len(requires))
+	for tool := range requires {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+
+	for i, tool := range tools {
+		if constraint := requires[tool].Version; constraint != "" && constraint != "*" {
+			tools[i] = tool + " " + constraint
+		}
+	}
+	return strings.Join(tools, ", ")
+}
+
+// dryRunState returns the state of a plan for showing which dependencies a
+// run would miss, an empty state if the plan has not run yet, or nil if the
+// state cannot be read without secrets or a database connection
+func dryRunState(plan *config.ExecutionPlan, planFile string) *core.ExecutionState {
+	if plan.State.Backend != "" && plan.State.Backend != "file" {
+		return nil
+	}
+	stateFile, err := stateFilePath(planFile)
+	if err != nil {
+		return nil
+	}
+	sm, err := core.NewStateManager(stateFile)
+	if err != nil {
+		return nil
+	}
+	state, err := sm.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return &core.ExecutionState{}
+	}
+	if err != nil {
+		return nil
+	}
+	return state
+}
+
+// showExecutionPlan prints the steps a run would execute. With the state of
+// the plan, it also flags dependencies that are neither selected nor completed.
+func showExecutionPlan(plan *config.ExecutionPlan, opts core.RunOptions, state *core.ExecutionState) error {
+	selected, err := core.SelectSteps(plan, opts)
+	if err != nil {
+		return fmt.Errorf("failed to select steps: %w", err)
+	}
+
+	targetPlatform := opts.Platform
+	if targetPlatform == "" {
+		targetPlatform = runtime.GOOS
+	}
+	fmt.Printf("\n🖥️  Platform: %s\n", targetPlatform)
+	if len(opts.Tags) > 0 {
+		fmt.Printf("🏷️  Tags: %s\n", strings.Join(opts.Tags, ", "))
+	}
+	if len(opts.SkipTags) > 0 {
+		fmt.Printf("🚫 Skipped tags: %s\n", strings.Join(opts.SkipTags, ", "))
+	}
+	if len(plan.Requires) > 0 {
+		fmt.Printf("🛠️  Requires: %s\n", formatRequires(plan.Requires))
+	}
+	if len(selected) < len(plan.Steps) {
+		fmt.Printf("🎯 %d of %d steps selected\n", len(selected), len(plan.Steps))
+		if deps := core.UnselectedDependencies(plan, selected); len(deps) > 0 {
+			mode := "assumed completed"
+			if opts.Dependencies == core.DependenciesEnforce {
+				mode = "must already be completed"
+			}
+			fmt.Printf("⏭️  Dependencies not selected (%s): %s\n", mode, strings.Join(deps, ", "))
+		}
+	}
+	unmet := make(map[string]bool)
+	if state != nil {
+		if deps := core.UnmetDependencies(plan, selected, state); len(deps) > 0 {
+			consequence := "the steps depending on them run anyway"
+			if opts.Dependencies == core.DependenciesEnforce {
+				consequence = "the run will fail"
+			}
+			fmt.Printf("⚠️  Dependencies not completed (%s): %s\n", consequence, strings.Join(deps, ", "))
+			for _, dep := range deps {
+				unmet[dep] = true
+			}
+		}
+	}
+
+	maxParallel := parallel
+	if maxParallel == 0 {
+		maxParallel = plan.MaxParallel
+	}
+	if maxParallel > 1 {
+		fmt.Printf("\n⚡ Up to %d independent steps run concurrently\n", maxParallel)
+	}
+
 	fmt.Println("\n📋 Steps to be executed:")
-	for i, step := range plan.Steps {
+	for i, stepID := range selected {
+		step := findPlanStep(plan, stepID)
 		fmt.Printf("\n%d. %s", i+1, step.ID)
 		if step.Description != "" {
 			fmt.Printf(" - %s", step.Description)
 		}
+		if selectedAsDependency(step, opts) {
+			fmt.Printf(" (dependency)")
+		}
 		fmt.Println()
 
+		if len(step.Tags) > 0 {
+			fmt.Printf("   Tags: %s\n", strings.Join(step.Tags, ", "))
+		}
+
 		if len(step.DependsOn) > 0 {
-			fmt.Printf("   Dependencies: %v\n", step.DependsOn)
+			deps := make([]string, len(step.DependsOn))
+			for i, dep := range step.DependsOn {
+				deps[i] = dep
+				if unmet[dep] {
+					deps[i] += " (not completed)"
+				}
+			}
+			fmt.Printf("   Dependencies: %v\n", deps)
+		}
+
+		if len(step.Requires) > 0 {
+			fmt.Printf("   Requires: %s\n", formatRequires(step.Requires))
 		}
 
 		if step.SkipIf != "" {
 			fmt.Printf("   Skip if: %s\n", step.SkipIf)
 		}
 
+		if step.CheckCommand != "" {
+			fmt.Printf("   Check: %s", step.CheckCommand)
+			if step.CheckAfter {
+				fmt.Printf(" (verified after execution)")
+			}
+			fmt.Println()
+		}
+
 		fmt.Printf("   Executor: %s\n", step.Executor)
 		fmt.Printf("   Files:\n")
 		for _, file := range step.Files {
 			fmt.Printf("     - %s", file.Path)
 			if file.Platform != "" {
 				fmt.Printf(" (platform: %s)", file.Platform)
+				if file.Platform != targetPlatform {
+					fmt.Printf(" [skipped]")
+				}
 			}
 			if file.Timeout > 0 {
 				fmt.Printf(" (timeout: %ds)", file.Timeout)
 			}
 			fmt.Println()
 		}
+		if len(step.Rollback) > 0 {
+			fmt.Printf("   Rollback:\n")
+			for _, file := range step.Rollback {
+				fmt.Printf("     - %s\n", file.Path)
+			}
+		}
 	}
+	return nil
+}
+
+// selectedAsDependency reports whether a selected step only runs because
+// steps chosen with --only or --tags depend on it
+func selectedAsDependency(step *config.Step, opts core.RunOptions) bool {
+	if len(opts.Only) > 0 {
+		for _, id := range opts.Only {
+			if id == step.ID {
+				return len(opts.Tags) > 0 && !step.HasTag(opts.Tags...)
+			}
+		}
+		return true
+	}
+	return len(opts.Tags) > 0 && !step.HasTag(opts.Tags...)
+}
+
+// findPlanStep finds a step of a plan by ID
+func findPlanStep(plan *config.ExecutionPlan, id string) *config.Step {
+	for i := range plan.Steps {
+		if plan.Steps[i].ID == id {
+			return &plan.Steps[i]
+		}
+	}
+	return nil
 }
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SphereStacking/plexr/internal/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutePlanChange(t *testing.T) {
+	// setup writes version 2.0.0 of a plan whose state was recorded for 1.0.0
+	setup := func(t *testing.T) (string, string) {
+		t.Helper()
+		dir := t.TempDir()
+		planFile := filepath.Join(dir, "plan.yml")
+		require.NoError(t, os.WriteFile(planFile, []byte(`
+name: "Test Plan"
+version: "2.0.0"
+executors:
+  shell:
+    type: shell
+steps:
+  - id: install
+    executor: shell
+    files:
+      - path: install.sh
+`), 0600)) // #nosec G306 - Test file
+
+		stateFile := filepath.Join(dir, "state.json")
+		sm, err := core.NewStateManager(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, sm.Save(&core.ExecutionState{SetupName: "Test Plan", SetupVersion: "1.0.0"}))
+
+		previous := stateFileFlag
+		stateFileFlag = stateFile
+		t.Cleanup(func() { stateFileFlag = previous })
+		return planFile, stateFile
+	}
+
+	t.Run("Stops before asking to confirm the run", func(t *testing.T) {
+		planFile, stateFile := setup(t)
+
+		err := runExecute(executeCmd, []string{planFile})
+		var exitErr *exitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, exitPlanChanged, exitErr.code)
+		var change *core.PlanChangedError
+		assert.ErrorAs(t, err, &change)
+		assert.Contains(t, err.Error(), "--on-plan-change")
+
+		// The run did not start, so it is not in the history
+		_, err = os.Stat(core.HistoryPath(stateFile))
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+	})
+
+	t.Run("Is left to the runner with --on-plan-change", func(t *testing.T) {
+		planFile, stateFile := setup(t)
+		plan, err := loadPlan(planFile, false)
+		require.NoError(t, err)
+
+		assert.NoError(t, prepareState(plan, stateFile, false))
+		assert.Error(t, prepareState(plan, stateFile, true))
+	})
+}
+
+func TestFileSkippedMessage(t *testing.T) {
+	assert.Equal(t, "Skipped install.ps1 (platform: windows)\n", fileSkippedMessage(map[string]interface{}{
+		"file": "install.ps1", "reason": "platform", "platform": "windows",
+	}))
+	assert.Equal(t, "Skipped seed.sql (skip_if: env.CI == \"true\")\n", fileSkippedMessage(map[string]interface{}{
+		"file": "seed.sql", "reason": "skip_if_condition", "condition": "env.CI == \"true\"",
+	}))
+}
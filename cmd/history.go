@@ -0,0 +1,234 @@
+/*
+Copyright © 2025 Plexr Authors
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SphereStacking/plexr/internal/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// History command flags
+	historyJSON  bool
+	historyLimit int
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history <plan.yml>",
+	Short: "List past runs of a plan",
+	Long: `List the runs of a setup plan recorded in its run history, newest first.
+
+Every execution of the plan appends a record to the history journal next to
+the state file, with the run ID, plan version, selected steps, the result and
+duration of every step and the outcome of the run. The journal is append-only
+and is kept when the state is reset.
+
+Use 'plexr history show <plan.yml> <run-id>' to inspect a single run.`,
+	Example: `  # List the runs of a plan
+  plexr history plan.yml
+
+  # List the last 5 runs as JSON
+  plexr history plan.yml --limit 5 --json
+
+  # Show the steps of a run (a unique prefix of the run ID is enough)
+  plexr history show plan.yml 20250101T100000`,
+	Args: cobra.ExactArgs(1),
+	RunE: runHistory,
+}
+
+// historyShowCmd represents the history show command
+var historyShowCmd = &cobra.Command{
+	Use:   "show <plan.yml> <run-id>",
+	Short: "Show the details of a past run",
+	Long: `Show the details of a run recorded in the run history of a setup plan:
+when it ran, its outcome and the status, duration, attempts and errors of
+every selected step. The run ID may be shortened to any unique prefix.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runHistoryShow,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyShowCmd)
+
+	addStateFileFlag(historyCmd)
+	historyCmd.PersistentFlags().BoolVar(&historyJSON, "json", false, "Print the run records as JSON")
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 0, "Show only the given number of most recent runs")
+}
+
+// readHistory reads the run history of a plan, newest first
+func readHistory(planFile string) ([]core.RunRecord, error) {
+	stateFile, err := stateFilePath(planFile)
+	if err != nil {
+		return nil, err
+	}
+	records, err := core.ReadHistory(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	records, err := readHistory(args[0])
+	if err != nil {
+		return err
+	}
+	if historyLimit > 0 && len(records) > historyLimit {
+		records = records[:historyLimit]
+	}
+
+	if historyJSON {
+		if records == nil {
+			records = []core.RunRecord{}
+		}
+		return printJSON(records)
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No run history found. The plan has not been executed yet.")
+		return nil
+	}
+
+	fmt.Printf("📜 Run History (%d runs):\n\n", len(records))
+	for _, record := range records {
+		fmt.Printf("   %s  %s  %-8s  %s  %s\n",
+			colorize(colorCyan, record.ID),
+			record.StartedAt.Local().Format("2006-01-02 15:04:05"),
+			formatRunDuration(record.Duration),
+			colorize(outcomeColor(record.Outcome), fmt.Sprintf("%-11s", record.Outcome)),
+			colorize(colorGray, summarizeRunSteps(record.Steps)))
+	}
+	return nil
+}
+
+func runHistoryShow(cmd *cobra.Command, args []string) error {
+	records, err := readHistory(args[0])
+	if err != nil {
+		return err
+	}
+	record, err := core.FindRun(records, args[1])
+	if err != nil {
+		return err
+	}
+
+	if historyJSON {
+		return printJSON(record)
+	}
+
+	fmt.Printf("📜 Run %s\n", colorize(colorCyan, record.ID))
+	fmt.Printf("📋 Setup: %s %s\n", colorize(colorCyan, record.PlanName), colorize(colorGray, fmt.Sprintf("(v%s)", record.PlanVersion)))
+	fmt.Printf("🖥️  Platform: %s\n", colorize(colorBlue, record.Platform))
+	fmt.Printf("🕒 Started: %s\n", colorize(colorGray, record.StartedAt.Format(time.RFC3339)))
+	fmt.Printf("⏱️  Duration: %s\n", formatRunDuration(record.Duration))
+	fmt.Printf("🏁 Outcome: %s\n", colorize(outcomeColor(record.Outcome), record.Outcome))
+	if record.Error != "" {
+		fmt.Printf("   %s\n", colorize(colorRed, record.Error))
+	}
+
+	if len(record.Steps) == 0 {
+		return nil
+	}
+	fmt.Printf("\n📝 Steps:\n")
+	for _, step := range record.Steps {
+		icon := "⏸️ "
+		color := colorGray
+		switch step.Status {
+		case core.StatusCompleted:
+			icon, color = "✅", colorGreen
+		case core.StatusAlreadyCompleted:
+			icon = "✔️ "
+		case core.StatusFailed:
+			icon, color = "❌", colorRed
+		case core.StatusInterrupted, core.StatusBlocked:
+			icon, color = "⛔", colorYellow
+		case core.StatusSkipped:
+			icon = "⏭️ "
+		case core.StatusRolledBack:
+			icon, color = "↩️ ", colorYellow
+		}
+
+		var details []string
+		if step.Reason != "" {
+			details = append(details, step.Reason)
+		}
+		if step.Status != core.StatusAlreadyCompleted && step.Status != core.StatusBlocked &&
+			step.Status != core.StatusNotRun && step.Status != core.StatusSkipped {
+			details = append(details, formatRunDuration(step.Duration))
+		}
+		if step.Attempts > 1 {
+			details = append(details, fmt.Sprintf("%d attempts", step.Attempts))
+		}
+		line := fmt.Sprintf("   %s %s %s", icon, step.ID, colorize(color, step.Status))
+		if len(details) > 0 {
+			line += " " + colorize(colorGray, "("+strings.Join(details, ", ")+")")
+		}
+		fmt.Println(line)
+		if step.Error != "" {
+			fmt.Printf("        %s\n", colorize(colorRed, step.Error))
+		}
+	}
+	return nil
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// formatRunDuration formats a duration in milliseconds
+func formatRunDuration(ms int64) string {
+	d := time.Duration(ms) * time.Millisecond
+	if d >= time.Second {
+		d = d.Round(100 * time.Millisecond)
+	}
+	return d.String()
+}
+
+// outcomeColor returns the color of a run outcome
+func outcomeColor(outcome string) string {
+	switch outcome {
+	case core.OutcomeSucceeded:
+		return colorGreen
+	case core.OutcomeFailed:
+		return colorRed
+	default:
+		return colorYellow
+	}
+}
+
+// summarizeRunSteps counts the steps of a run by status
+func summarizeRunSteps(steps []core.RunStepRecord) string {
+	var order []string
+	counts := make(map[string]int)
+	for _, step := range steps {
+		if counts[step.Status] == 0 {
+			order = append(order, step.Status)
+		}
+		counts[step.Status]++
+	}
+
+	parts := make([]string, 0, len(order))
+	for _, status := range order {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[status], strings.ReplaceAll(status, "_", " ")))
+	}
+	if len(parts) == 0 {
+		return "no steps"
+	}
+	return strings.Join(parts, ", ")
+}
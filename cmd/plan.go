@@ -0,0 +1,43 @@
+/*
+Copyright © 2025 Plexr Authors
+*/
+package cmd
+
+import (
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Plan loading flags shared by commands that load a plan
+	planVars     []string
+	planVarFiles []string
+	planEnvFiles []string
+)
+
+// addPlanFlags registers the --var, --var-file and --env-file flags on a command
+func addPlanFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&planVars, "var", nil, "Set a plan variable (NAME=VALUE, repeatable)")
+	cmd.Flags().StringArrayVar(&planVarFiles, "var-file", nil, "Load plan variables from a YAML file (repeatable)")
+	cmd.Flags().StringArrayVar(&planEnvFiles, "env-file", nil, "Load environment variables from a .env file (repeatable)")
+}
+
+// loadPlan loads an execution plan with the variables and env files given on
+// the command line. Secrets are only resolved when resolveSecrets is set.
+func loadPlan(path string, resolveSecrets bool) (*config.ExecutionPlan, error) {
+	opts := config.LoadOptions{
+		VarFiles:       planVarFiles,
+		Vars:           make(map[string]string, len(planVars)),
+		EnvFiles:       planEnvFiles,
+		ResolveSecrets: resolveSecrets,
+	}
+	for _, assignment := range planVars {
+		name, value, err := config.ParseVar(assignment)
+		if err != nil {
+			return nil, err
+		}
+		opts.Vars[name] = value
+	}
+
+	return config.LoadExecutionPlanWithOptions(path, opts)
+}
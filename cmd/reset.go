@@ -4,17 +4,24 @@ Copyright © 2025 Plexr Authors
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"strings"
+	"time"
 
+	"github.com/SphereStacking/plexr/internal/config"
 	"github.com/SphereStacking/plexr/internal/core"
 	"github.com/spf13/cobra"
 )
 
 var (
 	// Reset command flags
-	resetAuto bool
+	resetAuto          bool
+	resetRestoreBackup bool
+	resetSteps         string
+	resetCascade       bool
+	resetFailed        bool
 )
 
 // resetCmd represents the reset command
@@ -27,12 +34,32 @@ This command removes all execution history, including:
 - Completed steps tracking
 - Failed files
 - Current step information
-- Installed tools tracking`,
+- Installed tools tracking
+
+The removed state is kept as the backup of the state file; a corrupted
+state is moved aside instead, so the backup survives. With --restore-backup
+the state is replaced by its backup instead, which holds the state the last
+run started from. This recovers a corrupted state file or undoes a reset.
+The run history is kept; see 'plexr history'.
+
+With --step only the given steps are reset, so that the next run executes
+them again; --cascade also resets every step that depends on them. With
+--failed only the failures and interruptions of earlier runs are cleared,
+and completed steps stay completed.`,
 	Example: `  # Reset a plan's execution state
   plexr reset plan.yml
 
   # Reset without confirmation prompt
-  plexr reset plan.yml --auto`,
+  plexr reset plan.yml --auto
+
+  # Run two steps and everything that depends on them again
+  plexr reset plan.yml --step build,migrate --cascade
+
+  # Forget failed attempts but keep completed steps
+  plexr reset plan.yml --failed
+
+  # Recover a corrupted state file from its backup
+  plexr reset plan.yml --restore-backup`,
 	Args: cobra.ExactArgs(1),
 	RunE: runReset,
 }
@@ -41,44 +68,234 @@ func init() {
 	rootCmd.AddCommand(resetCmd)
 
 	resetCmd.Flags().BoolVarP(&resetAuto, "auto", "a", false, "Skip confirmation prompt")
+	resetCmd.Flags().BoolVar(&resetRestoreBackup, "restore-backup", false, "Replace the state with its backup instead of removing it")
+	resetCmd.Flags().StringVar(&resetSteps, "step", "", "Reset only these comma-separated steps")
+	resetCmd.Flags().BoolVar(&resetCascade, "cascade", false, "With --step, also reset the steps that depend on them")
+	resetCmd.Flags().BoolVar(&resetFailed, "failed", false, "Clear only the failure records, keeping completed steps")
+	addLockFlags(resetCmd)
+	addStateFileFlag(resetCmd)
 }
 
 func runReset(cmd *cobra.Command, args []string) error {
 	planFile := args[0]
-	dir := filepath.Dir(planFile)
-	stateFile := filepath.Join(dir, ".plexr_state.json")
 
-	// Check if state file exists
-	if _, err := os.Stat(stateFile); os.IsNotExist(err) {
-		fmt.Println("❌ No execution state found. Nothing to reset.")
-		return nil
+	modes := 0
+	for _, set := range []bool{resetRestoreBackup, resetSteps != "", resetFailed} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return fmt.Errorf("--restore-backup, --step and --failed cannot be combined")
+	}
+	if resetCascade && resetSteps == "" {
+		return fmt.Errorf("--cascade requires --step")
 	}
 
-	// Confirm reset
-	if !resetAuto {
-		fmt.Print("⚠️  This will reset all execution state. Continue? [y/N]: ")
-		var response string
-		if _, err := fmt.Scanln(&response); err != nil {
-			fmt.Printf("\nFailed to read input: %v\n", err)
-			return err
-		}
-		if response != "y" && response != "Y" {
-			fmt.Println("Reset canceled.")
+	stateFile, err := stateFilePath(planFile)
+	if err != nil {
+		return err
+	}
+
+	plan, err := loadStatePlan(planFile)
+	if err != nil {
+		return err
+	}
+	sm, err := openStateManager(plan, stateFile)
+	if err != nil {
+		return err
+	}
+	defer sm.Close()
+
+	switch {
+	case resetRestoreBackup:
+		return restoreStateBackup(sm, stateFile)
+	case resetSteps != "":
+		return resetStateSteps(sm, plan, stateFile)
+	case resetFailed:
+		return clearStateFailures(sm, stateFile)
+	}
+
+	// Check if there is a state; a corrupted state, or one written by a
+	// newer version, can be reset
+	if _, err := sm.Load(); err != nil {
+		var corrupt *core.CorruptStateError
+		var versionErr *core.StateVersionError
+		if errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("❌ No execution state found. Nothing to reset.")
 			return nil
 		}
+		if !errors.As(err, &corrupt) && !errors.As(err, &versionErr) {
+			return fmt.Errorf("failed to load state: %w", err)
+		}
+	}
+
+	// The state of a running plan cannot be reset
+	if err := prepareStateLock(stateFile); err != nil {
+		return err
+	}
+
+	if ok, err := confirmReset("This will reset all execution state.", "Reset canceled."); !ok {
+		return err
 	}
 
 	// Reset state
-	sm, err := core.NewStateManager(stateFile)
+	if err := withStateLock(sm, stateFile, sm.Reset); err != nil {
+		return fmt.Errorf("failed to reset state: %w", err)
+	}
+
+	fmt.Println("✅ Execution state has been reset.")
+	return nil
+}
+
+// resetStateSteps resets the steps given with --step and, with --cascade,
+// the steps that depend on them
+func resetStateSteps(sm *core.StateManager, plan *config.ExecutionPlan, stateFile string) error {
+	stepIDs := core.ParseStepList(resetSteps)
+	for _, id := range stepIDs {
+		if findPlanStep(plan, id) == nil {
+			return fmt.Errorf("step not found: %s", id)
+		}
+	}
+	if resetCascade {
+		var err error
+		if stepIDs, err = core.WithDependents(plan, stepIDs); err != nil {
+			return err
+		}
+	}
+
+	if err := loadResetState(sm); err != nil {
+		return err
+	}
+	if err := prepareStateLock(stateFile); err != nil {
+		return err
+	}
+
+	if ok, err := confirmReset(fmt.Sprintf("This will reset the steps %s.", strings.Join(stepIDs, ", ")), "Reset canceled."); !ok {
+		return err
+	}
+
+	err := withStateLock(sm, stateFile, func() error {
+		// Another run may have changed the state in the meantime
+		if err := loadResetState(sm); err != nil {
+			return err
+		}
+		return sm.ResetSteps(stepIDs)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create state manager: %w", err)
+		return fmt.Errorf("failed to reset steps: %w", err)
+	}
+
+	fmt.Printf("✅ Reset %d step(s): %s\n", len(stepIDs), strings.Join(stepIDs, ", "))
+	return nil
+}
+
+// clearStateFailures clears the failure records of the state
+func clearStateFailures(sm *core.StateManager, stateFile string) error {
+	if err := loadResetState(sm); err != nil {
+		return err
+	}
+	if err := prepareStateLock(stateFile); err != nil {
+		return err
+	}
+
+	if ok, err := confirmReset("This will clear the failure records of the execution state.", "Reset canceled."); !ok {
+		return err
 	}
 
-	err = sm.Reset()
+	var cleared []string
+	err := withStateLock(sm, stateFile, func() error {
+		if err := loadResetState(sm); err != nil {
+			return err
+		}
+		var err error
+		cleared, err = sm.ClearFailures()
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to reset state: %w", err)
+		return fmt.Errorf("failed to clear failures: %w", err)
 	}
 
-	fmt.Println("✅ Execution state has been reset.")
+	if len(cleared) == 0 {
+		fmt.Println("✅ No failure records found.")
+		return nil
+	}
+	fmt.Printf("✅ Cleared the failure records of %d step(s): %s\n", len(cleared), strings.Join(cleared, ", "))
+	return nil
+}
+
+// loadResetState loads the state for a partial reset, which requires an
+// existing and readable state
+func loadResetState(sm *core.StateManager) error {
+	_, err := sm.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no execution state found")
+	}
+	if err != nil {
+		return stateError(fmt.Errorf("failed to load state: %w", err))
+	}
+	return nil
+}
+
+// confirmReset asks for confirmation unless --auto is given, printing
+// canceled when the user declines
+func confirmReset(prompt, canceled string) (bool, error) {
+	if resetAuto {
+		return true, nil
+	}
+
+	fmt.Printf("⚠️  %s Continue? [y/N]: ", prompt)
+	var response string
+	if _, err := fmt.Scanln(&response); err != nil {
+		fmt.Printf("\nFailed to read input: %v\n", err)
+		return false, err
+	}
+	if response != "y" && response != "Y" {
+		fmt.Println(canceled)
+		return false, nil
+	}
+	return true, nil
+}
+
+// withStateLock runs fn while holding the lock of the state
+func withStateLock(sm *core.StateManager, stateFile string, fn func() error) error {
+	lock, err := sm.Lock(stateFile)
+	if err != nil {
+		return lockError(err)
+	}
+	err = fn()
+	if releaseErr := lock.Release(); releaseErr != nil && err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+// restoreStateBackup replaces the state with its backup
+func restoreStateBackup(sm *core.StateManager, stateFile string) error {
+	if _, err := sm.Backup(); errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("❌ No state backup found. Nothing to restore.")
+		return nil
+	}
+
+	if err := prepareStateLock(stateFile); err != nil {
+		return err
+	}
+
+	if ok, err := confirmReset("This will replace the execution state with its backup.", "Restore canceled."); !ok {
+		return err
+	}
+
+	var state *core.ExecutionState
+	err := withStateLock(sm, stateFile, func() error {
+		var err error
+		state, err = sm.RestoreBackup()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore state: %w", err)
+	}
+
+	fmt.Printf("✅ Execution state has been restored from its backup (%d steps completed, last updated %s).\n",
+		len(state.CompletedSteps), state.UpdatedAt.Format(time.RFC3339))
 	return nil
 }
@@ -16,9 +16,11 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/SphereStacking/plexr/internal/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -54,16 +56,48 @@ outdated setup documentation.`,
 	},
 }
 
+// Exit codes returned by plexr
+const (
+	exitGeneralError    = 1
+	exitExecutionFailed = 4 // A step failed and aborted the run
+	exitStateCorrupted  = 5 // The state cannot be parsed
+	exitPartialFailure  = 6 // Steps failed with on_failure: continue, independent steps completed
+	exitDriftDetected   = 7 // Completed steps are no longer satisfied (plexr verify)
+	exitPlanChanged     = 8 // The state was recorded for another plan name or version
+	exitInterrupted     = 130
+)
+
+// exitError is an error that terminates plexr with a specific exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+		os.Exit(exitGeneralError)
 	}
 }
 
 func init() {
+	// Errors may quote script output, which can contain secrets
+	rootCmd.SetErr(utils.NewMaskingWriter(os.Stderr))
+
 	// Global flags
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
 }
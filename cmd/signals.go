@@ -0,0 +1,45 @@
+/*
+Copyright © 2025 Plexr Authors
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// withInterruptHandler returns a context that is canceled on the first SIGINT
+// or SIGTERM. A second signal exits immediately. The returned function stops
+// listening for signals.
+func withInterruptHandler(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Printf("\n⚠️  Received %s, stopping running steps... (press Ctrl-C again to force quit)\n", sig)
+			cancel()
+		case <-done:
+			return
+		}
+
+		select {
+		case <-signals:
+			fmt.Println("\n❌ Forced quit, the state file may not reflect the running steps")
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright © 2025 Plexr Authors
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Remove the lock of the state file before running
+	forceUnlock bool
+	// State file location given on the command line
+	stateFileFlag string
+)
+
+// addStateFileFlag registers the --state-file flag on a command that uses the state of a plan
+func addStateFileFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&stateFileFlag, "state-file", "s", "", "State file location (default: $PLEXR_STATE_FILE or the per-user state directory)")
+}
+
+// stateFilePath returns the state file of a plan: the --state-file flag,
+// then PLEXR_STATE_FILE, then the plan's file in the per-user state directory.
+// The lock and run history of the plan are kept next to it.
+func stateFilePath(planFile string) (string, error) {
+	if stateFileFlag != "" {
+		return stateFileFlag, nil
+	}
+	if path := os.Getenv("PLEXR_STATE_FILE"); path != "" {
+		return path, nil
+	}
+	return core.DefaultStateFile(planFile)
+}
+
+// addLockFlags registers the --force-unlock flag on a command that modifies the state
+func addLockFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&forceUnlock, "force-unlock", false, "Remove the state lock left by a run that is no longer active")
+}
+
+// prepareStateLock removes the lock of the state file when --force-unlock is
+// given, and otherwise fails early when another run holds it
+func prepareStateLock(stateFile string) error {
+	if !forceUnlock {
+		return lockError(core.CheckLock(stateFile))
+	}
+
+	holder, err := core.ForceUnlock(stateFile)
+	if err != nil {
+		return err
+	}
+	if holder != nil {
+		fmt.Printf("🔓 Removed the state lock of pid %d on %s (since %s)\n", holder.PID, holder.Host, holder.StartedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// prepareState checks that a run can use the state of a plan: it must not
+// be locked by another run and must not be corrupted. With checkPlan, it
+// must also have been recorded for the plan's name and version. A plan
+// without a state yet passes.
+func prepareState(plan *config.ExecutionPlan, stateFile string, checkPlan bool) error {
+	if err := prepareStateLock(stateFile); err != nil {
+		return err
+	}
+
+	sm, err := openStateManager(plan, stateFile)
+	if err != nil {
+		return err
+	}
+	defer sm.Close()
+
+	state, err := sm.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return stateError(err)
+	}
+	if checkPlan {
+		if change := core.DetectPlanChange(state, plan); change != nil {
+			return planChangeError(change)
+		}
+	}
+	return nil
+}
+
+// loadStatePlan loads a plan for a command that works on its state. Secrets
+// are only resolved when the state backend needs them to connect.
+func loadStatePlan(planFile string) (*config.ExecutionPlan, error) {
+	plan, err := loadPlan(planFile, false)
+	if err == nil && plan.State.Backend == "postgres" && len(plan.Secrets) > 0 {
+		plan, err = loadPlan(planFile, true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plan: %w", err)
+	}
+	return plan, nil
+}
+
+// openStateManager opens the state store selected by a plan
+func openStateManager(plan *config.ExecutionPlan, stateFile string) (*core.StateManager, error) {
+	store, err := core.OpenStateStore(plan, stateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state: %w", err)
+	}
+	return core.NewStateManagerWithStore(store), nil
+}
+
+// lockError explains how to recover from a lock left by a run that is gone
+func lockError(err error) error {
+	var lockedErr *core.LockedError
+	if errors.As(err, &lockedErr) && lockedErr.Shared {
+		return fmt.Errorf("%w\nThe lock is released when the other run ends", err)
+	}
+	if errors.As(err, &lockedErr) {
+		return fmt.Errorf("%w\nIf that process is no longer running, run again with --force-unlock", err)
+	}
+	return err
+}
+
+// planChangeError explains how to run a plan whose state was recorded for
+// another plan or plan version
+func planChangeError(err error) error {
+	var change *core.PlanChangedError
+	if !errors.As(err, &change) {
+		return err
+	}
+	err = fmt.Errorf("%w\nRun again with --on-plan-change=continue to keep the progress, --on-plan-change=new-steps to run only the steps added to the plan, or --on-plan-change=reset to start over", err)
+	return &exitError{code: exitPlanChanged, err: err}
+}
+
+// stateError explains how to recover from a corrupted state file, which
+// exits with exitStateCorrupted
+func stateError(err error) error {
+	var corrupt *core.CorruptStateError
+	if !errors.As(err, &corrupt) {
+		return err
+	}
+	if corrupt.Backup != "" {
+		err = fmt.Errorf("%w\nRun 'plexr reset <plan.yml> --restore-backup' to restore the previous state, or 'plexr reset <plan.yml>' to start over", err)
+	} else {
+		err = fmt.Errorf("%w\nRun 'plexr reset <plan.yml>' to start over", err)
+	}
+	return &exitError{code: exitStateCorrupted, err: err}
+}
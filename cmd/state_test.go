@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/SphereStacking/plexr/internal/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateError(t *testing.T) {
+	t.Run("Corrupted state exits with exitStateCorrupted", func(t *testing.T) {
+		corrupt := &core.CorruptStateError{Path: "state.json", Backup: "state.json.bak", Err: errors.New("unexpected end of JSON input")}
+		err := stateError(fmt.Errorf("failed to load state: %w", corrupt))
+
+		var exitErr *exitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, exitStateCorrupted, exitErr.code)
+		assert.ErrorAs(t, err, &corrupt)
+		assert.Contains(t, err.Error(), "--restore-backup")
+
+		corrupt.Backup = ""
+		err = stateError(corrupt)
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, exitStateCorrupted, exitErr.code)
+		assert.NotContains(t, err.Error(), "--restore-backup")
+	})
+
+	t.Run("Other errors are returned unchanged", func(t *testing.T) {
+		err := errors.New("permission denied")
+		assert.Equal(t, err, stateError(err))
+	})
+}
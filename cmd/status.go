@@ -5,13 +5,14 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/SphereStacking/plexr/internal/config"
 	"github.com/SphereStacking/plexr/internal/core"
 	"github.com/spf13/cobra"
 )
@@ -25,9 +26,11 @@ var statusCmd = &cobra.Command{
 This command shows:
 - Setup information (name, version, platform)
 - Execution timeline (start time, last update)
-- Current step being executed
-- Completed steps
+- Steps being executed
+- Completed steps, with when each step last ran, how long it took and why it failed
 - Failed files (if any)
+- Rollbacks (if any)
+- Step outputs (if any)
 - Installed tools and versions`,
 	Example: `  # Show status of a plan
   plexr status plan.yml
@@ -40,6 +43,9 @@ This command shows:
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
+
+	addPlanFlags(statusCmd)
+	addStateFileFlag(statusCmd)
 }
 
 // ANSI color codes
@@ -86,32 +92,91 @@ func createProgressBar(completed, total int, width int) string {
 	return fmt.Sprintf("%s %.1f%%", bar, percentage)
 }
 
+// formatStepRecord summarizes when and how a step last ran
+func formatStepRecord(record *core.StepRecord) string {
+	var details []string
+	switch record.Status {
+	case core.StatusSkipped:
+		details = append(details, "skipped: "+record.Reason)
+	case core.StatusRunning:
+		details = append(details, "started "+record.StartedAt.Format(time.RFC3339))
+	default:
+		details = append(details, record.Status)
+		if record.FinishedAt != nil {
+			details = append(details, record.FinishedAt.Format(time.RFC3339))
+		}
+		details = append(details, (time.Duration(record.Duration) * time.Millisecond).String())
+	}
+	if record.Attempts > 1 {
+		details = append(details, fmt.Sprintf("run %d times", record.Attempts))
+	}
+	return "(" + strings.Join(details, ", ") + ")"
+}
+
+// statusOutputLines is the number of output lines shown for a failed file
+const statusOutputLines = 5
+
+// printStepFailure prints why a step failed and the end of the output of its failed files
+func printStepFailure(record *core.StepRecord) {
+	if record.Error == "" {
+		return
+	}
+	color := colorRed
+	if record.Status == core.StatusCompleted {
+		// The failure was ignored
+		color = colorYellow
+	}
+	fmt.Printf("        %s\n", colorize(color, record.Error))
+
+	for _, file := range record.Files {
+		if file.Status != core.StatusFailed {
+			continue
+		}
+		details := fmt.Sprintf("%d attempt(s)", file.Attempts)
+		if file.ExitCode != 0 {
+			details += fmt.Sprintf(", exit code %d", file.ExitCode)
+		}
+		fmt.Printf("        %s %s\n", file.Path, colorize(colorGray, "("+details+")"))
+
+		lines := strings.Split(strings.TrimRight(file.Output, "\n"), "\n")
+		if len(lines) > statusOutputLines {
+			lines = lines[len(lines)-statusOutputLines:]
+		}
+		for _, line := range lines {
+			if line != "" {
+				fmt.Printf("          %s\n", colorize(colorGray, line))
+			}
+		}
+	}
+}
+
 func runStatus(cmd *cobra.Command, args []string) error {
 	planFile := args[0]
-	dir := filepath.Dir(planFile)
-	stateFile := filepath.Join(dir, ".plexr_state.json")
-
-	// Check if state file exists
-	if _, err := os.Stat(stateFile); os.IsNotExist(err) {
-		fmt.Println(colorize(colorRed, "❌ No execution state found. The plan has not been executed yet."))
-		return nil
+	stateFile, err := stateFilePath(planFile)
+	if err != nil {
+		return err
 	}
 
 	// Load the plan configuration
-	plan, err := config.LoadExecutionPlan(planFile)
+	plan, err := loadStatePlan(planFile)
 	if err != nil {
-		return fmt.Errorf("failed to load plan: %w", err)
+		return err
 	}
 
 	// Load state
-	sm, err := core.NewStateManager(stateFile)
+	sm, err := openStateManager(plan, stateFile)
 	if err != nil {
-		return fmt.Errorf("failed to create state manager: %w", err)
+		return err
 	}
+	defer sm.Close()
 
 	state, err := sm.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Println(colorize(colorRed, "❌ No execution state found. The plan has not been executed yet."))
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to load state: %w", err)
+		return stateError(fmt.Errorf("failed to load state: %w", err))
 	}
 
 	// Display status header
@@ -119,6 +184,9 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	fmt.Printf("🖥️  Platform: %s\n", colorize(colorBlue, state.Platform))
 	fmt.Printf("🕒 Started: %s\n", colorize(colorGray, state.StartedAt.Format(time.RFC3339)))
 	fmt.Printf("🔄 Last Updated: %s\n", colorize(colorGray, state.UpdatedAt.Format(time.RFC3339)))
+	if change := core.DetectPlanChange(state, plan); change != nil {
+		fmt.Printf("%s\n", colorize(colorYellow, fmt.Sprintf("⚠️  %s; 'plexr execute' needs --on-plan-change", change)))
+	}
 
 	// Calculate progress
 	totalSteps := len(plan.Steps)
@@ -135,16 +203,42 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		completedMap[stepID] = true
 	}
 
+	failedMap := make(map[string]bool)
+	for _, stepID := range state.FailedSteps {
+		failedMap[stepID] = true
+	}
+	interruptedMap := make(map[string]bool)
+	for _, stepID := range state.InterruptedSteps {
+		interruptedMap[stepID] = true
+	}
+	runningMap := make(map[string]bool)
+	for _, stepID := range state.RunningSteps() {
+		runningMap[stepID] = true
+	}
+
 	// Display steps with their status
 	fmt.Println("\n📝 Steps:")
 	for i, step := range plan.Steps {
 		var statusIcon, statusColor string
+		record := state.Steps[step.ID]
 
 		switch {
+		case completedMap[step.ID] && record != nil && record.Status == core.StatusSkipped:
+			statusIcon = "⏭️ "
+			statusColor = colorGray
 		case completedMap[step.ID]:
 			statusIcon = "✅"
 			statusColor = colorGreen
-		case state.CurrentStep == step.ID:
+		case interruptedMap[step.ID]:
+			statusIcon = "⚠️ "
+			statusColor = colorYellow
+		case failedMap[step.ID]:
+			statusIcon = "❌"
+			statusColor = colorRed
+		case record != nil && record.Status == core.StatusRolledBack:
+			statusIcon = "↩️ "
+			statusColor = colorYellow
+		case runningMap[step.ID]:
 			statusIcon = "⏳"
 			statusColor = colorYellow
 		default:
@@ -166,7 +260,29 @@ func runStatus(cmd *cobra.Command, args []string) error {
 			stepLine += colorize(colorGray, fmt.Sprintf(" [depends on: %s]", deps))
 		}
 
+		if record != nil {
+			stepLine += colorize(colorGray, " "+formatStepRecord(record))
+		}
+
 		fmt.Printf("%3d. %s\n", i+1, stepLine)
+		if record != nil {
+			printStepFailure(record)
+		}
+	}
+
+	// Display running steps if any
+	if running := state.RunningSteps(); len(running) > 0 {
+		fmt.Printf("\n⏳ Running: %s\n", colorize(colorYellow, strings.Join(running, ", ")))
+	}
+
+	// Display interruption if any
+	if len(state.InterruptedSteps) > 0 {
+		interrupted := fmt.Sprintf("\n⚠️  Interrupted during: %s", strings.Join(state.InterruptedSteps, ", "))
+		if state.InterruptedAt != nil {
+			interrupted += colorize(colorGray, fmt.Sprintf(" (%s)", state.InterruptedAt.Format(time.RFC3339)))
+		}
+		fmt.Println(colorize(colorYellow, interrupted))
+		fmt.Println("   Run execute again to resume.")
 	}
 
 	// Display failed files if any
@@ -177,11 +293,72 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Display retried files if any
+	if len(state.FileAttempts) > 0 {
+		files := make([]string, 0, len(state.FileAttempts))
+		for file := range state.FileAttempts {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		fmt.Printf("\n🔁 Failed Attempts:\n")
+		for _, file := range files {
+			attempts := state.FileAttempts[file]
+			last := attempts[len(attempts)-1]
+			fmt.Printf("   - %s: %s %s\n",
+				colorize(colorYellow, file),
+				colorize(colorGray, fmt.Sprintf("(%d failed)", len(attempts))),
+				last.Error)
+		}
+	}
+
+	// Display rollbacks if any
+	if len(state.Rollbacks) > 0 {
+		fmt.Printf("\n↩️  Rollbacks:\n")
+		for _, rollback := range state.Rollbacks {
+			result := colorize(colorGreen, "rolled back")
+			if !rollback.Success {
+				result = colorize(colorRed, "failed: "+rollback.Error)
+			}
+			fmt.Printf("   - %s %s → %s\n",
+				colorize(colorYellow, rollback.StepID),
+				colorize(colorGray, fmt.Sprintf("(%s, %s)", rollback.Reason, rollback.Time.Format(time.RFC3339))),
+				result)
+		}
+	}
+
+	// Display step outputs if any
+	if len(state.StepOutputs) > 0 {
+		fmt.Printf("\n📤 Step Outputs:\n")
+		for _, step := range plan.Steps {
+			outputs := state.StepOutputs[step.ID]
+			if len(outputs) == 0 {
+				continue
+			}
+			keys := make([]string, 0, len(outputs))
+			for key := range outputs {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			fmt.Printf("   - %s:\n", colorize(colorCyan, step.ID))
+			for _, key := range keys {
+				fmt.Printf("       %s = %s\n", colorize(colorBlue, key), outputs[key])
+			}
+		}
+	}
+
 	// Display installed tools if any
 	if len(state.InstalledTools) > 0 {
+		tools := make([]string, 0, len(state.InstalledTools))
+		for tool := range state.InstalledTools {
+			tools = append(tools, tool)
+		}
+		sort.Strings(tools)
+
 		fmt.Printf("\n🛠️  Installed Tools:\n")
-		for tool, version := range state.InstalledTools {
-			fmt.Printf("   - %s: %s\n", colorize(colorBlue, tool), colorize(colorGreen, version))
+		for _, tool := range tools {
+			fmt.Printf("   - %s: %s\n", colorize(colorBlue, tool), colorize(colorGreen, state.InstalledTools[tool]))
 		}
 	}
 
@@ -22,18 +22,24 @@ This command checks:
 - Required fields presence
 - Step dependencies resolution
 - Executor references
-- File paths security`,
+- File paths security
+- Template variables`,
 	Example: `  # Validate a plan file
   plexr validate plan.yml
 
   # Validate with verbose output
-  plexr validate plan.yml -v`,
+  plexr validate plan.yml -v
+
+  # Validate with plan variables
+  plexr validate plan.yml --var env=staging`,
 	Args: cobra.ExactArgs(1),
 	RunE: runValidate,
 }
 
 func init() {
 	rootCmd.AddCommand(validateCmd)
+
+	addPlanFlags(validateCmd)
 }
 
 func runValidate(cmd *cobra.Command, args []string) error {
@@ -42,7 +48,7 @@ func runValidate(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Validating execution plan: %s\n", planFile)
 
 	// Load and validate
-	plan, err := config.LoadExecutionPlan(planFile)
+	plan, err := loadPlan(planFile, false)
 	if err != nil {
 		return fmt.Errorf("❌ Validation failed: %w", err)
 	}
@@ -52,6 +58,9 @@ func runValidate(cmd *cobra.Command, args []string) error {
 	fmt.Printf("📌 Version: %s\n", plan.Version)
 	fmt.Printf("📊 Steps: %d\n", len(plan.Steps))
 	fmt.Printf("🔧 Executors: %d\n", len(plan.Executors))
+	if len(plan.Secrets) > 0 {
+		fmt.Printf("🔑 Secrets: %d (not resolved)\n", len(plan.Secrets))
+	}
 
 	// Check for warnings
 	warnings := checkWarnings(plan)
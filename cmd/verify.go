@@ -0,0 +1,197 @@
+/*
+Copyright © 2025 Plexr Authors
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Verify command flags
+	verifyOnly  string
+	verifyTags  string
+	verifyReset bool
+	verifyJSON  bool
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify <plan.yml>",
+	Short: "Check that completed steps are still satisfied",
+	Long: `Check whether the machine has drifted from the recorded state of a setup plan.
+
+The verify command re-evaluates every completed step without running it: the
+step's verify command if it has one, otherwise its check_command. A step that
+was skipped by its skip_if condition is checked against that condition. Steps
+with neither cannot be verified and are reported as such.
+
+Completed steps whose check fails have drifted. With --reset they are marked
+as not completed, so that the next 'plexr execute' runs them again. verify
+exits with code 7 when drift is detected.`,
+	Example: `  # Check all completed steps
+  plexr verify plan.yml
+
+  # Check some steps and mark the drifted ones for the next run
+  plexr verify plan.yml --only=docker,database --reset
+
+  # Report the results as JSON
+  plexr verify plan.yml --json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runVerify,
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringVarP(&verifyOnly, "only", "o", "", "Verify only specific steps (comma-separated, their dependencies are included)")
+	verifyCmd.Flags().StringVar(&verifyTags, "tags", "", "Verify only steps with any of these tags (comma-separated)")
+	verifyCmd.Flags().BoolVar(&verifyReset, "reset", false, "Mark drifted steps as not completed so the next run repairs them")
+	verifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "Print the results as JSON")
+	addPlanFlags(verifyCmd)
+	addLockFlags(verifyCmd)
+	addStateFileFlag(verifyCmd)
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	planFile := args[0]
+	stateFile, err := stateFilePath(planFile)
+	if err != nil {
+		return err
+	}
+
+	// Check commands may use secrets
+	plan, err := loadPlan(planFile, true)
+	if err != nil {
+		return fmt.Errorf("failed to load execution plan: %w", err)
+	}
+
+	// Checks are not reliable while the plan is running
+	if err := prepareState(plan, stateFile, false); err != nil {
+		return err
+	}
+
+	runner, err := core.NewRunner(plan, stateFile)
+	if err != nil {
+		return fmt.Errorf("failed to create runner: %w", err)
+	}
+	defer runner.Close()
+	if err := runner.SetOptions(core.RunOptions{Only: core.ParseStepList(verifyOnly), Tags: core.ParseStepList(verifyTags)}); err != nil {
+		return fmt.Errorf("invalid verify options: %w", err)
+	}
+
+	ctx, stop := withInterruptHandler(cmd.Context())
+	defer stop()
+	results, err := runner.Verify(ctx)
+	if errors.Is(err, fs.ErrNotExist) {
+		if verifyJSON {
+			return printJSON([]core.VerifyResult{})
+		}
+		fmt.Println(colorize(colorRed, "❌ No execution state found. The plan has not been executed yet."))
+		return nil
+	}
+	if err != nil {
+		return stateError(err)
+	}
+
+	drifted := core.DriftedSteps(results)
+	if verifyJSON {
+		if results == nil {
+			results = []core.VerifyResult{}
+		}
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	} else {
+		printVerifyResults(results)
+	}
+
+	if len(drifted) > 0 && verifyReset {
+		if err := resetDriftedSteps(plan, stateFile, drifted); err != nil {
+			return err
+		}
+		if !verifyJSON {
+			fmt.Printf("\n🔧 Marked %d step(s) as not completed: %s\n", len(drifted), strings.Join(drifted, ", "))
+			fmt.Printf("   Run 'plexr execute %s' to repair them.\n", planFile)
+		}
+	} else if len(drifted) > 0 && !verifyJSON {
+		fmt.Printf("\nRun 'plexr verify %s --reset' to run the drifted steps again on the next execution.\n", planFile)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Status == core.VerifyError {
+			failed++
+		}
+	}
+	switch {
+	case len(drifted) > 0:
+		return &exitError{code: exitDriftDetected, err: fmt.Errorf("%d completed step(s) drifted: %s", len(drifted), strings.Join(drifted, ", "))}
+	case failed > 0:
+		return fmt.Errorf("failed to verify %d step(s)", failed)
+	}
+	return nil
+}
+
+// printVerifyResults prints the result of verifying each completed step
+func printVerifyResults(results []core.VerifyResult) {
+	if len(results) == 0 {
+		fmt.Println("No completed steps to verify.")
+		return
+	}
+
+	counts := make(map[string]int)
+	fmt.Printf("🔎 Verified %d completed step(s):\n\n", len(results))
+	for _, result := range results {
+		counts[result.Status]++
+		icon, color := "✅", colorGreen
+		switch result.Status {
+		case core.VerifyDrifted:
+			icon, color = "❌", colorRed
+		case core.VerifyError:
+			icon, color = "⚠️ ", colorYellow
+		case core.VerifyUnverifiable:
+			icon, color = "➖", colorGray
+		}
+
+		line := fmt.Sprintf("   %s %s %s", icon, result.StepID, colorize(color, result.Status))
+		if result.Check != "" {
+			line += " " + colorize(colorGray, "("+result.Check+")")
+		}
+		fmt.Println(line)
+		if result.Error != "" {
+			fmt.Printf("        %s\n", colorize(colorYellow, result.Error))
+		}
+	}
+
+	fmt.Printf("\n📊 %d satisfied, %d drifted, %d unverifiable, %d error(s)\n",
+		counts[core.VerifySatisfied], counts[core.VerifyDrifted], counts[core.VerifyUnverifiable], counts[core.VerifyError])
+}
+
+// resetDriftedSteps marks drifted steps as not completed
+func resetDriftedSteps(plan *config.ExecutionPlan, stateFile string, stepIDs []string) error {
+	sm, err := openStateManager(plan, stateFile)
+	if err != nil {
+		return err
+	}
+	defer sm.Close()
+
+	err = withStateLock(sm, stateFile, func() error {
+		// Another run may have changed the state in the meantime
+		if err := loadResetState(sm); err != nil {
+			return err
+		}
+		return sm.ResetSteps(stepIDs)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset drifted steps: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/SphereStacking/plexr/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// envNamePattern matches the variable names allowed in env files
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envFileVars loads the env files listed in the env_file of a plan or step
+// mapping on top of a copy of base. The paths may reference the process
+// environment and are relative to dir. The env_file node is added to skip,
+// as it must not be expanded again.
+func envFileVars(node *yaml.Node, dir string, skip map[*yaml.Node]bool, base map[string]string) (map[string]string, error) {
+	env := make(map[string]string, len(base))
+	for name, value := range base {
+		env[name] = value
+	}
+
+	fileNode := mappingValue(node, "env_file")
+	if fileNode == nil {
+		return env, nil
+	}
+	skip[fileNode] = true
+
+	if err := rewriteValues(fileNode, nil, expandEnv); err != nil {
+		return nil, err
+	}
+	var paths EnvFiles
+	if err := fileNode.Decode(&paths); err != nil {
+		return nil, fmt.Errorf("line %d: %w", fileNode.Line, err)
+	}
+	for i, path := range paths {
+		if !filepath.IsAbs(path) {
+			paths[i] = filepath.Join(dir, path)
+		}
+	}
+
+	if err := loadEnvFiles(env, paths); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// effectiveEnv merges the variables of the plan's or a step's env files with
+// those of the command line's env files. Variables that are set in the
+// process environment are left out, as the process environment wins.
+func effectiveEnv(fileEnv, cliEnv map[string]string) map[string]string {
+	env := make(map[string]string, len(fileEnv)+len(cliEnv))
+	for _, vars := range []map[string]string{fileEnv, cliEnv} {
+		for name, value := range vars {
+			if _, ok := os.LookupEnv(name); !ok {
+				env[name] = value
+			}
+		}
+	}
+	return env
+}
+
+// loadEnvFiles reads env files into env, later files overriding earlier ones
+func loadEnvFiles(env map[string]string, paths []string) error {
+	for _, path := range paths {
+		data, err := os.ReadFile(path) // #nosec G304 - env files are chosen by the plan author or user
+		if err != nil {
+			return fmt.Errorf("failed to read env file: %w", err)
+		}
+		if err := parseEnvFile(string(data), env); err != nil {
+			return fmt.Errorf("failed to parse env file %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// parseEnvFile parses dotenv content into env. Every line assigns NAME=VALUE,
+// optionally prefixed with export; blank lines and # comments are ignored.
+// Values can be
+//
+//   - unquoted: surrounding whitespace and trailing " # comments" are removed
+//   - single quoted: taken literally
+//   - double quoted: \n, \r, \t, \" and \\ escapes are replaced
+//
+// Quoted values may span several lines. Unquoted and double quoted values
+// expand ${NAME} references to the process environment and the variables
+// assigned before them.
+func parseEnvFile(content string, env map[string]string) error {
+	lookup := func(name string) (string, bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, true
+		}
+		value, ok := env[name]
+		return value, ok
+	}
+
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if rest, ok := strings.CutPrefix(line, "export"); ok && strings.TrimLeft(rest, " \t") != rest {
+			line = strings.TrimLeft(rest, " \t")
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !envNamePattern.MatchString(name) {
+			return fmt.Errorf("line %d: expected NAME=VALUE", lineNo)
+		}
+		value = strings.TrimLeft(value, " \t")
+
+		var err error
+		if value != "" && (value[0] == '\'' || value[0] == '"') {
+			quote := value[0]
+			inner, rest, found := cutQuoted(value[1:], quote)
+			for !found && i+1 < len(lines) {
+				i++
+				value += "\n" + lines[i]
+				inner, rest, found = cutQuoted(value[1:], quote)
+			}
+			if !found {
+				return fmt.Errorf("line %d: unterminated quoted value", lineNo)
+			}
+			if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+				return fmt.Errorf("line %d: unexpected characters after quoted value", lineNo)
+			}
+
+			value = inner
+			if quote == '"' {
+				value, err = utils.ExpandEnv(unescapeEnvValue(inner), lookup)
+			}
+		} else {
+			for j := 1; j < len(value); j++ {
+				if value[j] == '#' && (value[j-1] == ' ' || value[j-1] == '\t') {
+					value = value[:j]
+					break
+				}
+			}
+			value, err = utils.ExpandEnv(strings.TrimSpace(value), lookup)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		env[name] = value
+	}
+	return nil
+}
+
+// cutQuoted splits s at the quote closing a quoted value. Double quotes can
+// be escaped with a backslash.
+func cutQuoted(s string, quote byte) (inner, rest string, found bool) {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// unescapeEnvValue replaces the escape sequences of a double quoted value
+func unescapeEnvValue(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case '"', '\\':
+			buf.WriteByte(s[i])
+		default:
+			buf.WriteByte('\\')
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String()
+}
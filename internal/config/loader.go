@@ -3,23 +3,74 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 
+	"github.com/SphereStacking/plexr/internal/expr"
+	"github.com/SphereStacking/plexr/internal/version"
 	"gopkg.in/yaml.v3"
 )
 
+// LoadOptions customizes how an execution plan is loaded
+type LoadOptions struct {
+	// VarFiles are YAML files whose variables override the plan's vars, in order
+	VarFiles []string
+	// Vars override the plan's vars and the variables of VarFiles
+	Vars map[string]string
+	// EnvFiles are dotenv files whose variables override those of the env
+	// files of the plan and its steps, in order
+	EnvFiles []string
+	// ResolveSecrets looks up the values of the plan's secrets. Otherwise
+	// secrets are rendered as a placeholder and no secret command is run.
+	ResolveSecrets bool
+}
+
 // LoadExecutionPlan loads and parses an execution plan from a YAML file
 func LoadExecutionPlan(path string) (*ExecutionPlan, error) {
+	return LoadExecutionPlanWithOptions(path, LoadOptions{})
+}
+
+// LoadExecutionPlanWithOptions loads an execution plan, rendering templates
+// and expanding environment variables in its values before decoding it.
+// Relative env files of the plan are resolved against the plan's directory.
+func LoadExecutionPlanWithOptions(path string, opts LoadOptions) (*ExecutionPlan, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	var plan ExecutionPlan
-	if err := yaml.Unmarshal(data, &plan); err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, fmt.Errorf("failed to parse YAML: %w", err)
 	}
 
+	expanded, err := expandPlan(&doc, opts, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand plan: %w", err)
+	}
+
+	var plan ExecutionPlan
+	if doc.Kind != 0 {
+		if err := doc.Decode(&plan); err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+	}
+	if len(expanded.vars) > 0 {
+		plan.Vars = expanded.vars
+	}
+	if len(expanded.env) > 0 {
+		plan.Environment = expanded.env
+	}
+	if opts.ResolveSecrets && len(expanded.secrets) > 0 {
+		plan.SecretValues = expanded.secrets
+	}
+	for i, env := range expanded.stepEnvs {
+		if i < len(plan.Steps) {
+			plan.Steps[i].Environment = env
+		}
+	}
+
 	if err := ValidateExecutionPlan(&plan); err != nil {
 		return nil, fmt.Errorf("validation failed: %w", err)
 	}
@@ -45,6 +96,30 @@ func ValidateExecutionPlan(plan *ExecutionPlan) error {
 		return fmt.Errorf("at least one step is required")
 	}
 
+	for name, source := range plan.Secrets {
+		if err := validateSecret(name, source); err != nil {
+			return err
+		}
+	}
+
+	if err := validateRequires(plan.Requires); err != nil {
+		return err
+	}
+
+	if err := validateState(plan); err != nil {
+		return err
+	}
+
+	if plan.MaxParallel < 0 {
+		return fmt.Errorf("max_parallel cannot be negative")
+	}
+
+	switch plan.RollbackScope {
+	case "", "step", "run":
+	default:
+		return fmt.Errorf("invalid rollback_scope '%s' (must be step or run)", plan.RollbackScope)
+	}
+
 	// Check for duplicate step IDs
 	stepIDs := make(map[string]bool)
 	for _, step := range plan.Steps {
@@ -57,6 +132,8 @@ func ValidateExecutionPlan(plan *ExecutionPlan) error {
 		stepIDs[step.ID] = true
 	}
 
+	scope := conditionScope(plan)
+
 	// Check that all steps reference defined executors
 	for _, step := range plan.Steps {
 
@@ -72,28 +149,28 @@ func ValidateExecutionPlan(plan *ExecutionPlan) error {
 			return fmt.Errorf("at least one file is required for step %s", step.ID)
 		}
 
-		// Validate file paths and platform values
-		validPlatforms := map[string]bool{
-			"":        true, // empty is valid (means all platforms)
-			"linux":   true,
-			"darwin":  true,
-			"windows": true,
+		if err := validateCondition(step.SkipIf, scope); err != nil {
+			return fmt.Errorf("invalid skip_if in step '%s': %w", step.ID, err)
 		}
-		for _, file := range step.Files {
-			// Validate file path
-			if file.Path == "" {
-				return fmt.Errorf("file path cannot be empty in step '%s'", step.ID)
-			}
-			if strings.HasPrefix(file.Path, "/") {
-				return fmt.Errorf("file path cannot be absolute in step '%s': %s", step.ID, file.Path)
-			}
-			if strings.Contains(file.Path, "..") {
-				return fmt.Errorf("file path cannot contain '..' in step '%s': %s", step.ID, file.Path)
+
+		if err := validateRequires(step.Requires); err != nil {
+			return fmt.Errorf("%w in step '%s'", err, step.ID)
+		}
+
+		for _, tag := range step.Tags {
+			if tag == "" || strings.ContainsAny(tag, ", \t") {
+				return fmt.Errorf("invalid tag '%s' in step '%s' (tags cannot be empty or contain commas or spaces)", tag, step.ID)
 			}
+		}
 
-			// Validate platform
-			if !validPlatforms[file.Platform] {
-				return fmt.Errorf("invalid platform '%s' in step '%s'", file.Platform, step.ID)
+		for _, file := range step.Files {
+			if err := validateFile(step.ID, file, scope); err != nil {
+				return err
+			}
+		}
+		for _, file := range step.Rollback {
+			if err := validateFile(step.ID, file, scope); err != nil {
+				return fmt.Errorf("invalid rollback: %w", err)
 			}
 		}
 
@@ -107,6 +184,21 @@ func ValidateExecutionPlan(plan *ExecutionPlan) error {
 		if !validTransactionModes[step.TransactionMode] {
 			return fmt.Errorf("invalid transaction_mode '%s' in step '%s'", step.TransactionMode, step.ID)
 		}
+
+		// Validate failure policy
+		validFailurePolicies := map[string]bool{
+			"":         true, // empty is valid (abort)
+			"abort":    true,
+			"continue": true,
+			"ignore":   true,
+		}
+		if !validFailurePolicies[step.OnFailure] {
+			return fmt.Errorf("invalid on_failure '%s' in step '%s'", step.OnFailure, step.ID)
+		}
+
+		if step.CheckAfter && step.CheckCommand == "" {
+			return fmt.Errorf("check_after requires check_command in step '%s'", step.ID)
+		}
 	}
 
 	// Check for circular dependencies
@@ -117,6 +209,146 @@ func ValidateExecutionPlan(plan *ExecutionPlan) error {
 	return nil
 }
 
+// validateFile validates the path, platform, skip_if and retry policy of a file
+func validateFile(stepID string, file FileConfig, scope expr.Scope) error {
+	validPlatforms := map[string]bool{
+		"":        true, // empty is valid (means all platforms)
+		"linux":   true,
+		"darwin":  true,
+		"windows": true,
+	}
+
+	// Validate file path
+	if file.Path == "" {
+		return fmt.Errorf("file path cannot be empty in step '%s'", stepID)
+	}
+	if strings.HasPrefix(file.Path, "/") {
+		return fmt.Errorf("file path cannot be absolute in step '%s': %s", stepID, file.Path)
+	}
+	if strings.Contains(file.Path, "..") {
+		return fmt.Errorf("file path cannot contain '..' in step '%s': %s", stepID, file.Path)
+	}
+
+	// Validate platform
+	if !validPlatforms[file.Platform] {
+		return fmt.Errorf("invalid platform '%s' in step '%s'", file.Platform, stepID)
+	}
+
+	if err := validateCondition(file.SkipIf, scope); err != nil {
+		return fmt.Errorf("invalid skip_if for file '%s' in step '%s': %w", file.Path, stepID, err)
+	}
+
+	// Validate retry policy
+	if err := validateRetry(file.Retry); err != nil {
+		return fmt.Errorf("invalid retry for file '%s' in step '%s': %w", file.Path, stepID, err)
+	}
+
+	return nil
+}
+
+// conditionScope collects the step IDs and variables skip_if expressions may refer to
+func conditionScope(plan *ExecutionPlan) expr.Scope {
+	scope := expr.Scope{
+		Steps: make(map[string]bool, len(plan.Steps)),
+		Vars:  make(map[string]bool),
+	}
+	for _, step := range plan.Steps {
+		scope.Steps[step.ID] = true
+	}
+	for name := range plan.Vars {
+		scope.Vars[name] = true
+	}
+	for _, vars := range plan.Platforms {
+		for name := range vars {
+			scope.Vars[name] = true
+		}
+	}
+	return scope
+}
+
+// validateCondition parses and type-checks a skip_if expression
+func validateCondition(condition string, scope expr.Scope) error {
+	if condition == "" {
+		return nil
+	}
+
+	e, err := expr.Parse(condition)
+	if err != nil {
+		return err
+	}
+	return e.Check(scope)
+}
+
+// validateRequires validates the version constraints of tool requirements
+func validateRequires(requires map[string]ToolRequirement) error {
+	for tool, req := range requires {
+		if tool == "" {
+			return fmt.Errorf("required tool name cannot be empty")
+		}
+		if _, err := version.ParseConstraint(req.Version); err != nil {
+			return fmt.Errorf("invalid version for required tool '%s': %w", tool, err)
+		}
+	}
+	return nil
+}
+
+// stateTablePattern matches a table name, optionally qualified by a schema
+var stateTablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// validateState validates the state backend of a plan
+func validateState(plan *ExecutionPlan) error {
+	switch plan.State.Backend {
+	case "", "file":
+		return nil
+	case "postgres":
+	default:
+		return fmt.Errorf("invalid state backend '%s' (must be file or postgres)", plan.State.Backend)
+	}
+
+	if plan.State.Executor == "" {
+		return fmt.Errorf("state backend postgres requires an executor")
+	}
+	executor, ok := plan.Executors[plan.State.Executor]
+	if !ok {
+		return fmt.Errorf("undefined executor '%s' in state", plan.State.Executor)
+	}
+	if executor["type"] != "sql" {
+		return fmt.Errorf("state executor '%s' must be a sql executor", plan.State.Executor)
+	}
+	if plan.State.Table != "" && !stateTablePattern.MatchString(plan.State.Table) {
+		return fmt.Errorf("invalid state table '%s'", plan.State.Table)
+	}
+	return nil
+}
+
+// validateRetry validates a file retry policy
+func validateRetry(retry RetryConfig) error {
+	if retry.Max < 0 {
+		return fmt.Errorf("max cannot be negative")
+	}
+
+	switch retry.Backoff {
+	case "", "fixed", "exponential":
+	default:
+		return fmt.Errorf("unknown backoff '%s' (expected fixed or exponential)", retry.Backoff)
+	}
+
+	if retry.Delay < 0 || retry.MaxDelay < 0 {
+		return fmt.Errorf("delays cannot be negative")
+	}
+	if retry.Jitter < 0 || retry.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+
+	for _, state := range retry.SQLStates {
+		if len(state) != 2 && len(state) != 5 {
+			return fmt.Errorf("sql state '%s' must be a 2 character class or a 5 character code", state)
+		}
+	}
+
+	return nil
+}
+
 // checkCircularDependencies checks for circular dependencies in steps
 func checkCircularDependencies(steps []Step) error {
 	// Build a map of step IDs to their dependencies
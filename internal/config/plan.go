@@ -1,37 +1,175 @@
 package config
 
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 // ExecutionPlan represents the top-level structure of a YAML execution plan
 type ExecutionPlan struct {
 	Name          string                       `yaml:"name"`
 	Version       string                       `yaml:"version"`
 	Description   string                       `yaml:"description"`
 	WorkDirectory string                       `yaml:"work_directory,omitempty"`
+	MaxParallel   int                          `yaml:"max_parallel,omitempty"`
+	RollbackScope string                       `yaml:"rollback_scope,omitempty"`  // step (default) or run
+	RerunOnChange bool                         `yaml:"rerun_on_change,omitempty"` // Run completed steps again when their files change, instead of warning
+	Vars          map[string]string            `yaml:"vars,omitempty"`            // Template variables, including overrides
+	EnvFile       EnvFiles                     `yaml:"env_file,omitempty"`
+	Environment   map[string]string            `yaml:"-"` // Variables of the plan's and the command line's env files
+	Secrets       map[string]SecretSource      `yaml:"secrets,omitempty"`
+	Requires      map[string]ToolRequirement   `yaml:"requires,omitempty"` // Tools checked before any step runs
+	SecretValues  map[string]string            `yaml:"-"`                  // Resolved secret values, only set when secrets are resolved
 	Platforms     map[string]map[string]string `yaml:"platforms,omitempty"`
+	State         StateConfig                  `yaml:"state,omitempty"`
 	Executors     map[string]ExecutorConfig    `yaml:"executors"`
 	Steps         []Step                       `yaml:"steps"`
 }
 
+// StateConfig selects where the execution state of the plan is stored
+type StateConfig struct {
+	Backend  string `yaml:"backend,omitempty"`  // file (default) or postgres
+	Executor string `yaml:"executor,omitempty"` // SQL executor whose connection settings the postgres backend uses
+	Table    string `yaml:"table,omitempty"`    // Table of the postgres backend (default: plexr_state)
+	Key      string `yaml:"key,omitempty"`      // Row of the plan's state in the table (default: the plan name)
+}
+
+// SecretSource describes where the value of a secret comes from. Exactly one
+// of the fields is set.
+type SecretSource struct {
+	Env     string `yaml:"env,omitempty"`     // Environment variable, including env file variables
+	File    string `yaml:"file,omitempty"`    // File, relative to the plan file
+	Command string `yaml:"command,omitempty"` // Command whose standard output is the value
+}
+
+// ToolRequirement describes a tool that must be installed. It can be written
+// either as a version constraint or as a mapping.
+type ToolRequirement struct {
+	Version string `yaml:"version,omitempty"` // Version constraint such as ">=1.21" (default: any version)
+	Command string `yaml:"command,omitempty"` // Command printing the version (default: <tool> --version, version or -version)
+}
+
+// UnmarshalYAML accepts both `go: ">=1.21"` and the full mapping form
+func (t *ToolRequirement) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var constraint string
+		if err := value.Decode(&constraint); err != nil {
+			return err
+		}
+		*t = ToolRequirement{Version: constraint}
+		return nil
+	}
+
+	type plain ToolRequirement
+	var req plain
+	if err := value.Decode(&req); err != nil {
+		return err
+	}
+	*t = ToolRequirement(req)
+	return nil
+}
+
 // ExecutorConfig represents the configuration for an executor
 type ExecutorConfig map[string]interface{}
 
 // Step represents a single execution step
 type Step struct {
-	ID              string       `yaml:"id"`
-	Description     string       `yaml:"description"`
-	Executor        string       `yaml:"executor"`
-	DependsOn       []string     `yaml:"depends_on,omitempty"`
-	SkipIf          string       `yaml:"skip_if,omitempty"`
-	CheckCommand    string       `yaml:"check_command,omitempty"`
-	WorkDirectory   string       `yaml:"work_directory,omitempty"`
-	Files           []FileConfig `yaml:"files"`
-	TransactionMode string       `yaml:"transaction_mode,omitempty"`
+	ID              string                     `yaml:"id"`
+	Description     string                     `yaml:"description"`
+	Executor        string                     `yaml:"executor"`
+	DependsOn       []string                   `yaml:"depends_on,omitempty"`
+	Tags            []string                   `yaml:"tags,omitempty"`
+	Requires        map[string]ToolRequirement `yaml:"requires,omitempty"` // Tools checked before the step runs
+	SkipIf          string                     `yaml:"skip_if,omitempty"`
+	CheckCommand    string                     `yaml:"check_command,omitempty"`
+	CheckAfter      bool                       `yaml:"check_after,omitempty"`
+	Verify          string                     `yaml:"verify,omitempty"`          // Command run by plexr verify instead of check_command
+	RerunOnChange   *bool                      `yaml:"rerun_on_change,omitempty"` // Overrides the plan's rerun_on_change
+	OnFailure       string                     `yaml:"on_failure,omitempty"`      // abort (default), continue or ignore
+	WorkDirectory   string                     `yaml:"work_directory,omitempty"`
+	EnvFile         EnvFiles                   `yaml:"env_file,omitempty"`
+	Files           []FileConfig               `yaml:"files"`
+	Rollback        []FileConfig               `yaml:"rollback,omitempty"`
+	TransactionMode string                     `yaml:"transaction_mode,omitempty"`
+
+	// Environment holds the env file variables of the step. They take
+	// precedence over the plan's Environment.
+	Environment map[string]string `yaml:"-"`
+}
+
+// HasTag reports whether the step has any of the given tags
+func (s *Step) HasTag(tags ...string) bool {
+	for _, tag := range tags {
+		for _, stepTag := range s.Tags {
+			if stepTag == tag {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // FileConfig represents the configuration for a file to be executed
 type FileConfig struct {
-	Path     string `yaml:"path"`
-	Timeout  int    `yaml:"timeout,omitempty"`
-	Retry    int    `yaml:"retry,omitempty"`
-	Platform string `yaml:"platform,omitempty"`
-	SkipIf   string `yaml:"skip_if,omitempty"`
+	Path     string      `yaml:"path"`
+	Timeout  int         `yaml:"timeout,omitempty"`
+	Retry    RetryConfig `yaml:"retry,omitempty"`
+	Platform string      `yaml:"platform,omitempty"`
+	SkipIf   string      `yaml:"skip_if,omitempty"`
+}
+
+// EnvFiles lists dotenv files. It can be written either as a single path or as a list.
+type EnvFiles []string
+
+// UnmarshalYAML accepts both `env_file: .env` and a list of files
+func (f *EnvFiles) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var path string
+		if err := value.Decode(&path); err != nil {
+			return err
+		}
+		*f = EnvFiles{path}
+		return nil
+	}
+
+	var paths []string
+	if err := value.Decode(&paths); err != nil {
+		return fmt.Errorf("env_file must be a path or a list of paths: %w", err)
+	}
+	*f = EnvFiles(paths)
+	return nil
+}
+
+// RetryConfig describes how a failed file execution is retried.
+// It can be written either as a plain number of retries or as a mapping.
+type RetryConfig struct {
+	Max       int           `yaml:"max"`                  // Number of retries after the first attempt
+	Backoff   string        `yaml:"backoff,omitempty"`    // fixed (default) or exponential
+	Delay     time.Duration `yaml:"delay,omitempty"`      // Delay before the first retry
+	MaxDelay  time.Duration `yaml:"max_delay,omitempty"`  // Upper bound for the delay
+	Jitter    float64       `yaml:"jitter,omitempty"`     // Random fraction (0-1) added to or removed from the delay
+	ExitCodes []int         `yaml:"exit_codes,omitempty"` // Shell exit codes worth retrying
+	SQLStates []string      `yaml:"sql_states,omitempty"` // SQLSTATE classes or codes worth retrying
+}
+
+// UnmarshalYAML accepts both `retry: 3` and the full mapping form
+func (r *RetryConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var retries int
+		if err := value.Decode(&retries); err != nil {
+			return fmt.Errorf("retry must be a number or a mapping: %w", err)
+		}
+		*r = RetryConfig{Max: retries}
+		return nil
+	}
+
+	type plain RetryConfig
+	var cfg plain
+	if err := value.Decode(&cfg); err != nil {
+		return err
+	}
+	*r = RetryConfig(cfg)
+	return nil
 }
@@ -3,8 +3,11 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
+	"github.com/SphereStacking/plexr/internal/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -26,6 +29,7 @@ description: |
   This is a comprehensive test plan
   with multiple lines of description
 work_directory: "/tmp/test"
+rerun_on_change: true
 
 platforms:
   darwin:
@@ -57,8 +61,10 @@ steps:
     description: "Install dependencies"
     executor: shell
     depends_on: [prepare]
-    skip_if: "test -f /tmp/skip"
+    skip_if: 'file_exists("/tmp/skip")'
     check_command: "which tool"
+    verify: "tool --version"
+    rerun_on_change: false
     files:
       - path: "install_mac.sh"
         platform: darwin
@@ -75,7 +81,7 @@ steps:
     transaction_mode: all
     files:
       - path: "configure.sh"
-        skip_if: "test -f /etc/configured"
+        skip_if: 'file_exists("/etc/configured")'
 `,
 			wantErr: false,
 			check: func(t *testing.T, plan *ExecutionPlan) {
@@ -84,6 +90,7 @@ steps:
 				assert.Equal(t, "2.1.0", plan.Version)
 				assert.Contains(t, plan.Description, "comprehensive test plan")
 				assert.Equal(t, "/tmp/test", plan.WorkDirectory)
+				assert.True(t, plan.RerunOnChange)
 
 				// Platforms
 				assert.Len(t, plan.Platforms, 2)
@@ -106,16 +113,20 @@ steps:
 				// Step 2: install
 				assert.Equal(t, "install", plan.Steps[1].ID)
 				assert.Equal(t, []string{"prepare"}, plan.Steps[1].DependsOn)
-				assert.Equal(t, "test -f /tmp/skip", plan.Steps[1].SkipIf)
+				assert.Equal(t, `file_exists("/tmp/skip")`, plan.Steps[1].SkipIf)
 				assert.Equal(t, "which tool", plan.Steps[1].CheckCommand)
+				assert.Equal(t, "tool --version", plan.Steps[1].Verify)
+				require.NotNil(t, plan.Steps[1].RerunOnChange)
+				assert.False(t, *plan.Steps[1].RerunOnChange)
+				assert.Nil(t, plan.Steps[0].RerunOnChange)
 				assert.Len(t, plan.Steps[1].Files, 2)
 				assert.Equal(t, 600, plan.Steps[1].Files[0].Timeout)
-				assert.Equal(t, 3, plan.Steps[1].Files[0].Retry)
+				assert.Equal(t, 3, plan.Steps[1].Files[0].Retry.Max)
 
 				// Step 3: configure
 				assert.Equal(t, "configure", plan.Steps[2].ID)
 				assert.Equal(t, "all", plan.Steps[2].TransactionMode)
-				assert.Equal(t, "test -f /etc/configured", plan.Steps[2].Files[0].SkipIf)
+				assert.Equal(t, `file_exists("/etc/configured")`, plan.Steps[2].Files[0].SkipIf)
 			},
 		},
 		{
@@ -532,6 +543,1035 @@ func TestValidateExecutionPlan(t *testing.T) {
 	})
 }
 
+func TestValidateMaxParallel(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxParallel int
+		wantErr     bool
+	}{
+		{"unset", 0, false},
+		{"sequential", 1, false},
+		{"parallel", 4, false},
+		{"negative", -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &ExecutionPlan{
+				Name:        "Test",
+				Version:     "1.0.0",
+				MaxParallel: tt.maxParallel,
+				Executors: map[string]ExecutorConfig{
+					"shell": {"type": "shell"},
+				},
+				Steps: []Step{
+					{
+						ID:       "test",
+						Executor: "shell",
+						Files:    []FileConfig{{Path: "test.sh"}},
+					},
+				},
+			}
+
+			err := ValidateExecutionPlan(plan)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "max_parallel")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRetryConfig(t *testing.T) {
+	t.Run("parse retry forms", func(t *testing.T) {
+		content := `
+name: "Retry"
+version: "1.0.0"
+executors:
+  shell:
+    type: shell
+steps:
+  - id: install
+    executor: shell
+    files:
+      - path: "simple.sh"
+        retry: 2
+      - path: "policy.sh"
+        retry:
+          max: 5
+          backoff: exponential
+          delay: 500ms
+          max_delay: 10s
+          jitter: 0.2
+          exit_codes: [75, 111]
+          sql_states: ["40", "08006"]
+`
+		tmpDir := t.TempDir()
+		planFile := filepath.Join(tmpDir, "plan.yml")
+		require.NoError(t, os.WriteFile(planFile, []byte(content), 0600))
+
+		plan, err := LoadExecutionPlan(planFile)
+		require.NoError(t, err)
+
+		files := plan.Steps[0].Files
+		assert.Equal(t, RetryConfig{Max: 2}, files[0].Retry)
+		assert.Equal(t, RetryConfig{
+			Max:       5,
+			Backoff:   "exponential",
+			Delay:     500 * time.Millisecond,
+			MaxDelay:  10 * time.Second,
+			Jitter:    0.2,
+			ExitCodes: []int{75, 111},
+			SQLStates: []string{"40", "08006"},
+		}, files[1].Retry)
+	})
+
+	t.Run("validate retry policy", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			retry   RetryConfig
+			wantErr string
+		}{
+			{"no retry", RetryConfig{}, ""},
+			{"valid policy", RetryConfig{Max: 3, Backoff: "fixed", Delay: time.Second, SQLStates: []string{"40"}}, ""},
+			{"negative max", RetryConfig{Max: -1}, "max cannot be negative"},
+			{"unknown backoff", RetryConfig{Max: 1, Backoff: "linear"}, "unknown backoff"},
+			{"negative delay", RetryConfig{Max: 1, Delay: -time.Second}, "delays cannot be negative"},
+			{"jitter out of range", RetryConfig{Max: 1, Jitter: 1.5}, "jitter must be between 0 and 1"},
+			{"invalid sql state", RetryConfig{Max: 1, SQLStates: []string{"400"}}, "sql state '400'"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				plan := &ExecutionPlan{
+					Name:    "Test",
+					Version: "1.0.0",
+					Executors: map[string]ExecutorConfig{
+						"shell": {"type": "shell"},
+					},
+					Steps: []Step{
+						{
+							ID:       "test",
+							Executor: "shell",
+							Files:    []FileConfig{{Path: "test.sh", Retry: tt.retry}},
+						},
+					},
+				}
+
+				err := ValidateExecutionPlan(plan)
+				if tt.wantErr == "" {
+					assert.NoError(t, err)
+				} else {
+					assert.Error(t, err)
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+			})
+		}
+	})
+}
+
+func TestValidateCheckAfter(t *testing.T) {
+	plan := &ExecutionPlan{
+		Name:    "Test",
+		Version: "1.0.0",
+		Executors: map[string]ExecutorConfig{
+			"shell": {"type": "shell"},
+		},
+		Steps: []Step{
+			{
+				ID:         "test",
+				Executor:   "shell",
+				CheckAfter: true,
+				Files:      []FileConfig{{Path: "test.sh"}},
+			},
+		},
+	}
+
+	err := ValidateExecutionPlan(plan)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "check_after requires check_command")
+
+	plan.Steps[0].CheckCommand = "which tool"
+	assert.NoError(t, ValidateExecutionPlan(plan))
+}
+
+func TestValidateOnFailure(t *testing.T) {
+	for _, policy := range []string{"", "abort", "continue", "ignore"} {
+		t.Run("valid "+policy, func(t *testing.T) {
+			plan := &ExecutionPlan{
+				Name:      "Test",
+				Version:   "1.0.0",
+				Executors: map[string]ExecutorConfig{"shell": {"type": "shell"}},
+				Steps: []Step{
+					{ID: "test", Executor: "shell", OnFailure: policy, Files: []FileConfig{{Path: "test.sh"}}},
+				},
+			}
+			assert.NoError(t, ValidateExecutionPlan(plan))
+		})
+	}
+
+	plan := &ExecutionPlan{
+		Name:      "Test",
+		Version:   "1.0.0",
+		Executors: map[string]ExecutorConfig{"shell": {"type": "shell"}},
+		Steps: []Step{
+			{ID: "test", Executor: "shell", OnFailure: "retry", Files: []FileConfig{{Path: "test.sh"}}},
+		},
+	}
+	err := ValidateExecutionPlan(plan)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid on_failure 'retry' in step 'test'")
+}
+
+func TestValidateTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		wantErr bool
+	}{
+		{"no tags", nil, false},
+		{"valid tags", []string{"tools", "seed-db", "optional_extras"}, false},
+		{"empty tag", []string{""}, true},
+		{"comma", []string{"tools,db"}, true},
+		{"space", []string{"optional extras"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &ExecutionPlan{
+				Name:      "Test",
+				Version:   "1.0.0",
+				Executors: map[string]ExecutorConfig{"shell": {"type": "shell"}},
+				Steps: []Step{
+					{ID: "test", Executor: "shell", Tags: tt.tags, Files: []FileConfig{{Path: "test.sh"}}},
+				},
+			}
+			err := ValidateExecutionPlan(plan)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid tag")
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	step := Step{Tags: []string{"tools", "optional"}}
+	assert.True(t, step.HasTag("db", "optional"))
+	assert.False(t, step.HasTag("db"))
+	assert.False(t, step.HasTag())
+}
+
+func TestRequires(t *testing.T) {
+	t.Run("scalar and mapping forms", func(t *testing.T) {
+		planPath := filepath.Join(t.TempDir(), "plan.yml")
+		require.NoError(t, os.WriteFile(planPath, []byte(`
+name: test
+version: "1.0.0"
+requires:
+  go: ">=1.21"
+  git: "*"
+executors:
+  db:
+    type: sql
+steps:
+  - id: migrate
+    executor: db
+    requires:
+      psql:
+        version: ^16
+        command: psql --version
+    files:
+      - path: migrate.sql
+`), 0600))
+
+		plan, err := LoadExecutionPlan(planPath)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]ToolRequirement{
+			"go":  {Version: ">=1.21"},
+			"git": {Version: "*"},
+		}, plan.Requires)
+		assert.Equal(t, map[string]ToolRequirement{
+			"psql": {Version: "^16", Command: "psql --version"},
+		}, plan.Steps[0].Requires)
+	})
+
+	tests := []struct {
+		name         string
+		planRequires map[string]ToolRequirement
+		stepRequires map[string]ToolRequirement
+		wantErr      string
+	}{
+		{"valid", map[string]ToolRequirement{"go": {Version: ">=1.21, <2"}}, map[string]ToolRequirement{"psql": {}}, ""},
+		{"invalid plan constraint", map[string]ToolRequirement{"go": {Version: "latest"}}, nil, "invalid version for required tool 'go'"},
+		{"invalid step constraint", nil, map[string]ToolRequirement{"psql": {Version: ">=16.x"}}, "in step 'test'"},
+		{"empty tool name", map[string]ToolRequirement{"": {}}, nil, "required tool name cannot be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &ExecutionPlan{
+				Name:      "Test",
+				Version:   "1.0.0",
+				Requires:  tt.planRequires,
+				Executors: map[string]ExecutorConfig{"shell": {"type": "shell"}},
+				Steps: []Step{
+					{ID: "test", Executor: "shell", Requires: tt.stepRequires, Files: []FileConfig{{Path: "test.sh"}}},
+				},
+			}
+			err := ValidateExecutionPlan(plan)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestValidateRollback(t *testing.T) {
+	newPlan := func() *ExecutionPlan {
+		return &ExecutionPlan{
+			Name:    "Test",
+			Version: "1.0.0",
+			Executors: map[string]ExecutorConfig{
+				"shell": {"type": "shell"},
+			},
+			Steps: []Step{
+				{
+					ID:       "test",
+					Executor: "shell",
+					Files:    []FileConfig{{Path: "install.sh"}},
+					Rollback: []FileConfig{{Path: "uninstall.sh"}},
+				},
+			},
+		}
+	}
+
+	t.Run("valid rollback", func(t *testing.T) {
+		plan := newPlan()
+		plan.RollbackScope = "run"
+		assert.NoError(t, ValidateExecutionPlan(plan))
+	})
+
+	t.Run("invalid rollback_scope", func(t *testing.T) {
+		plan := newPlan()
+		plan.RollbackScope = "all"
+		err := ValidateExecutionPlan(plan)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid rollback_scope 'all'")
+	})
+
+	t.Run("invalid rollback file", func(t *testing.T) {
+		plan := newPlan()
+		plan.Steps[0].Rollback[0].Path = "../uninstall.sh"
+		err := ValidateExecutionPlan(plan)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid rollback: file path cannot contain '..'")
+	})
+
+	t.Run("parse rollback", func(t *testing.T) {
+		content := `
+name: "Rollback"
+version: "1.0.0"
+rollback_scope: run
+executors:
+  shell:
+    type: shell
+steps:
+  - id: install
+    executor: shell
+    files:
+      - path: "install.sh"
+    rollback:
+      - path: "uninstall.sh"
+        platform: linux
+`
+		planFile := filepath.Join(t.TempDir(), "plan.yml")
+		require.NoError(t, os.WriteFile(planFile, []byte(content), 0600))
+
+		plan, err := LoadExecutionPlan(planFile)
+		require.NoError(t, err)
+		assert.Equal(t, "run", plan.RollbackScope)
+		assert.Equal(t, []FileConfig{{Path: "uninstall.sh", Platform: "linux"}}, plan.Steps[0].Rollback)
+	})
+}
+
+func TestPlanVars(t *testing.T) {
+	content := `
+name: "{{ vars.project }} setup"
+version: "1.0.0"
+description: "Set up {{ vars.project }} for {{ .vars.env }}"
+vars:
+  project: shop
+  env: development
+  timeout: "30"
+executors:
+  db:
+    type: shell
+    shell: "{{ vars.shell }}"
+steps:
+  - id: database
+    description: "Create the {{ vars.project }}_{{ vars.env }} database"
+    executor: db
+    skip_if: 'vars.env == "production"'
+    check_command: 'psql "{{ steps.database.outputs.url }}" -c "SELECT 1"'
+    files:
+      - path: "scripts/{{ vars.env }}/create.sh"
+        timeout: "{{ vars.timeout }}"
+`
+	write := func(t *testing.T, name, content string) string {
+		path := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	t.Run("renders plan fields", func(t *testing.T) {
+		plan, err := LoadExecutionPlanWithOptions(write(t, "plan.yml", content), LoadOptions{
+			Vars: map[string]string{"shell": "/bin/zsh"},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "shop setup", plan.Name)
+		assert.Equal(t, "Set up shop for development", plan.Description)
+		assert.Equal(t, "/bin/zsh", plan.Executors["db"]["shell"])
+		assert.Equal(t, "Create the shop_development database", plan.Steps[0].Description)
+		assert.Equal(t, "scripts/development/create.sh", plan.Steps[0].Files[0].Path)
+		assert.Equal(t, 30, plan.Steps[0].Files[0].Timeout)
+		assert.Equal(t, `vars.env == "production"`, plan.Steps[0].SkipIf)
+		// Step output references are resolved at execution time
+		assert.Equal(t, `psql "{{ steps.database.outputs.url }}" -c "SELECT 1"`, plan.Steps[0].CheckCommand)
+		assert.Equal(t, map[string]string{
+			"project": "shop",
+			"env":     "development",
+			"timeout": "30",
+			"shell":   "/bin/zsh",
+		}, plan.Vars)
+	})
+
+	t.Run("var files and overrides", func(t *testing.T) {
+		staging := write(t, "staging.yml", "env: staging\nshell: /bin/sh\n")
+		ci := write(t, "ci.yml", "shell: /bin/bash\n")
+
+		plan, err := LoadExecutionPlanWithOptions(write(t, "plan.yml", content), LoadOptions{
+			VarFiles: []string{staging, ci},
+			Vars:     map[string]string{"project": "store"},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "Set up store for staging", plan.Description)
+		assert.Equal(t, "/bin/bash", plan.Executors["db"]["shell"])
+		assert.Equal(t, "scripts/staging/create.sh", plan.Steps[0].Files[0].Path)
+	})
+
+	t.Run("undefined variable", func(t *testing.T) {
+		_, err := LoadExecutionPlan(write(t, "plan.yml", content))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "line 12: undefined variable 'shell'")
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		_, err := LoadExecutionPlan(write(t, "plan.yml", `
+name: "{{ vars.project "
+version: "1.0.0"
+`))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "line 2: invalid template")
+	})
+
+	t.Run("missing var file", func(t *testing.T) {
+		_, err := LoadExecutionPlanWithOptions(write(t, "plan.yml", content), LoadOptions{
+			VarFiles: []string{filepath.Join(t.TempDir(), "missing.yml")},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to read var file")
+	})
+
+	t.Run("skip_if may reference plan vars", func(t *testing.T) {
+		plan := &ExecutionPlan{
+			Name:      "Test",
+			Version:   "1.0.0",
+			Vars:      map[string]string{"env": "dev"},
+			Executors: map[string]ExecutorConfig{"shell": {"type": "shell"}},
+			Steps: []Step{
+				{ID: "test", Executor: "shell", SkipIf: `vars.env == "ci"`, Files: []FileConfig{{Path: "test.sh"}}},
+			},
+		}
+		assert.NoError(t, ValidateExecutionPlan(plan))
+
+		plan.Steps[0].SkipIf = `vars.stage == "ci"`
+		assert.Error(t, ValidateExecutionPlan(plan))
+	})
+}
+
+func TestParseVar(t *testing.T) {
+	tests := []struct {
+		assignment string
+		name       string
+		value      string
+		wantErr    bool
+	}{
+		{"env=staging", "env", "staging", false},
+		{"url=postgres://u:p@host/db?a=b", "url", "postgres://u:p@host/db?a=b", false},
+		{"empty=", "empty", "", false},
+		{"novalue", "", "", true},
+		{"=value", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.assignment, func(t *testing.T) {
+			name, value, err := ParseVar(tt.assignment)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.name, name)
+			assert.Equal(t, tt.value, value)
+		})
+	}
+}
+
+func TestPlanEnvExpansion(t *testing.T) {
+	content := `
+name: "${APP_NAME:-shop} setup"
+version: "1.0.0"
+vars:
+  env: ${DEPLOY_ENV:-development}
+executors:
+  main_db:
+    type: sql
+    driver: postgres
+    host: ${MAIN_DB_HOST:-localhost}
+    port: ${MAIN_DB_PORT:-5432}
+    password: "${MAIN_DB_PASSWORD}"
+    options: ${DB_OPTIONS:+sslmode=${DB_OPTIONS}}
+steps:
+  - id: setup
+    description: "Set up {{ vars.env }} in $${HOME}, ${#HOME} and $HOME"
+    executor: main_db
+    files:
+      - path: ${SQL_DIR:-${SCHEMA_DIR:-sql}}/setup.sql
+`
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "plan.yml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		plan, err := LoadExecutionPlan(write(t, content))
+		require.NoError(t, err)
+
+		assert.Equal(t, "shop setup", plan.Name)
+		assert.Equal(t, "localhost", plan.Executors["main_db"]["host"])
+		assert.Equal(t, 5432, plan.Executors["main_db"]["port"])
+		assert.Equal(t, "", plan.Executors["main_db"]["password"])
+		// Unquoted values that expand to nothing are null, as if left empty
+		assert.Nil(t, plan.Executors["main_db"]["options"])
+		assert.Equal(t, "sql/setup.sql", plan.Steps[0].Files[0].Path)
+		assert.Equal(t, map[string]string{"env": "development"}, plan.Vars)
+		// Escaped and unsupported references are left as written
+		assert.Equal(t, "Set up development in ${HOME}, ${#HOME} and $HOME", plan.Steps[0].Description)
+	})
+
+	t.Run("environment values", func(t *testing.T) {
+		t.Setenv("APP_NAME", "store")
+		t.Setenv("DEPLOY_ENV", "staging")
+		t.Setenv("MAIN_DB_HOST", "db.internal")
+		t.Setenv("MAIN_DB_PORT", "6432")
+		t.Setenv("MAIN_DB_PASSWORD", "0123")
+		t.Setenv("DB_OPTIONS", "require")
+		t.Setenv("SCHEMA_DIR", "schema")
+
+		plan, err := LoadExecutionPlan(write(t, content))
+		require.NoError(t, err)
+
+		assert.Equal(t, "store setup", plan.Name)
+		assert.Equal(t, "db.internal", plan.Executors["main_db"]["host"])
+		assert.Equal(t, 6432, plan.Executors["main_db"]["port"])
+		// Quoted values stay strings
+		assert.Equal(t, "0123", plan.Executors["main_db"]["password"])
+		assert.Equal(t, "sslmode=require", plan.Executors["main_db"]["options"])
+		assert.Equal(t, "schema/setup.sql", plan.Steps[0].Files[0].Path)
+		assert.Equal(t, "Set up staging in ${HOME}, ${#HOME} and $HOME", plan.Steps[0].Description)
+	})
+
+	t.Run("environment values are not rendered", func(t *testing.T) {
+		t.Setenv("APP_NAME", "{{ vars.missing }}")
+
+		plan, err := LoadExecutionPlan(write(t, content))
+		require.NoError(t, err)
+		assert.Equal(t, "{{ vars.missing }} setup", plan.Name)
+	})
+
+	t.Run("required variable", func(t *testing.T) {
+		_, err := LoadExecutionPlan(write(t, `
+name: test
+version: "1.0.0"
+executors:
+  db:
+    type: sql
+    database: ${DB_NAME:?set DB_NAME to the database to migrate}
+`))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "line 7: DB_NAME: set DB_NAME to the database to migrate")
+
+		_, err = LoadExecutionPlan(write(t, "name: ${PLAN_NAME?}\nversion: \"1.0.0\"\n"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "line 1: PLAN_NAME: parameter not set")
+	})
+
+	t.Run("shell commands are left to the shell", func(t *testing.T) {
+		t.Setenv("APP_NAME", "shop")
+
+		plan, err := LoadExecutionPlan(write(t, `
+name: test
+version: "1.0.0"
+vars:
+  table: users
+requires:
+  psql:
+    command: psql --version ${PSQL_FLAGS:-}
+executors:
+  shell:
+    type: shell
+steps:
+  - id: setup
+    executor: shell
+    skip_if: command("test -f ${HOME}/.${APP_NAME}")
+    check_command: test -n "${PLEXR_STEP_CREATE_OUTPUT_ID}" && psql -c "select 1 from {{ vars.table }}"
+    verify: test -n "${DB_URL:?}"
+    files:
+      - path: ${APP_NAME}.sh
+        skip_if: command("test -z ${CI}")
+    rollback:
+      - path: undo.sh
+        skip_if: command("test -z ${CI}")
+`))
+		require.NoError(t, err)
+
+		step := plan.Steps[0]
+		assert.Equal(t, `command("test -f ${HOME}/.${APP_NAME}")`, step.SkipIf)
+		assert.Equal(t, `test -n "${PLEXR_STEP_CREATE_OUTPUT_ID}" && psql -c "select 1 from users"`, step.CheckCommand)
+		assert.Equal(t, `test -n "${DB_URL:?}"`, step.Verify)
+		assert.Equal(t, "shop.sh", step.Files[0].Path)
+		assert.Equal(t, `command("test -z ${CI}")`, step.Files[0].SkipIf)
+		assert.Equal(t, `command("test -z ${CI}")`, step.Rollback[0].SkipIf)
+		assert.Equal(t, "psql --version ${PSQL_FLAGS:-}", plan.Requires["psql"].Command)
+	})
+}
+
+func TestEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(t *testing.T, name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	write(t, ".env", "DB_HOST=db.internal\nDB_PORT=6432\nDB_USER=app\nDB_PASSWORD=secret\n")
+	write(t, ".env.seed", "DB_USER=seeder\n")
+	planPath := write(t, "plan.yml", `
+name: test
+version: "1.0.0"
+env_file: .env
+executors:
+  db:
+    type: sql
+    host: ${DB_HOST:-localhost}
+    port: ${DB_PORT:-5432}
+steps:
+  - id: migrate
+    description: "Migrate as ${DB_USER}"
+    executor: db
+    files:
+      - path: migrate.sql
+  - id: seed
+    description: "Seed as ${DB_USER}"
+    executor: db
+    env_file:
+      - .env.seed
+    files:
+      - path: seed.sql
+`)
+
+	t.Run("plan and step env files", func(t *testing.T) {
+		plan, err := LoadExecutionPlan(planPath)
+		require.NoError(t, err)
+
+		assert.Equal(t, EnvFiles{".env"}, plan.EnvFile)
+		assert.Equal(t, "db.internal", plan.Executors["db"]["host"])
+		assert.Equal(t, 6432, plan.Executors["db"]["port"])
+		assert.Equal(t, "Migrate as app", plan.Steps[0].Description)
+		assert.Equal(t, "Seed as seeder", plan.Steps[1].Description)
+		assert.Equal(t, map[string]string{
+			"DB_HOST":     "db.internal",
+			"DB_PORT":     "6432",
+			"DB_USER":     "app",
+			"DB_PASSWORD": "secret",
+		}, plan.Environment)
+		assert.Nil(t, plan.Steps[0].Environment)
+		assert.Equal(t, "seeder", plan.Steps[1].Environment["DB_USER"])
+	})
+
+	t.Run("command line env files override the plan's", func(t *testing.T) {
+		ci := write(t, ".env.ci", "DB_HOST=ci-db\nDB_USER=ci\n")
+
+		plan, err := LoadExecutionPlanWithOptions(planPath, LoadOptions{EnvFiles: []string{ci}})
+		require.NoError(t, err)
+
+		assert.Equal(t, "ci-db", plan.Executors["db"]["host"])
+		assert.Equal(t, "Migrate as ci", plan.Steps[0].Description)
+		assert.Equal(t, "Seed as ci", plan.Steps[1].Description)
+		assert.Equal(t, "ci", plan.Environment["DB_USER"])
+		assert.Equal(t, "ci", plan.Steps[1].Environment["DB_USER"])
+	})
+
+	t.Run("process environment wins", func(t *testing.T) {
+		t.Setenv("DB_USER", "admin")
+
+		plan, err := LoadExecutionPlan(planPath)
+		require.NoError(t, err)
+
+		assert.Equal(t, "Migrate as admin", plan.Steps[0].Description)
+		assert.Equal(t, "Seed as admin", plan.Steps[1].Description)
+		assert.NotContains(t, plan.Environment, "DB_USER")
+		assert.NotContains(t, plan.Steps[1].Environment, "DB_USER")
+	})
+
+	t.Run("env file paths expand the process environment", func(t *testing.T) {
+		t.Setenv("PLEXR_TEST_STAGE", "seed")
+		path := write(t, "stage.yml", `
+name: test
+version: "1.0.0"
+env_file: .env.${PLEXR_TEST_STAGE}
+executors:
+  shell:
+    type: shell
+steps:
+  - id: test
+    description: "Run as ${DB_USER}"
+    executor: shell
+    files:
+      - path: test.sh
+`)
+
+		plan, err := LoadExecutionPlan(path)
+		require.NoError(t, err)
+		assert.Equal(t, EnvFiles{".env.seed"}, plan.EnvFile)
+		assert.Equal(t, "Run as seeder", plan.Steps[0].Description)
+	})
+
+	t.Run("missing env file", func(t *testing.T) {
+		_, err := LoadExecutionPlanWithOptions(planPath, LoadOptions{
+			EnvFiles: []string{filepath.Join(dir, "missing.env")},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to read env file")
+	})
+
+	t.Run("invalid env file", func(t *testing.T) {
+		invalid := write(t, ".env.invalid", "DB_HOST=localhost\nnot a variable\n")
+
+		_, err := LoadExecutionPlanWithOptions(planPath, LoadOptions{EnvFiles: []string{invalid}})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "line 2: expected NAME=VALUE")
+	})
+}
+
+func TestParseEnvFile(t *testing.T) {
+	t.Setenv("PLEXR_TEST_HOME", "/home/app")
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "unquoted values",
+			content: "# Database\nHOST=localhost\n\nPORT = 5432 # default port\nEMPTY=\nHASH=pass#word\n",
+			want:    map[string]string{"HOST": "localhost", "PORT": "5432", "EMPTY": "", "HASH": "pass#word"},
+		},
+		{
+			name:    "export prefix",
+			content: "export HOST=localhost\nexported=1\n",
+			want:    map[string]string{"HOST": "localhost", "exported": "1"},
+		},
+		{
+			name:    "single quotes are literal",
+			content: `PASSWORD='p@ss ${HOME} \n # not a comment'`,
+			want:    map[string]string{"PASSWORD": `p@ss ${HOME} \n # not a comment`},
+		},
+		{
+			name:    "double quotes unescape",
+			content: `GREETING="say \"hi\"\tthere\n" # comment`,
+			want:    map[string]string{"GREETING": "say \"hi\"\tthere\n"},
+		},
+		{
+			name:    "multi-line values",
+			content: "KEY=\"-----BEGIN KEY-----\nabc\n-----END KEY-----\"\nNEXT=1\n",
+			want:    map[string]string{"KEY": "-----BEGIN KEY-----\nabc\n-----END KEY-----", "NEXT": "1"},
+		},
+		{
+			name:    "references",
+			content: "DB=app\nURL=postgres://${DB_HOST:-localhost}/${DB}\nDATA=\"${PLEXR_TEST_HOME}/data\"\nRAW='${DB}'\n",
+			want: map[string]string{
+				"DB":   "app",
+				"URL":  "postgres://localhost/app",
+				"DATA": "/home/app/data",
+				"RAW":  "${DB}",
+			},
+		},
+		{
+			name:    "later assignments win",
+			content: "A=1\nA=2\n",
+			want:    map[string]string{"A": "2"},
+		},
+		{
+			name:    "windows line endings",
+			content: "A=1\r\nB=2\r\n",
+			want:    map[string]string{"A": "1", "B": "2"},
+		},
+		{
+			name:    "missing equals sign",
+			content: "A=1\nB\n",
+			wantErr: "line 2: expected NAME=VALUE",
+		},
+		{
+			name:    "invalid name",
+			content: "MY-VAR=1\n",
+			wantErr: "line 1: expected NAME=VALUE",
+		},
+		{
+			name:    "unterminated quote",
+			content: "A=\"open\nB=2\n",
+			wantErr: "line 1: unterminated quoted value",
+		},
+		{
+			name:    "text after quotes",
+			content: "A='one' two\n",
+			wantErr: "line 1: unexpected characters after quoted value",
+		},
+		{
+			name:    "required variable",
+			content: "A=${PLEXR_TEST_UNSET:?must be set}\n",
+			wantErr: "line 1: PLEXR_TEST_UNSET: must be set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := make(map[string]string)
+			err := parseEnvFile(tt.content, env)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, env)
+		})
+	}
+}
+
+func TestPlanSecrets(t *testing.T) {
+	t.Cleanup(utils.ResetSecrets)
+
+	dir := t.TempDir()
+	write := func(t *testing.T, name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	write(t, ".env", "DB_PASSWORD=pa$$word\n")
+	write(t, "api_token", "tok-123\n")
+	secrets := `
+secrets:
+  db_password:
+    env: DB_PASSWORD
+  api_token:
+    file: api_token
+`
+	if runtime.GOOS != "windows" {
+		secrets += `  signing_key:
+    command: printf 'key-%s\n' "$KEY_SUFFIX"
+`
+	}
+	planPath := write(t, "plan.yml", `
+name: test
+version: "1.0.0"
+env_file: .env
+`+secrets+`
+executors:
+  db:
+    type: sql
+    password: "{{ secrets.db_password }}"
+steps:
+  - id: deploy
+    description: "Deploy with {{ .secrets.api_token }}"
+    executor: db
+    files:
+      - path: deploy.sql
+`)
+
+	t.Run("resolves secrets", func(t *testing.T) {
+		t.Setenv("KEY_SUFFIX", "abc")
+
+		plan, err := LoadExecutionPlanWithOptions(planPath, LoadOptions{ResolveSecrets: true})
+		require.NoError(t, err)
+
+		assert.Equal(t, "pa$$word", plan.Executors["db"]["password"])
+		assert.Equal(t, "Deploy with tok-123", plan.Steps[0].Description)
+		assert.Equal(t, SecretSource{Env: "DB_PASSWORD"}, plan.Secrets["db_password"])
+		assert.Equal(t, "tok-123", plan.SecretValues["api_token"])
+		if runtime.GOOS != "windows" {
+			assert.Equal(t, "key-abc", plan.SecretValues["signing_key"])
+		}
+
+		// Resolved values are masked from now on
+		assert.Equal(t, "token: "+utils.MaskPlaceholder, utils.MaskSecrets("token: tok-123"))
+	})
+
+	t.Run("unresolved secrets are placeholders", func(t *testing.T) {
+		plan, err := LoadExecutionPlan(planPath)
+		require.NoError(t, err)
+
+		assert.Equal(t, utils.MaskPlaceholder, plan.Executors["db"]["password"])
+		assert.Equal(t, "Deploy with "+utils.MaskPlaceholder, plan.Steps[0].Description)
+		assert.Nil(t, plan.SecretValues)
+	})
+
+	t.Run("undefined secret", func(t *testing.T) {
+		_, err := LoadExecutionPlan(write(t, "undefined.yml", `
+name: test
+version: "1.0.0"
+executors:
+  db:
+    type: sql
+    password: "{{ secrets.db_password }}"
+`))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "line 7: undefined secret 'db_password'")
+	})
+
+	t.Run("resolution errors", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			secret  string
+			wantErr string
+		}{
+			{"unset variable", "{env: PLEXR_TEST_UNSET}", "failed to resolve secret 'token': environment variable PLEXR_TEST_UNSET is not set"},
+			{"missing file", "{file: missing}", "failed to read secret file"},
+			{"empty file", "{file: empty}", "failed to resolve secret 'token': value is empty"},
+			{"failing command", "{command: exit 3}", "failed to resolve secret 'token': command failed"},
+			{"several sources", "{env: HOME, file: api_token}", "secret 'token' must have exactly one of env, file or command"},
+		}
+		write(t, "empty", "\n")
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := LoadExecutionPlanWithOptions(write(t, "invalid.yml", `
+name: test
+version: "1.0.0"
+secrets:
+  token: `+tt.secret+`
+`), LoadOptions{ResolveSecrets: true})
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			})
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		plan := &ExecutionPlan{
+			Name:      "Test",
+			Version:   "1.0.0",
+			Secrets:   map[string]SecretSource{"db-password": {Env: "DB_PASSWORD"}},
+			Executors: map[string]ExecutorConfig{"shell": {"type": "shell"}},
+			Steps:     []Step{{ID: "test", Executor: "shell", Files: []FileConfig{{Path: "test.sh"}}}},
+		}
+		err := ValidateExecutionPlan(plan)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid secret name 'db-password'")
+
+		plan.Secrets = map[string]SecretSource{"db_password": {}}
+		err = ValidateExecutionPlan(plan)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must have exactly one of env, file or command")
+	})
+}
+
+func TestStateConfig(t *testing.T) {
+	t.Run("postgres backend", func(t *testing.T) {
+		planPath := filepath.Join(t.TempDir(), "plan.yml")
+		require.NoError(t, os.WriteFile(planPath, []byte(`
+name: test
+version: "1.0.0"
+state:
+  backend: postgres
+  executor: db
+  table: setup.plexr_state
+executors:
+  db:
+    type: sql
+steps:
+  - id: migrate
+    executor: db
+    files:
+      - path: migrate.sql
+`), 0600))
+
+		plan, err := LoadExecutionPlan(planPath)
+		require.NoError(t, err)
+		assert.Equal(t, StateConfig{Backend: "postgres", Executor: "db", Table: "setup.plexr_state"}, plan.State)
+	})
+
+	tests := []struct {
+		name    string
+		state   StateConfig
+		wantErr string
+	}{
+		{"default", StateConfig{}, ""},
+		{"file", StateConfig{Backend: "file"}, ""},
+		{"postgres", StateConfig{Backend: "postgres", Executor: "db", Key: "shared"}, ""},
+		{"unknown backend", StateConfig{Backend: "redis"}, "invalid state backend 'redis'"},
+		{"missing executor", StateConfig{Backend: "postgres"}, "requires an executor"},
+		{"undefined executor", StateConfig{Backend: "postgres", Executor: "other"}, "undefined executor 'other' in state"},
+		{"not a sql executor", StateConfig{Backend: "postgres", Executor: "shell"}, "must be a sql executor"},
+		{"invalid table", StateConfig{Backend: "postgres", Executor: "db", Table: "state; DROP TABLE users"}, "invalid state table"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &ExecutionPlan{
+				Name:    "Test",
+				Version: "1.0.0",
+				State:   tt.state,
+				Executors: map[string]ExecutorConfig{
+					"shell": {"type": "shell"},
+					"db":    {"type": "sql"},
+				},
+				Steps: []Step{
+					{ID: "test", Executor: "shell", Files: []FileConfig{{Path: "test.sh"}}},
+				},
+			}
+			err := ValidateExecutionPlan(plan)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestExecutorConfig(t *testing.T) {
 	t.Run("marshal and unmarshal", func(t *testing.T) {
 		tests := []struct {
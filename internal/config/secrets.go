@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SphereStacking/plexr/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// secretCommandTimeout bounds the time a secret command may take
+const secretCommandTimeout = time.Minute
+
+// SecretEnvName returns the name of the environment variable that passes a secret to scripts
+func SecretEnvName(name string) string {
+	return "PLEXR_SECRET_" + strings.ToUpper(name)
+}
+
+// planSecrets decodes the secrets section of the plan document, expanding
+// references to env in its values. When resolve is set, the value of every
+// secret is looked up and registered for masking; otherwise each secret is
+// represented by the mask placeholder.
+func planSecrets(doc *yaml.Node, dir string, env map[string]string, resolve bool) (map[string]string, error) {
+	node := mappingValue(doc, "secrets")
+	if node == nil {
+		return map[string]string{}, nil
+	}
+
+	if err := rewriteValues(node, nil, envExpander(env)); err != nil {
+		return nil, err
+	}
+	var sources map[string]SecretSource
+	if err := node.Decode(&sources); err != nil {
+		return nil, fmt.Errorf("invalid secrets: %w", err)
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make(map[string]string, len(sources))
+	for _, name := range names {
+		source := sources[name]
+		if err := validateSecret(name, source); err != nil {
+			return nil, err
+		}
+		if !resolve {
+			values[name] = utils.MaskPlaceholder
+			continue
+		}
+
+		value, err := resolveSecret(source, dir, env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve secret '%s': %w", name, err)
+		}
+		utils.AddSecret(value)
+		values[name] = value
+	}
+	return values, nil
+}
+
+// validateSecret checks the name of a secret and that it has exactly one source
+func validateSecret(name string, source SecretSource) error {
+	if !envNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name '%s' (use letters, digits and underscores)", name)
+	}
+
+	count := 0
+	for _, value := range []string{source.Env, source.File, source.Command} {
+		if value != "" {
+			count++
+		}
+	}
+	if count != 1 {
+		return fmt.Errorf("secret '%s' must have exactly one of env, file or command", name)
+	}
+	return nil
+}
+
+// resolveSecret looks up the value of a secret. Relative files are resolved
+// against dir and commands run in it, with the variables of env added to
+// their environment.
+func resolveSecret(source SecretSource, dir string, env map[string]string) (string, error) {
+	var value string
+	switch {
+	case source.Env != "":
+		v, ok := utils.LookupEnv(env)(source.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", source.Env)
+		}
+		value = v
+	case source.File != "":
+		path := source.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := os.ReadFile(path) // #nosec G304 - secret files are chosen by the plan author
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		value = string(data)
+	default:
+		v, err := runSecretCommand(source.Command, dir, env)
+		if err != nil {
+			return "", err
+		}
+		value = v
+	}
+
+	// Files and command output usually end with a newline
+	value = strings.TrimRight(value, "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("value is empty")
+	}
+	return value, nil
+}
+
+// runSecretCommand runs a command with the platform shell and returns its standard output
+func runSecretCommand(command, dir string, env map[string]string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command) // #nosec G204 - secret commands are defined by the plan author
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command) // #nosec G204 - secret commands are defined by the plan author
+	}
+	cmd.Dir = dir
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cmd.Env = os.Environ()
+	for _, name := range names {
+		cmd.Env = append(cmd.Env, name+"="+env[name])
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("command failed: %w: %s", err, message)
+		}
+		return "", fmt.Errorf("command failed: %w", err)
+	}
+	return stdout.String(), nil
+}
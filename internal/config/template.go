@@ -0,0 +1,315 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/SphereStacking/plexr/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// outputRefPattern matches the start of {{ steps.<id>.outputs.<key> }}
+	// references, which are resolved by the runner at execution time
+	outputRefPattern = regexp.MustCompile(`\{\{(-?\s*steps\.)`)
+	// missingKeyPattern extracts the map (vars or secrets) and the key from
+	// text/template's missing key error
+	missingKeyPattern = regexp.MustCompile(`at <\.?(\w+)[^>]*>: map has no entry for key "([^"]*)"`)
+)
+
+// planVars merges the plan's vars section with the variables of the var
+// files and the explicit overrides, in that order of precedence
+func planVars(doc *yaml.Node, opts LoadOptions) (map[string]string, error) {
+	vars := make(map[string]string)
+
+	if node := mappingValue(doc, "vars"); node != nil {
+		if err := node.Decode(&vars); err != nil {
+			return nil, fmt.Errorf("invalid vars: %w", err)
+		}
+	}
+
+	for _, path := range opts.VarFiles {
+		fileVars, err := loadVarFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range fileVars {
+			vars[name] = value
+		}
+	}
+
+	for name, value := range opts.Vars {
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// loadVarFile reads a YAML mapping of variable names to values
+func loadVarFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 - var files are chosen by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to read var file: %w", err)
+	}
+
+	vars := make(map[string]string)
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("failed to parse var file %s: %w", path, err)
+	}
+	return vars, nil
+}
+
+// ParseVar parses a NAME=VALUE variable assignment
+func ParseVar(assignment string) (string, string, error) {
+	name, value, ok := strings.Cut(assignment, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid variable '%s' (expected NAME=VALUE)", assignment)
+	}
+	return name, value, nil
+}
+
+// expansion holds what expanding a plan document yields besides the document
+type expansion struct {
+	vars     map[string]string   // Plan variables, including overrides
+	secrets  map[string]string   // Secret values, or placeholders when secrets are not resolved
+	env      map[string]string   // Variables of the plan's and the command line's env files
+	stepEnvs []map[string]string // Variables of each step's env files, nil for steps without any
+}
+
+// expandPlan expands the values of the plan document. The env files of the
+// plan are loaded and its secrets resolved first, so that their variables are
+// available to the expansion. Environment variables are then expanded in the vars section
+// before the variables are collected; every other value has its templates
+// rendered first and then its environment variables expanded, so values of
+// environment variables are never rendered. Steps with env files of their own
+// are expanded with their variables as well. Shell commands and skip_if
+// conditions only have their templates rendered, as the shell expands their
+// variables when they run. Relative env files are resolved against dir.
+func expandPlan(doc *yaml.Node, opts LoadOptions, dir string) (*expansion, error) {
+	skip := make(map[*yaml.Node]bool)
+
+	fileEnv, err := envFileVars(doc, dir, skip, nil)
+	if err != nil {
+		return nil, err
+	}
+	cliEnv := make(map[string]string)
+	if err := loadEnvFiles(cliEnv, opts.EnvFiles); err != nil {
+		return nil, err
+	}
+	result := &expansion{env: effectiveEnv(fileEnv, cliEnv)}
+
+	result.secrets, err = planSecrets(doc, dir, result.env, opts.ResolveSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if node := mappingValue(doc, "secrets"); node != nil {
+		skip[node] = true
+	}
+
+	varsNode := mappingValue(doc, "vars")
+	if varsNode != nil {
+		skip[varsNode] = true
+		if err := rewriteValues(varsNode, nil, envExpander(result.env)); err != nil {
+			return nil, err
+		}
+	}
+
+	result.vars, err = planVars(doc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := template.FuncMap{
+		"vars":    func() map[string]string { return result.vars },
+		"secrets": func() map[string]string { return result.secrets },
+	}
+	data := map[string]interface{}{"vars": result.vars, "secrets": result.secrets}
+	expander := func(env map[string]string) func(string) (string, error) {
+		expand := envExpander(env)
+		return func(value string) (string, error) {
+			if strings.Contains(value, "{{") {
+				rendered, err := renderValue(value, funcs, data)
+				if err != nil {
+					return "", err
+				}
+				value = rendered
+			}
+			return expand(value)
+		}
+	}
+
+	render := func(value string) (string, error) {
+		if !strings.Contains(value, "{{") {
+			return value, nil
+		}
+		return renderValue(value, funcs, data)
+	}
+	for _, node := range commandNodes(doc) {
+		if err := rewriteValues(node, nil, render); err != nil {
+			return nil, err
+		}
+		skip[node] = true
+	}
+
+	if steps := mappingValue(doc, "steps"); steps != nil && steps.Kind == yaml.SequenceNode {
+		for _, step := range steps.Content {
+			var stepEnv map[string]string
+			if mappingValue(step, "env_file") != nil {
+				stepFileEnv, err := envFileVars(step, dir, skip, fileEnv)
+				if err != nil {
+					return nil, err
+				}
+				stepEnv = effectiveEnv(stepFileEnv, cliEnv)
+				if err := rewriteValues(step, skip, expander(stepEnv)); err != nil {
+					return nil, err
+				}
+				skip[step] = true
+			}
+			result.stepEnvs = append(result.stepEnvs, stepEnv)
+		}
+	}
+
+	if err := rewriteValues(doc, skip, expander(result.env)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// commandNodes returns the values of a plan document that the shell runs or
+// that hold skip_if conditions: check_command, verify and skip_if of steps,
+// skip_if of their files and rollback files, and the version commands of
+// requires. References such as ${PLEXR_STEP_...} in them are only known
+// when they run.
+func commandNodes(doc *yaml.Node) []*yaml.Node {
+	var nodes []*yaml.Node
+	add := func(parent *yaml.Node, keys ...string) {
+		for _, key := range keys {
+			if node := mappingValue(parent, key); node != nil {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	addRequires := func(parent *yaml.Node) {
+		if requires := mappingValue(parent, "requires"); requires != nil && requires.Kind == yaml.MappingNode {
+			for i := 1; i < len(requires.Content); i += 2 {
+				add(requires.Content[i], "command")
+			}
+		}
+	}
+
+	addRequires(doc)
+	steps := mappingValue(doc, "steps")
+	if steps == nil || steps.Kind != yaml.SequenceNode {
+		return nodes
+	}
+	for _, step := range steps.Content {
+		add(step, "skip_if", "check_command", "verify")
+		addRequires(step)
+		for _, key := range []string{"files", "rollback"} {
+			if files := mappingValue(step, key); files != nil && files.Kind == yaml.SequenceNode {
+				for _, file := range files.Content {
+					add(file, "skip_if")
+				}
+			}
+		}
+	}
+	return nodes
+}
+
+// envExpander expands ${NAME}, ${NAME:-default} and ${NAME:?message}
+// references to the variables of env, which override the process environment
+func envExpander(env map[string]string) func(string) (string, error) {
+	lookup := utils.LookupEnv(env)
+	return func(value string) (string, error) {
+		return utils.ExpandEnv(value, lookup)
+	}
+}
+
+// expandEnv expands references to the process environment
+func expandEnv(value string) (string, error) {
+	return utils.ExpandEnv(value, os.LookupEnv)
+}
+
+// rewriteValues replaces every scalar value below node, except those under
+// nodes in skip, with the result of rewrite. Mapping keys are left as written.
+func rewriteValues(node *yaml.Node, skip map[*yaml.Node]bool, rewrite func(string) (string, error)) error {
+	if skip[node] {
+		return nil
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := rewriteValues(child, skip, rewrite); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := rewriteValues(node.Content[i], skip, rewrite); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		value, err := rewrite(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value != node.Value {
+			// Let the new value of a plain scalar resolve to its own type, so
+			// that port: ${DB_PORT:-5432} decodes as a number. Quoted values
+			// stay strings unless they hold a template, which YAML requires
+			// to be quoted when it starts the value.
+			quoted := node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0
+			if node.Style&yaml.TaggedStyle == 0 && (!quoted || strings.Contains(node.Value, "{{")) {
+				node.Tag = ""
+				node.Style = 0
+			}
+			node.Value = value
+		}
+	}
+	return nil
+}
+
+// renderValue renders a single template, leaving step output references untouched
+func renderValue(text string, funcs template.FuncMap, data interface{}) (string, error) {
+	text = outputRefPattern.ReplaceAllString(text, `{{"{{"}}$1`)
+
+	tmpl, err := template.New("plan").Option("missingkey=error").Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		if match := missingKeyPattern.FindStringSubmatch(err.Error()); match != nil {
+			if match[1] == "secrets" {
+				return "", fmt.Errorf("undefined secret '%s'", match[2])
+			}
+			return "", fmt.Errorf("undefined variable '%s'", match[2])
+		}
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// mappingValue returns the value of a key of the document's top-level mapping
+func mappingValue(doc *yaml.Node, key string) *yaml.Node {
+	node := doc
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
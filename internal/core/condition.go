@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/expr"
+	"github.com/SphereStacking/plexr/internal/utils"
+)
+
+// evaluateCondition evaluates a skip_if expression in the context of a step.
+// Relative paths and commands are resolved against the step's working directory.
+func (r *Runner) evaluateCondition(ctx context.Context, step *config.Step, condition string) (bool, error) {
+	workDir := r.stepWorkDir(step)
+	resolve := func(path string) string {
+		if workDir != "" && !filepath.IsAbs(path) {
+			return filepath.Join(workDir, path)
+		}
+		return path
+	}
+
+	// Platform variables are more specific than plan variables
+	vars := make(map[string]string, len(r.plan.Vars))
+	for name, value := range r.plan.Vars {
+		vars[name] = value
+	}
+	for name, value := range r.plan.Platforms[r.platform] {
+		vars[name] = value
+	}
+
+	env := r.stepEnv(step)
+	exprCtx := &expr.Context{
+		OS:            r.platform,
+		Arch:          runtime.GOARCH,
+		Vars:          vars,
+		LookupEnv:     utils.LookupEnv(env),
+		StepCompleted: r.stateManager.IsStepCompleted,
+		FileExists: func(path string) bool {
+			info, err := os.Stat(resolve(path))
+			return err == nil && !info.IsDir()
+		},
+		DirExists: func(path string) bool {
+			info, err := os.Stat(resolve(path))
+			return err == nil && info.IsDir()
+		},
+		RunCommand: func(command string) (bool, error) {
+			command, err := r.expandOutputs(command)
+			if err != nil {
+				return false, err
+			}
+
+			cmdCtx, cancel := context.WithTimeout(ctx, checkCommandTimeout)
+			defer cancel()
+
+			result, err := r.shell.RunCommand(cmdCtx, command, workDir, env)
+			if err != nil {
+				return false, err
+			}
+			return result.Success, nil
+		},
+	}
+
+	return expr.Evaluate(condition, exprCtx)
+}
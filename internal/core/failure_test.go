@@ -0,0 +1,196 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/executors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnerFailurePolicies(t *testing.T) {
+	// failurePlan returns a plan where broken fails, dependent and
+	// indirect depend on it, and independent does not
+	failurePlan := func(policy string) *config.ExecutionPlan {
+		return &config.ExecutionPlan{
+			Name:    "Failure Policy Test",
+			Version: "1.0.0",
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{ID: "broken", Executor: "mock", OnFailure: policy, Files: []config.FileConfig{{Path: "broken.sh"}}},
+				{ID: "dependent", Executor: "mock", DependsOn: []string{"broken"}, Files: []config.FileConfig{{Path: "dependent.sh"}}},
+				{ID: "indirect", Executor: "mock", DependsOn: []string{"dependent"}, Files: []config.FileConfig{{Path: "indirect.sh"}}},
+				{ID: "independent", Executor: "mock", Files: []config.FileConfig{{Path: "independent.sh"}}},
+			},
+		}
+	}
+
+	newRunner := func(t *testing.T, policy string) (*Runner, *MockExecutor) {
+		runner, err := NewRunner(failurePlan(policy), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		mockExec := &MockExecutor{
+			name:          "mock",
+			executedFiles: []string{},
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				if file.Path == "broken.sh" {
+					err := errors.New("broken")
+					return &executors.ExecutionResult{Success: false, Error: err}, err
+				}
+				return &executors.ExecutionResult{Success: true}, nil
+			},
+		}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+		return runner, mockExec
+	}
+
+	t.Run("abort stops the run", func(t *testing.T) {
+		runner, mockExec := newRunner(t, "")
+
+		err := runner.Execute(context.Background())
+		require.Error(t, err)
+
+		var runErr *RunError
+		require.ErrorAs(t, err, &runErr)
+		assert.True(t, runErr.Aborted())
+		assert.Equal(t, []string{"broken"}, runErr.Failed)
+		assert.Equal(t, []string{"broken.sh"}, mockExec.GetExecutedFiles())
+		assert.Equal(t, []string{"broken"}, runner.State().FailedSteps)
+	})
+
+	t.Run("continue blocks dependents and runs independent steps", func(t *testing.T) {
+		runner, mockExec := newRunner(t, "continue")
+
+		var blocked []string
+		runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+			if event == "blocked" {
+				blocked = append(blocked, stepID+"<"+data.(map[string]interface{})["blocked_by"].(string))
+			}
+		})
+
+		err := runner.Execute(context.Background())
+		require.Error(t, err)
+
+		var runErr *RunError
+		require.ErrorAs(t, err, &runErr)
+		assert.False(t, runErr.Aborted())
+		assert.Equal(t, []string{"broken"}, runErr.Failed)
+		assert.Equal(t, []string{"dependent", "indirect"}, runErr.Blocked)
+		assert.Equal(t, "1 step(s) failed: broken; 2 step(s) blocked: dependent, indirect", err.Error())
+		assert.Equal(t, []string{"dependent<broken", "indirect<dependent"}, blocked)
+
+		assert.Equal(t, []string{"broken.sh", "independent.sh"}, mockExec.GetExecutedFiles())
+		state := runner.State()
+		assert.Equal(t, []string{"independent"}, state.CompletedSteps)
+		assert.Equal(t, []string{"broken"}, state.FailedSteps)
+	})
+
+	t.Run("ignore treats the failure as success", func(t *testing.T) {
+		runner, mockExec := newRunner(t, "ignore")
+
+		var warnings []string
+		runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+			if event == "failure_ignored" {
+				warnings = append(warnings, stepID)
+			}
+		})
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Equal(t, []string{"broken"}, warnings)
+		assert.Equal(t, []string{"broken.sh", "dependent.sh", "indirect.sh", "independent.sh"}, mockExec.GetExecutedFiles())
+		assert.Empty(t, runner.State().FailedSteps)
+	})
+
+	t.Run("successful rerun clears the failure", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+
+		runner, err := NewRunner(failurePlan("continue"), stateFile)
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", &MockExecutor{
+			name: "mock",
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				err := errors.New("broken")
+				return &executors.ExecutionResult{Success: false, Error: err}, err
+			},
+		}))
+		require.NoError(t, runner.SetOptions(RunOptions{Only: []string{"broken"}}))
+		require.Error(t, runner.Execute(context.Background()))
+		assert.Equal(t, []string{"broken"}, runner.State().FailedSteps)
+
+		runner, err = NewRunner(failurePlan("continue"), stateFile)
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", &MockExecutor{name: "mock"}))
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Empty(t, runner.State().FailedSteps)
+	})
+}
+
+func TestRunnerInterrupt(t *testing.T) {
+	plan := &config.ExecutionPlan{
+		Name:    "Interrupt Test",
+		Version: "1.0.0",
+		Executors: map[string]config.ExecutorConfig{
+			"mock": {"type": "mock"},
+		},
+		Steps: []config.Step{
+			{ID: "slow", Executor: "mock", OnFailure: "ignore", Files: []config.FileConfig{{Path: "slow.sh"}}, Rollback: []config.FileConfig{{Path: "undo.sh"}}},
+			{ID: "next", Executor: "mock", DependsOn: []string{"slow"}, Files: []config.FileConfig{{Path: "next.sh"}}},
+		},
+	}
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	runner, err := NewRunner(plan, stateFile)
+	require.NoError(t, err)
+	mockExec := &MockExecutor{
+		name:            "mock",
+		executedFiles:   []string{},
+		executionDelays: map[string]time.Duration{"slow.sh": 5 * time.Second},
+	}
+	require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+		if event == "started" {
+			cancel()
+		}
+	})
+
+	err = runner.Execute(ctx)
+	require.Error(t, err)
+
+	var runErr *RunError
+	require.ErrorAs(t, err, &runErr)
+	assert.True(t, runErr.WasInterrupted())
+	assert.Equal(t, []string{"slow"}, runErr.Interrupted)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Interruptions are neither ignored nor rolled back
+	assert.Equal(t, []string{"slow.sh"}, mockExec.GetExecutedFiles())
+	state := runner.State()
+	assert.Empty(t, state.CompletedSteps)
+	assert.Empty(t, state.FailedSteps)
+	assert.Empty(t, state.CurrentStep)
+	assert.Equal(t, []string{"slow"}, state.InterruptedSteps)
+	require.NotNil(t, state.InterruptedAt)
+
+	// The next run reports the interruption and resumes
+	runner, err = NewRunner(plan, stateFile)
+	require.NoError(t, err)
+	require.NoError(t, runner.RegisterExecutor("mock", &MockExecutor{name: "mock"}))
+
+	var resumed []string
+	runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+		if event == "resuming_interrupted" {
+			resumed = data.(map[string]interface{})["steps"].([]string)
+		}
+	})
+	require.NoError(t, runner.Execute(context.Background()))
+	assert.Equal(t, []string{"slow"}, resumed)
+	assert.Empty(t, runner.State().InterruptedSteps)
+}
@@ -0,0 +1,61 @@
+//go:build windows
+
+package core
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	// errorLockViolation is returned when another handle holds the lock
+	errorLockViolation syscall.Errno = 33
+)
+
+// lockRegion returns the locked byte range. It lies far beyond the contents
+// of the file, as locked ranges cannot be read by other processes.
+func lockRegion() *syscall.Overlapped {
+	return &syscall.Overlapped{Offset: 0xFFFFFFFE, OffsetHigh: 0x7FFFFFFF}
+}
+
+// lockFile takes an exclusive or shared lock on a file without waiting, and
+// reports whether the lock was taken
+func lockFile(file *os.File, exclusive bool) (bool, error) {
+	flags := uintptr(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	r1, _, err := procLockFileEx.Call(file.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(lockRegion()))) // #nosec G103 - required by the Windows API
+	if r1 == 0 {
+		if err == errorLockViolation {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// unlockFile releases the lock on a file
+func unlockFile(file *os.File) error {
+	r1, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(lockRegion()))) // #nosec G103 - required by the Windows API
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+// removeLockFile empties a lock file while its lock is still held. Open
+// files cannot be removed on Windows; an empty lock file records no holder
+// and is taken over by the next run.
+func removeLockFile(file *os.File, _ string) error {
+	return file.Truncate(0)
+}
@@ -0,0 +1,218 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/SphereStacking/plexr/internal/config"
+)
+
+var (
+	// varRefPattern matches the plan variables read by skip_if expressions
+	varRefPattern = regexp.MustCompile(`\bvars\.([A-Za-z_][A-Za-z0-9_]*)`)
+	// envRefPattern matches environment variable references in files and
+	// commands ($NAME, ${NAME}) and in skip_if expressions (env.NAME,
+	// env("NAME"), has_env("NAME"))
+	envRefPattern = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)|\benv\.([A-Za-z_][A-Za-z0-9_]*)|\benv\(\s*["']([A-Za-z_][A-Za-z0-9_]*)["']`)
+)
+
+// fingerprintInput is what a step fingerprint covers: everything that
+// decides what the step does, but not the outputs of other steps or the
+// plan's secrets, which may change on every run
+type fingerprintInput struct {
+	Executor        string                `json:"executor"`
+	ExecutorConfig  config.ExecutorConfig `json:"executor_config"`
+	WorkDirectory   string                `json:"work_directory"`
+	TransactionMode string                `json:"transaction_mode"`
+	Environment     map[string]string     `json:"environment"`
+	Files           []fingerprintFile     `json:"files"`
+	// Vars holds the plan variables the step's conditions read
+	Vars map[string]string `json:"vars,omitempty"`
+	// ReferencedEnv holds the process environment variables the step's
+	// files, commands and conditions reference, unless the plan sets them
+	ReferencedEnv map[string]string `json:"referenced_env,omitempty"`
+}
+
+// fingerprintFile describes a file of a step in its fingerprint
+type fingerprintFile struct {
+	Path     string `json:"path"`
+	Platform string `json:"platform"`
+	SkipIf   string `json:"skip_if"`
+	Content  string `json:"content"` // SHA-256 of the contents, empty if the file cannot be read
+}
+
+// stepFingerprint hashes the files, executor configuration, environment
+// variables and the plan variables of a step
+func (r *Runner) stepFingerprint(step *config.Step) (string, error) {
+	input := fingerprintInput{
+		Executor:        step.Executor,
+		ExecutorConfig:  redactSecrets(r.plan.Executors[step.Executor], r.plan.SecretValues),
+		WorkDirectory:   r.stepWorkDir(step),
+		TransactionMode: step.TransactionMode,
+		Environment:     make(map[string]string),
+	}
+	for _, vars := range []map[string]string{r.plan.Environment, step.Environment} {
+		for name, value := range vars {
+			input.Environment[name] = value
+		}
+	}
+
+	// Text that may reference variables: conditions, commands and files
+	sources := []string{step.SkipIf, step.CheckCommand}
+	for _, file := range step.Files {
+		sources = append(sources, file.SkipIf)
+		entry := fingerprintFile{Path: file.Path, Platform: file.Platform, SkipIf: file.SkipIf}
+		// A missing file is a change too; running the step reports it
+		if data, err := os.ReadFile(file.Path); err == nil {
+			sum := sha256.Sum256(data)
+			entry.Content = hex.EncodeToString(sum[:])
+			sources = append(sources, string(data))
+		}
+		input.Files = append(input.Files, entry)
+	}
+	input.Vars = r.referencedVars(sources)
+	input.ReferencedEnv = referencedEnv(sources, input.Environment)
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint step %s: %w", step.ID, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// redactSecrets returns a copy of an executor configuration in which the
+// values of secrets rendered into it are replaced with the names of the
+// secrets, so that rotating a secret does not change the fingerprint
+func redactSecrets(cfg config.ExecutorConfig, secrets map[string]string) config.ExecutorConfig {
+	if len(cfg) == 0 || len(secrets) == 0 {
+		return cfg
+	}
+
+	// Longer values first, so that a secret containing another is replaced whole
+	names := make([]string, 0, len(secrets))
+	for name, value := range secrets {
+		if value != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(secrets[names[i]]) != len(secrets[names[j]]) {
+			return len(secrets[names[i]]) > len(secrets[names[j]])
+		}
+		return names[i] < names[j]
+	})
+	pairs := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		pairs = append(pairs, secrets[name], "{{ secrets."+name+" }}")
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	var redact func(value interface{}) interface{}
+	redact = func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case string:
+			return replacer.Replace(v)
+		case map[string]interface{}:
+			redacted := make(map[string]interface{}, len(v))
+			for key, item := range v {
+				redacted[key] = redact(item)
+			}
+			return redacted
+		case []interface{}:
+			redacted := make([]interface{}, len(v))
+			for i, item := range v {
+				redacted[i] = redact(item)
+			}
+			return redacted
+		default:
+			return value
+		}
+	}
+
+	redacted := make(config.ExecutorConfig, len(cfg))
+	for key, value := range cfg {
+		redacted[key] = redact(value)
+	}
+	return redacted
+}
+
+// referencedVars returns the plan variables, for the runner's platform,
+// that sources reference
+func (r *Runner) referencedVars(sources []string) map[string]string {
+	var vars map[string]string
+	for _, source := range sources {
+		for _, match := range varRefPattern.FindAllStringSubmatch(source, -1) {
+			value, ok := r.plan.Platforms[r.platform][match[1]]
+			if !ok {
+				value = r.plan.Vars[match[1]]
+			}
+			if vars == nil {
+				vars = make(map[string]string)
+			}
+			vars[match[1]] = value
+		}
+	}
+	return vars
+}
+
+// referencedEnv returns the process environment variables that sources
+// reference, except those set by the plan, which are fingerprinted already
+func referencedEnv(sources []string, planEnv map[string]string) map[string]string {
+	var env map[string]string
+	for _, source := range sources {
+		for _, match := range envRefPattern.FindAllStringSubmatch(source, -1) {
+			name := match[1] + match[2] + match[3]
+			if _, ok := planEnv[name]; ok {
+				continue
+			}
+			if env == nil {
+				env = make(map[string]string)
+			}
+			env[name] = os.Getenv(name)
+		}
+	}
+	return env
+}
+
+// stepChanged reports whether a completed step changed since it last ran.
+// A step without a recorded fingerprint, such as one completed by an older
+// version, counts as changed if it runs again on changes, so that it gets one.
+func (r *Runner) stepChanged(step *config.Step) (bool, error) {
+	recorded := r.stateManager.StepFingerprint(step.ID)
+	if recorded == "" {
+		return r.rerunOnChange(step), nil
+	}
+	fingerprint, err := r.stepFingerprint(step)
+	if err != nil {
+		return false, err
+	}
+	return fingerprint != recorded, nil
+}
+
+// recordFingerprint records the current fingerprint of a step that completed
+func (r *Runner) recordFingerprint(step *config.Step) error {
+	fingerprint, err := r.stepFingerprint(step)
+	if err != nil {
+		return err
+	}
+	if err := r.stateManager.SetStepFingerprint(step.ID, fingerprint); err != nil {
+		return fmt.Errorf("failed to update step %s: %w", step.ID, err)
+	}
+	return nil
+}
+
+// rerunOnChange reports whether a changed step runs again: the step's
+// rerun_on_change setting, or else the plan's
+func (r *Runner) rerunOnChange(step *config.Step) bool {
+	if step.RerunOnChange != nil {
+		return *step.RerunOnChange
+	}
+	return r.plan.RerunOnChange
+}
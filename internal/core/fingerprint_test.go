@@ -0,0 +1,235 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/executors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnerRerunOnChange(t *testing.T) {
+	setup := func(t *testing.T) (*Runner, *MockExecutor, string, *[]bool) {
+		t.Helper()
+		tmpDir := t.TempDir()
+		script := filepath.Join(tmpDir, "schema.sql")
+		require.NoError(t, os.WriteFile(script, []byte("CREATE TABLE users (id INT);"), 0644))
+
+		plan := &config.ExecutionPlan{
+			Name:    "Rerun Test",
+			Version: "1.0.0",
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock", "database": "app"},
+			},
+			Steps: []config.Step{
+				{ID: "schema", Executor: "mock", Files: []config.FileConfig{{Path: script}}},
+			},
+		}
+		runner, err := NewRunner(plan, filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		var changes []bool
+		runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+			if event == "changed" {
+				changes = append(changes, data.(map[string]interface{})["rerun"].(bool))
+			}
+		})
+
+		require.NoError(t, runner.Execute(context.Background()))
+		require.Len(t, mockExec.GetExecutedFiles(), 1)
+		return runner, mockExec, script, &changes
+	}
+
+	t.Run("Records the fingerprint of executed steps", func(t *testing.T) {
+		runner, mockExec, _, changes := setup(t)
+
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		assert.Len(t, state.Steps["schema"].Fingerprint, 64)
+
+		// Unchanged steps stay completed
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 1)
+		assert.Empty(t, *changes)
+	})
+
+	t.Run("Warns about changed steps by default", func(t *testing.T) {
+		runner, mockExec, script, changes := setup(t)
+		require.NoError(t, os.WriteFile(script, []byte("CREATE TABLE users (id BIGINT);"), 0644))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 1)
+		assert.Equal(t, []bool{false}, *changes)
+		assert.True(t, runner.stateManager.IsStepCompleted("schema"))
+	})
+
+	t.Run("Runs changed steps again", func(t *testing.T) {
+		runner, mockExec, script, changes := setup(t)
+		runner.plan.RerunOnChange = true
+		before := runner.stateManager.StepFingerprint("schema")
+		require.NoError(t, os.WriteFile(script, []byte("CREATE TABLE users (id BIGINT);"), 0644))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 2)
+		assert.Equal(t, []bool{true}, *changes)
+		assert.True(t, runner.stateManager.IsStepCompleted("schema"))
+		assert.NotEqual(t, before, runner.stateManager.StepFingerprint("schema"))
+
+		// The new fingerprint is current
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 2)
+	})
+
+	t.Run("Executor configuration and environment are part of the fingerprint", func(t *testing.T) {
+		runner, mockExec, _, _ := setup(t)
+		runner.plan.RerunOnChange = true
+
+		runner.plan.Executors["mock"]["database"] = "app_test"
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 2)
+
+		runner.plan.Steps[0].Environment = map[string]string{"DB_HOST": "db.internal"}
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 3)
+
+		// Secrets are not
+		runner.plan.SecretValues = map[string]string{"db_password": "hunter2"}
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 3)
+	})
+
+	t.Run("Secrets rendered into the executor configuration are not part of the fingerprint", func(t *testing.T) {
+		runner, mockExec, _, _ := setup(t)
+		runner.plan.RerunOnChange = true
+
+		runner.plan.SecretValues = map[string]string{"db_password": "hunter2"}
+		runner.plan.Executors["mock"]["password"] = "hunter2"
+		runner.plan.Executors["mock"]["options"] = map[string]interface{}{"dsn": "postgres://app:hunter2@db/app"}
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 2)
+
+		// Rotating the secret keeps the step completed
+		runner.plan.SecretValues["db_password"] = "correct horse"
+		runner.plan.Executors["mock"]["password"] = "correct horse"
+		runner.plan.Executors["mock"]["options"] = map[string]interface{}{"dsn": "postgres://app:correct horse@db/app"}
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 2)
+
+		// Other changes to the configuration still count
+		runner.plan.Executors["mock"]["options"] = map[string]interface{}{"dsn": "postgres://app:correct horse@db/app_test"}
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 3)
+	})
+
+	t.Run("Step setting overrides the plan", func(t *testing.T) {
+		runner, mockExec, script, changes := setup(t)
+		runner.plan.RerunOnChange = true
+		rerun := false
+		runner.plan.Steps[0].RerunOnChange = &rerun
+		require.NoError(t, os.WriteFile(script, []byte("DROP TABLE users;"), 0644))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 1)
+		assert.Equal(t, []bool{false}, *changes)
+	})
+
+	t.Run("Check command does not skip a changed step", func(t *testing.T) {
+		runner, mockExec, script, _ := setup(t)
+		runner.plan.RerunOnChange = true
+		runner.plan.Steps[0].CheckCommand = "exit 0"
+		require.NoError(t, os.WriteFile(script, []byte("CREATE INDEX users_id ON users (id);"), 0644))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 2)
+	})
+
+	t.Run("A failed rerun leaves the step incomplete", func(t *testing.T) {
+		runner, mockExec, script, _ := setup(t)
+		runner.plan.RerunOnChange = true
+		mockExec.executeFunc = func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+			err := errors.New("syntax error at end of input")
+			return &executors.ExecutionResult{Success: false, Error: err}, err
+		}
+		require.NoError(t, os.WriteFile(script, []byte("CREATE TABLE"), 0644))
+
+		require.Error(t, runner.Execute(context.Background()))
+		assert.False(t, runner.stateManager.IsStepCompleted("schema"))
+
+		// The next run tries again, though the fingerprint did not change since
+		require.Error(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 3)
+	})
+
+	t.Run("Referenced vars and environment are part of the fingerprint", func(t *testing.T) {
+		runner, mockExec, script, _ := setup(t)
+		runner.plan.RerunOnChange = true
+		runner.plan.Vars = map[string]string{"env": "dev", "unused": "a"}
+		runner.plan.Steps[0].SkipIf = `vars.env == "production"`
+		require.NoError(t, os.WriteFile(script, []byte("CREATE DATABASE ${PLEXR_TEST_DB_NAME};"), 0644))
+		t.Setenv("PLEXR_TEST_DB_NAME", "app")
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 2)
+
+		// Variables the step does not reference are not
+		runner.plan.Vars["unused"] = "b"
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 2)
+
+		runner.plan.Vars["env"] = "staging"
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 3)
+
+		t.Setenv("PLEXR_TEST_DB_NAME", "app_test")
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 4)
+	})
+
+	t.Run("Steps completed without executing record a fingerprint", func(t *testing.T) {
+		runner, mockExec, script, changes := setup(t)
+		runner.plan.RerunOnChange = true
+		runner.plan.Steps[0].CheckCommand = "exit 0"
+		require.NoError(t, runner.stateManager.ResetSteps([]string{"schema"}))
+
+		// Satisfied by the check command
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 1)
+		assert.NotEmpty(t, runner.stateManager.StepFingerprint("schema"))
+
+		require.NoError(t, os.WriteFile(script, []byte("CREATE TABLE users (id BIGINT);"), 0644))
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 2)
+		assert.Equal(t, []bool{true}, *changes)
+
+		// Skipped by skip_if
+		runner.plan.Steps[0].CheckCommand = ""
+		runner.plan.Steps[0].SkipIf = `os != ""`
+		require.NoError(t, runner.stateManager.ResetSteps([]string{"schema"}))
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.NotEmpty(t, runner.stateManager.StepFingerprint("schema"))
+	})
+
+	t.Run("Steps without a fingerprint", func(t *testing.T) {
+		runner, mockExec, _, changes := setup(t)
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		state.Steps["schema"].Fingerprint = ""
+		require.NoError(t, runner.stateManager.Save(state))
+
+		// Not reported as changed unless the step runs again on changes
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 1)
+		assert.Empty(t, *changes)
+
+		runner.plan.RerunOnChange = true
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Len(t, mockExec.GetExecutedFiles(), 2)
+		assert.NotEmpty(t, runner.stateManager.StepFingerprint("schema"))
+	})
+}
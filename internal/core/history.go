@@ -0,0 +1,235 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SphereStacking/plexr/internal/utils"
+)
+
+// Outcomes of a run
+const (
+	OutcomeSucceeded   = "succeeded"
+	OutcomeFailed      = "failed"
+	OutcomePartial     = "partial" // Steps failed with on_failure: continue, independent steps completed
+	OutcomeInterrupted = "interrupted"
+)
+
+// Statuses of steps in a run record that did not execute during the run
+const (
+	StatusAlreadyCompleted = "already_completed" // Completed by an earlier run
+	StatusBlocked          = "blocked"           // Not run because a dependency failed
+	StatusNotRun           = "not_run"           // Not reached before the run stopped
+)
+
+// RunRecord describes a single run of a plan in the history journal
+type RunRecord struct {
+	ID            string          `json:"id"`
+	PlanName      string          `json:"plan_name"`
+	PlanVersion   string          `json:"plan_version"`
+	Platform      string          `json:"platform"`
+	StartedAt     time.Time       `json:"started_at"`
+	FinishedAt    time.Time       `json:"finished_at"`
+	Duration      int64           `json:"duration_ms"` // in milliseconds
+	Outcome       string          `json:"outcome"`
+	Error         string          `json:"error,omitempty"`
+	SelectedSteps []string        `json:"selected_steps"`
+	Steps         []RunStepRecord `json:"steps"`
+}
+
+// RunStepRecord describes what happened to a selected step during a run
+type RunStepRecord struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Duration int64  `json:"duration_ms,omitempty"` // in milliseconds
+	Attempts int    `json:"attempts,omitempty"`    // Attempts of the step's files, including retries
+	Reason   string `json:"reason,omitempty"`      // Why the step was skipped
+	Error    string `json:"error,omitempty"`
+}
+
+// HistoryPath returns the path of the run history journal of a state file
+func HistoryPath(stateFile string) string {
+	return stateFile + ".history"
+}
+
+// newRunID returns a unique, chronologically sortable run ID
+func newRunID(start time.Time) string {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		// The timestamp alone is still unique for runs holding the state lock
+		return start.UTC().Format("20060102T150405.000")
+	}
+	return start.UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// runOutcome classifies the error returned by a run
+func runOutcome(err error) string {
+	if err == nil {
+		return OutcomeSucceeded
+	}
+	var runErr *RunError
+	if errors.As(err, &runErr) {
+		switch {
+		case runErr.WasInterrupted():
+			return OutcomeInterrupted
+		case !runErr.Aborted():
+			return OutcomePartial
+		}
+	}
+	return OutcomeFailed
+}
+
+// runRecord builds the history record of a run from the error it returned
+// and the step records it left in the state
+func (r *Runner) runRecord(id string, start time.Time, runErr error) RunRecord {
+	end := time.Now()
+	record := RunRecord{
+		ID:            id,
+		PlanName:      r.plan.Name,
+		PlanVersion:   r.plan.Version,
+		Platform:      r.platform,
+		StartedAt:     start,
+		FinishedAt:    end,
+		Duration:      end.Sub(start).Milliseconds(),
+		Outcome:       runOutcome(runErr),
+		SelectedSteps: append([]string{}, r.selected...),
+		Steps:         []RunStepRecord{},
+	}
+	if runErr != nil {
+		record.Error = runErr.Error()
+	}
+
+	blocked := make(map[string]bool)
+	var failure *RunError
+	if errors.As(runErr, &failure) {
+		for _, stepID := range failure.Blocked {
+			blocked[stepID] = true
+		}
+	}
+
+	r.stateManager.mu.RLock()
+	defer r.stateManager.mu.RUnlock()
+	state := r.stateManager.state
+
+	for _, stepID := range r.selected {
+		step := RunStepRecord{ID: stepID, Status: StatusNotRun}
+		var executed *StepRecord
+		if state != nil {
+			executed = state.Steps[stepID]
+		}
+
+		switch {
+		case executed != nil && !executed.StartedAt.Before(start):
+			step.Status = executed.Status
+			step.Duration = executed.Duration
+			step.Reason = executed.Reason
+			step.Error = executed.Error
+			for _, file := range executed.Files {
+				step.Attempts += file.Attempts
+			}
+		case blocked[stepID]:
+			step.Status = StatusBlocked
+		case state != nil && containsString(state.CompletedSteps, stepID):
+			step.Status = StatusAlreadyCompleted
+		}
+		record.Steps = append(record.Steps, step)
+	}
+	return record
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// AppendRun appends a run record to the history journal of a state file.
+// Every record is a line of JSON with secret values masked.
+func AppendRun(stateFile string, record RunRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal run record: %w", err)
+	}
+	data = append(utils.MaskSecretsJSON(data), '\n')
+
+	path := HistoryPath(stateFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600) // #nosec G304 - the journal is derived from the state file path
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer file.Close() // #nosec G307 - errors are reported by Sync
+
+	// A record cut short by a crash must not swallow the next one
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// ReadHistory reads the run records of the history journal of a state file,
+// oldest first. A missing journal has no records; lines that cannot be parsed,
+// such as a record cut short by a crash, are skipped.
+func ReadHistory(stateFile string) ([]RunRecord, error) {
+	data, err := os.ReadFile(HistoryPath(stateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	var records []RunRecord
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		var record RunRecord
+		if json.Unmarshal(line, &record) == nil && record.ID != "" {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// FindRun returns the run whose ID is id or starts with it
+func FindRun(records []RunRecord, id string) (*RunRecord, error) {
+	var matches []*RunRecord
+	for i := range records {
+		if records[i].ID == id {
+			return &records[i], nil
+		}
+		if id != "" && strings.HasPrefix(records[i].ID, id) {
+			matches = append(matches, &records[i])
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("run not found: %s", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("run ID %s is ambiguous: it matches %d runs", id, len(matches))
+	}
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/executors"
+	"github.com/SphereStacking/plexr/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	t.Run("Append and read records", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+
+		records, err := ReadHistory(stateFile)
+		require.NoError(t, err)
+		assert.Empty(t, records)
+
+		require.NoError(t, AppendRun(stateFile, RunRecord{ID: "20250101T100000-aaaaaa", Outcome: OutcomeFailed}))
+		require.NoError(t, AppendRun(stateFile, RunRecord{ID: "20250101T110000-bbbbbb", Outcome: OutcomeSucceeded}))
+
+		records, err = ReadHistory(stateFile)
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, "20250101T100000-aaaaaa", records[0].ID)
+		assert.Equal(t, OutcomeSucceeded, records[1].Outcome)
+	})
+
+	t.Run("Record cut short by a crash", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		require.NoError(t, AppendRun(stateFile, RunRecord{ID: "run1"}))
+
+		file, err := os.OpenFile(HistoryPath(stateFile), os.O_WRONLY|os.O_APPEND, 0600)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"id": "run2", "outco`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		require.NoError(t, AppendRun(stateFile, RunRecord{ID: "run3"}))
+
+		records, err := ReadHistory(stateFile)
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, "run1", records[0].ID)
+		assert.Equal(t, "run3", records[1].ID)
+	})
+
+	t.Run("Secrets are masked", func(t *testing.T) {
+		utils.AddSecret("hunter2-token")
+		t.Cleanup(utils.ResetSecrets)
+
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		require.NoError(t, AppendRun(stateFile, RunRecord{ID: "run1", Error: "token hunter2-token rejected"}))
+
+		data, err := os.ReadFile(HistoryPath(stateFile))
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "hunter2-token")
+	})
+
+	t.Run("FindRun", func(t *testing.T) {
+		records := []RunRecord{
+			{ID: "20250101T100000-aaaaaa"},
+			{ID: "20250101T100000-abcdef"},
+			{ID: "20250102T090000-123456"},
+		}
+
+		run, err := FindRun(records, "20250101T100000-abcdef")
+		require.NoError(t, err)
+		assert.Equal(t, "20250101T100000-abcdef", run.ID)
+
+		run, err = FindRun(records, "20250102")
+		require.NoError(t, err)
+		assert.Equal(t, "20250102T090000-123456", run.ID)
+
+		_, err = FindRun(records, "20250101")
+		assert.ErrorContains(t, err, "ambiguous")
+
+		_, err = FindRun(records, "2024")
+		assert.ErrorContains(t, err, "run not found")
+	})
+
+	t.Run("Runs are recorded", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		plan := &config.ExecutionPlan{
+			Name:    "History Test",
+			Version: "2.0.0",
+			Steps: []config.Step{
+				{ID: "install", Executor: "mock", Files: []config.FileConfig{{Path: "install.sh"}}},
+				{ID: "migrate", Executor: "mock", OnFailure: "continue", DependsOn: []string{"install"}, Files: []config.FileConfig{{Path: "migrate.sh"}}},
+				{ID: "seed", Executor: "mock", DependsOn: []string{"migrate"}, Files: []config.FileConfig{{Path: "seed.sh"}}},
+				{ID: "docs", Executor: "mock", SkipIf: "true", Files: []config.FileConfig{{Path: "docs.sh"}}},
+			},
+		}
+
+		failMigrate := true
+		mockExec := &MockExecutor{
+			name: "mock",
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				if file.Path == "migrate.sh" && failMigrate {
+					return nil, errors.New("migration failed")
+				}
+				return &executors.ExecutionResult{Success: true}, nil
+			},
+		}
+
+		runner, err := NewRunner(plan, stateFile)
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+		require.Error(t, runner.Execute(context.Background()))
+		firstID := runner.RunID()
+
+		// The second run completes the remaining steps
+		failMigrate = false
+		time.Sleep(10 * time.Millisecond)
+		runner, err = NewRunner(plan, stateFile)
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+		require.NoError(t, runner.Execute(context.Background()))
+
+		records, err := ReadHistory(stateFile)
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+
+		first := records[0]
+		assert.Equal(t, firstID, first.ID)
+		assert.Equal(t, "History Test", first.PlanName)
+		assert.Equal(t, "2.0.0", first.PlanVersion)
+		assert.Equal(t, OutcomePartial, first.Outcome)
+		assert.Contains(t, first.Error, "migrate")
+		assert.ElementsMatch(t, []string{"install", "migrate", "seed", "docs"}, first.SelectedSteps)
+		statuses := make(map[string]string)
+		for _, step := range first.Steps {
+			statuses[step.ID] = step.Status
+		}
+		assert.Equal(t, map[string]string{
+			"install": StatusCompleted,
+			"migrate": StatusFailed,
+			"seed":    StatusBlocked,
+			"docs":    StatusSkipped,
+		}, statuses)
+
+		second := records[1]
+		assert.NotEqual(t, first.ID, second.ID)
+		assert.Equal(t, OutcomeSucceeded, second.Outcome)
+		statuses = make(map[string]string)
+		for _, step := range second.Steps {
+			statuses[step.ID] = step.Status
+		}
+		assert.Equal(t, map[string]string{
+			"install": StatusAlreadyCompleted,
+			"migrate": StatusCompleted,
+			"seed":    StatusCompleted,
+			"docs":    StatusAlreadyCompleted,
+		}, statuses)
+	})
+}
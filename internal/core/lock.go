@@ -0,0 +1,266 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockAttempts bounds how often a lock file that was replaced while it was
+// being locked is opened again
+const lockAttempts = 3
+
+// LockInfo describes the process holding the lock of a state file
+type LockInfo struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// LockedError reports that another process holds the lock of a state file,
+// or of a state in a shared state store
+type LockedError struct {
+	Path   string
+	Holder LockInfo
+	// Shared reports a lock held in a shared state store, which releases
+	// the locks of runs that ended by itself
+	Shared bool
+}
+
+func (e *LockedError) Error() string {
+	if e.Shared {
+		return fmt.Sprintf("plan is already running: the state in %s is locked by another run", e.Path)
+	}
+	if e.Holder.PID == 0 {
+		return fmt.Sprintf("plan is already running (lock file %s)", e.Path)
+	}
+	return fmt.Sprintf("plan is already running: locked by pid %d on %s since %s (lock file %s)",
+		e.Holder.PID, e.Holder.Host, e.Holder.StartedAt.Format(time.RFC3339), e.Path)
+}
+
+// StateLock is a lock on a state file, held by a process for the duration of
+// a run. It is an OS advisory lock on a lock file next to the state file,
+// which the operating system releases when the process exits. The lock file
+// records the holder, so that other processes can report who is running the plan.
+type StateLock struct {
+	path string
+	info LockInfo
+	file *os.File
+	// unlockStore releases the lock held in a shared state store, if any
+	unlockStore func() error
+}
+
+// LockPath returns the path of the lock file of a state file
+func LockPath(stateFile string) string {
+	return stateFile + ".lock"
+}
+
+// AcquireLock locks a state file. A lock file left behind by a process that
+// no longer holds it is taken over, unless it was written on another host,
+// whose processes cannot be seen from here. Locks held by running processes
+// or by other hosts fail with a *LockedError.
+func AcquireLock(stateFile string) (*StateLock, error) {
+	host := lockHost()
+	lock := &StateLock{
+		path: LockPath(stateFile),
+		info: LockInfo{PID: os.Getpid(), Host: host, StartedAt: time.Now()},
+	}
+	data, err := json.Marshal(lock.info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lock: %w", err)
+	}
+
+	// The lock is the first file written for a state file, which may be in
+	// a state directory that does not exist yet
+	if err := os.MkdirAll(filepath.Dir(lock.path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	for attempt := 0; attempt < lockAttempts; attempt++ {
+		file, err := os.OpenFile(lock.path, os.O_RDWR|os.O_CREATE, 0600) // #nosec G304 - lock files are derived from the state file path
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+
+		locked, err := lockFile(file, true)
+		if err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", lock.path, err)
+		}
+		if !locked {
+			holder, _ := readLockInfo(file)
+			_ = file.Close()
+			return nil, &LockedError{Path: lock.path, Holder: holder}
+		}
+
+		// The previous holder removes the lock file when it releases the
+		// lock; a lock on a removed file locks nothing
+		if !isLockFile(file, lock.path) {
+			_ = file.Close()
+			continue
+		}
+
+		if holder, ok := readLockInfo(file); ok && holder.Host != host {
+			_ = file.Close()
+			return nil, &LockedError{Path: lock.path, Holder: holder}
+		}
+
+		if err := writeLockInfo(file, data); err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("failed to write lock file: %w", err)
+		}
+		lock.file = file
+		return lock, nil
+	}
+	return nil, &LockedError{Path: lock.path}
+}
+
+// Lock locks the state for a run: the lock file of stateFile and, for state
+// stores shared between hosts, the state in the store
+func (sm *StateManager) Lock(stateFile string) (*StateLock, error) {
+	lock, err := AcquireLock(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	if locker, ok := sm.store.(storeLocker); ok {
+		unlock, err := locker.lock()
+		if err != nil {
+			_ = lock.Release()
+			return nil, err
+		}
+		lock.unlockStore = unlock
+	}
+	return lock, nil
+}
+
+// CheckLock reports a *LockedError if the lock of a state file is held by
+// another process, without taking the lock
+func CheckLock(stateFile string) error {
+	path := LockPath(stateFile)
+	file, err := os.Open(path) // #nosec G304 - lock files are derived from the state file path
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open lock file: %w", err)
+	}
+	defer file.Close()
+
+	holder, ok := readLockInfo(file)
+	locked, err := lockFile(file, false)
+	if err != nil {
+		return fmt.Errorf("failed to check lock %s: %w", path, err)
+	}
+	if locked {
+		_ = unlockFile(file)
+		if !ok || holder.Host == lockHost() {
+			return nil
+		}
+	}
+	return &LockedError{Path: path, Holder: holder}
+}
+
+// lockHost returns the host name recorded in lock files
+func lockHost() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// isLockFile reports whether an open file is still the lock file at path
+func isLockFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(opened, current)
+}
+
+// readLockInfo reads the holder recorded in a lock file and reports whether
+// the file records one
+func readLockInfo(file *os.File) (LockInfo, bool) {
+	var holder LockInfo
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<20))
+	if err != nil || json.Unmarshal(data, &holder) != nil || holder.PID <= 0 {
+		return LockInfo{}, false
+	}
+	return holder, true
+}
+
+// writeLockInfo replaces the contents of a locked lock file
+func writeLockInfo(file *os.File, data []byte) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Release releases the lock and removes the lock file, unless the lock file
+// has been taken over by another process
+func (l *StateLock) Release() error {
+	var storeErr error
+	if l.unlockStore != nil {
+		storeErr = l.unlockStore()
+		l.unlockStore = nil
+	}
+	if err := l.releaseFile(); err != nil {
+		return err
+	}
+	return storeErr
+}
+
+// releaseFile releases the lock of the lock file
+func (l *StateLock) releaseFile() error {
+	if l.file == nil {
+		return nil
+	}
+	defer func() {
+		_ = l.file.Close() // Closing the file releases the OS lock
+		l.file = nil
+	}()
+
+	if !isLockFile(l.file, l.path) {
+		// Removed by ForceUnlock, and possibly locked again since
+		return nil
+	}
+	holder, ok := readLockInfo(l.file)
+	if !ok || holder.PID != l.info.PID || !holder.StartedAt.Equal(l.info.StartedAt) {
+		return nil
+	}
+	if err := removeLockFile(l.file, l.path); err != nil {
+		return fmt.Errorf("failed to remove lock file: %w", err)
+	}
+	return nil
+}
+
+// ForceUnlock removes the lock of a state file regardless of its holder and
+// returns the removed holder, or nil if the state file was not locked
+func ForceUnlock(stateFile string) (*LockInfo, error) {
+	path := LockPath(stateFile)
+	data, err := os.ReadFile(path) // #nosec G304 - lock files are derived from the state file path
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read lock file: %w", err)
+	}
+
+	var holder LockInfo
+	_ = json.Unmarshal(data, &holder) // A corrupt lock is removed all the same
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove lock file: %w", err)
+	}
+	return &holder, nil
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLock writes a lock file held by holder
+func writeLock(t *testing.T, stateFile string, holder LockInfo) {
+	t.Helper()
+	data, err := json.Marshal(holder)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(LockPath(stateFile), data, 0600))
+}
+
+// exitedPID returns the PID of a process that has exited
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$") // #nosec G204 - runs the test binary itself
+	require.NoError(t, cmd.Run())
+	return cmd.Process.Pid
+}
+
+func TestStateLock(t *testing.T) {
+	t.Run("Lock is exclusive until released", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+
+		lock, err := AcquireLock(stateFile)
+		require.NoError(t, err)
+		assert.FileExists(t, LockPath(stateFile))
+
+		_, err = AcquireLock(stateFile)
+		var lockedErr *LockedError
+		require.True(t, errors.As(err, &lockedErr))
+		assert.Equal(t, os.Getpid(), lockedErr.Holder.PID)
+		assert.Contains(t, err.Error(), "plan is already running")
+		assert.Error(t, CheckLock(stateFile))
+
+		require.NoError(t, lock.Release())
+		assert.NoFileExists(t, LockPath(stateFile))
+		assert.NoError(t, CheckLock(stateFile))
+
+		lock, err = AcquireLock(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, lock.Release())
+	})
+
+	t.Run("Lock creates the state directory", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state", "plexr", "plan.json")
+
+		lock, err := AcquireLock(stateFile)
+		require.NoError(t, err)
+		assert.FileExists(t, LockPath(stateFile))
+		require.NoError(t, lock.Release())
+	})
+
+	t.Run("Stale lock of an exited process is taken over", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		writeLock(t, stateFile, LockInfo{PID: exitedPID(t), Host: lockHost(), StartedAt: time.Now()})
+
+		assert.NoError(t, CheckLock(stateFile))
+		lock, err := AcquireLock(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, lock.Release())
+	})
+
+	t.Run("Lock of another host is not stale", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		writeLock(t, stateFile, LockInfo{PID: exitedPID(t), Host: "other-host", StartedAt: time.Now()})
+
+		_, err := AcquireLock(stateFile)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "on other-host")
+	})
+
+	t.Run("Unreadable lock", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		require.NoError(t, os.WriteFile(LockPath(stateFile), []byte("{"), 0600))
+
+		// A holder writes the lock file while holding the lock
+		assert.NoError(t, CheckLock(stateFile))
+		lock, err := AcquireLock(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, lock.Release())
+	})
+
+	t.Run("Lock file of a running process is free without the OS lock", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		writeLock(t, stateFile, LockInfo{PID: os.Getpid(), Host: lockHost(), StartedAt: time.Now()})
+
+		lock, err := AcquireLock(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, lock.Release())
+	})
+
+	t.Run("Concurrent acquirers", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		writeLock(t, stateFile, LockInfo{PID: exitedPID(t), Host: lockHost(), StartedAt: time.Now()})
+
+		const acquirers = 16
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			locks []*StateLock
+			start = make(chan struct{})
+		)
+		for i := 0; i < acquirers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				lock, err := AcquireLock(stateFile)
+				if err != nil {
+					var lockedErr *LockedError
+					assert.True(t, errors.As(err, &lockedErr), "unexpected error: %v", err)
+					return
+				}
+				mu.Lock()
+				locks = append(locks, lock)
+				mu.Unlock()
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		require.Len(t, locks, 1)
+		assert.Error(t, CheckLock(stateFile))
+		require.NoError(t, locks[0].Release())
+		assert.NoError(t, CheckLock(stateFile))
+	})
+
+	t.Run("Release keeps a lock taken over by another process", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		lock, err := AcquireLock(stateFile)
+		require.NoError(t, err)
+
+		other := LockInfo{PID: os.Getpid() + 1, Host: lockHost(), StartedAt: time.Now()}
+		writeLock(t, stateFile, other)
+		require.NoError(t, lock.Release())
+		assert.FileExists(t, LockPath(stateFile))
+	})
+
+	t.Run("ForceUnlock", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+
+		holder, err := ForceUnlock(stateFile)
+		require.NoError(t, err)
+		assert.Nil(t, holder)
+
+		writeLock(t, stateFile, LockInfo{PID: 4242, Host: "other-host", StartedAt: time.Now()})
+		holder, err = ForceUnlock(stateFile)
+		require.NoError(t, err)
+		require.NotNil(t, holder)
+		assert.Equal(t, 4242, holder.PID)
+		assert.NoFileExists(t, LockPath(stateFile))
+	})
+
+	t.Run("Execute fails while the plan is running", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		plan := &config.ExecutionPlan{
+			Name:    "Lock Test",
+			Version: "1.0.0",
+			Steps:   []config.Step{{ID: "step1", Executor: "mock", Files: []config.FileConfig{{Path: "step1.sh"}}}},
+		}
+		runner, err := NewRunner(plan, stateFile)
+		require.NoError(t, err)
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		lock, err := AcquireLock(stateFile)
+		require.NoError(t, err)
+
+		err = runner.Execute(context.Background())
+		var lockedErr *LockedError
+		assert.True(t, errors.As(err, &lockedErr))
+		assert.Empty(t, mockExec.GetExecutedFiles())
+
+		require.NoError(t, lock.Release())
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Equal(t, []string{"step1.sh"}, mockExec.GetExecutedFiles())
+		assert.NoFileExists(t, LockPath(stateFile))
+	})
+}
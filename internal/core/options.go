@@ -0,0 +1,266 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/SphereStacking/plexr/internal/config"
+)
+
+// DependencyMode controls how dependencies outside the selected steps are treated
+type DependencyMode string
+
+const (
+	// DependenciesAssume treats unselected dependencies as satisfied
+	DependenciesAssume DependencyMode = "assume"
+	// DependenciesEnforce requires unselected dependencies to be completed already
+	DependenciesEnforce DependencyMode = "enforce"
+)
+
+// PlanChangeMode controls what a run does when the state was recorded for
+// another plan name or version
+type PlanChangeMode string
+
+const (
+	// PlanChangeAbort stops the run with a *PlanChangedError
+	PlanChangeAbort PlanChangeMode = "abort"
+	// PlanChangeReset starts over with a new state
+	PlanChangeReset PlanChangeMode = "reset"
+	// PlanChangeContinue keeps the progress recorded for the previous plan
+	PlanChangeContinue PlanChangeMode = "continue"
+	// PlanChangeNewSteps keeps the progress and runs only the steps added to the plan
+	PlanChangeNewSteps PlanChangeMode = "new-steps"
+)
+
+// RunOptions controls which steps a runner executes and for which platform
+type RunOptions struct {
+	FromStep     string         // Skip steps that come before this step in execution order
+	Dependencies DependencyMode // How dependencies left out by FromStep or SkipTags are treated (default: assume)
+	Only         []string       // Run only these steps and their dependencies
+	Tags         []string       // Run only steps with any of these tags and their dependencies
+	SkipTags     []string       // Never run steps with any of these tags
+	Platform     string         // Target platform (default: runtime.GOOS)
+	OnPlanChange PlanChangeMode // What to do when the state was recorded for another plan name or version (default: abort)
+}
+
+// SetOptions applies run options to the runner
+func (r *Runner) SetOptions(opts RunOptions) error {
+	switch opts.Dependencies {
+	case "":
+		opts.Dependencies = DependenciesAssume
+	case DependenciesAssume, DependenciesEnforce:
+	default:
+		return fmt.Errorf("invalid dependency mode '%s' (must be %s or %s)", opts.Dependencies, DependenciesAssume, DependenciesEnforce)
+	}
+
+	switch opts.OnPlanChange {
+	case "":
+		opts.OnPlanChange = PlanChangeAbort
+	case PlanChangeAbort, PlanChangeReset, PlanChangeContinue, PlanChangeNewSteps:
+	default:
+		return fmt.Errorf("invalid plan change mode '%s' (must be %s, %s, %s or %s)", opts.OnPlanChange, PlanChangeAbort, PlanChangeReset, PlanChangeContinue, PlanChangeNewSteps)
+	}
+
+	if opts.FromStep != "" && findStep(r.plan, opts.FromStep) == nil {
+		return fmt.Errorf("step not found: %s", opts.FromStep)
+	}
+	for _, id := range opts.Only {
+		if findStep(r.plan, id) == nil {
+			return fmt.Errorf("step not found: %s", id)
+		}
+	}
+	if err := checkTags(r.plan, append(append([]string{}, opts.Tags...), opts.SkipTags...)); err != nil {
+		return err
+	}
+
+	r.options = opts
+	if opts.Platform != "" {
+		r.platform = opts.Platform
+	}
+	r.shell.SetPlatform(r.platform)
+	return nil
+}
+
+// ParseStepList splits a comma-separated list of step IDs
+func ParseStepList(list string) []string {
+	var ids []string
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// SelectSteps returns the steps selected by opts in execution order.
+// --only and --tags selections automatically include the dependencies of
+// the selected steps; when both are given, a step must match both.
+// --skip-tags drops the tagged steps even when other steps depend on them,
+// and --from-step drops every step ordered before the given step; see
+// UnmetDependencies for the dependencies this leaves unsatisfied.
+func SelectSteps(plan *config.ExecutionPlan, opts RunOptions) ([]string, error) {
+	order, err := executionOrder(plan)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTags(plan, append(append([]string{}, opts.Tags...), opts.SkipTags...)); err != nil {
+		return nil, err
+	}
+
+	only := make(map[string]bool, len(opts.Only))
+	for _, id := range opts.Only {
+		if findStep(plan, id) == nil {
+			return nil, fmt.Errorf("step not found: %s", id)
+		}
+		only[id] = true
+	}
+
+	skipped := func(step *config.Step) bool {
+		return step.HasTag(opts.SkipTags...)
+	}
+
+	if len(opts.Only) > 0 || len(opts.Tags) > 0 {
+		wanted := make(map[string]bool)
+		var include func(step *config.Step)
+		include = func(step *config.Step) {
+			if wanted[step.ID] || skipped(step) {
+				return
+			}
+			wanted[step.ID] = true
+			for _, dep := range step.DependsOn {
+				if depStep := findStep(plan, dep); depStep != nil {
+					include(depStep)
+				}
+			}
+		}
+		for i := range plan.Steps {
+			step := &plan.Steps[i]
+			if (len(opts.Only) == 0 || only[step.ID]) && (len(opts.Tags) == 0 || step.HasTag(opts.Tags...)) {
+				include(step)
+			}
+		}
+
+		var selected []string
+		for _, id := range order {
+			if wanted[id] {
+				selected = append(selected, id)
+			}
+		}
+		order = selected
+	} else if len(opts.SkipTags) > 0 {
+		var selected []string
+		for _, id := range order {
+			if !skipped(findStep(plan, id)) {
+				selected = append(selected, id)
+			}
+		}
+		order = selected
+	}
+
+	if len(order) == 0 {
+		return nil, fmt.Errorf("no steps selected")
+	}
+
+	if opts.FromStep != "" {
+		start := -1
+		for i, id := range order {
+			if id == opts.FromStep {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return nil, fmt.Errorf("step %s is not part of the selected steps", opts.FromStep)
+		}
+		order = order[start:]
+	}
+
+	return order, nil
+}
+
+// checkTags returns an error for tags that no step of the plan has
+func checkTags(plan *config.ExecutionPlan, tags []string) error {
+	for _, tag := range tags {
+		found := false
+		for i := range plan.Steps {
+			if plan.Steps[i].HasTag(tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("tag not found: %s", tag)
+		}
+	}
+	return nil
+}
+
+// UnselectedDependencies returns the dependencies of the selected steps
+// that are not selected themselves
+func UnselectedDependencies(plan *config.ExecutionPlan, order []string) []string {
+	selected := make(map[string]bool, len(order))
+	for _, id := range order {
+		selected[id] = true
+	}
+
+	var deps []string
+	seen := make(map[string]bool)
+	for _, id := range order {
+		step := findStep(plan, id)
+		if step == nil {
+			continue
+		}
+		for _, dep := range step.DependsOn {
+			if !selected[dep] && !seen[dep] {
+				seen[dep] = true
+				deps = append(deps, dep)
+			}
+		}
+	}
+	return deps
+}
+
+// UnmetDependencies returns the dependencies of the selected steps that are
+// neither selected nor completed in state, such as steps left out by
+// --skip-tags that never ran
+func UnmetDependencies(plan *config.ExecutionPlan, order []string, state *ExecutionState) []string {
+	completed := make(map[string]bool, len(state.CompletedSteps))
+	for _, id := range state.CompletedSteps {
+		completed[id] = true
+	}
+	return filterStrings(UnselectedDependencies(plan, order), func(id string) bool { return !completed[id] })
+}
+
+// WithDependents returns the given steps and every step that depends on them,
+// directly or transitively, in execution order
+func WithDependents(plan *config.ExecutionPlan, stepIDs []string) ([]string, error) {
+	order, err := executionOrder(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(stepIDs))
+	for _, id := range stepIDs {
+		if findStep(plan, id) == nil {
+			return nil, fmt.Errorf("step not found: %s", id)
+		}
+		wanted[id] = true
+	}
+
+	// Dependencies come first in the execution order
+	var steps []string
+	for _, id := range order {
+		if !wanted[id] {
+			for _, dep := range findStep(plan, id).DependsOn {
+				if wanted[dep] {
+					wanted[id] = true
+					break
+				}
+			}
+		}
+		if wanted[id] {
+			steps = append(steps, id)
+		}
+	}
+	return steps, nil
+}
@@ -0,0 +1,267 @@
+package core
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// optionsTestPlan returns a plan with the dependency graph
+// setup -> build -> test -> deploy and an independent, optional docs step
+func optionsTestPlan() *config.ExecutionPlan {
+	return &config.ExecutionPlan{
+		Name:    "Options Test",
+		Version: "1.0.0",
+		Executors: map[string]config.ExecutorConfig{
+			"mock": {"type": "mock"},
+		},
+		Steps: []config.Step{
+			{ID: "setup", Executor: "mock", Tags: []string{"tools"}, Files: []config.FileConfig{{Path: "setup.sh"}}},
+			{ID: "build", Executor: "mock", Tags: []string{"build"}, DependsOn: []string{"setup"}, Files: []config.FileConfig{{Path: "build.sh"}}},
+			{ID: "test", Executor: "mock", Tags: []string{"test", "ci"}, DependsOn: []string{"build"}, Files: []config.FileConfig{{Path: "test.sh"}}},
+			{ID: "deploy", Executor: "mock", DependsOn: []string{"test"}, Files: []config.FileConfig{{Path: "deploy.sh"}}},
+			{ID: "docs", Executor: "mock", Tags: []string{"docs", "optional"}, Files: []config.FileConfig{{Path: "docs.sh"}}},
+		},
+	}
+}
+
+func TestSelectSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    RunOptions
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "all steps",
+			opts: RunOptions{},
+			want: []string{"setup", "build", "test", "deploy", "docs"},
+		},
+		{
+			name: "from step",
+			opts: RunOptions{FromStep: "test"},
+			want: []string{"test", "deploy", "docs"},
+		},
+		{
+			name: "only includes dependencies",
+			opts: RunOptions{Only: []string{"test"}},
+			want: []string{"setup", "build", "test"},
+		},
+		{
+			name: "only with independent steps",
+			opts: RunOptions{Only: []string{"docs", "build"}},
+			want: []string{"setup", "build", "docs"},
+		},
+		{
+			name: "only combined with from step",
+			opts: RunOptions{Only: []string{"deploy"}, FromStep: "test"},
+			want: []string{"test", "deploy"},
+		},
+		{
+			name:    "from step outside only selection",
+			opts:    RunOptions{Only: []string{"docs"}, FromStep: "test"},
+			wantErr: "step test is not part of the selected steps",
+		},
+		{
+			name:    "unknown only step",
+			opts:    RunOptions{Only: []string{"missing"}},
+			wantErr: "step not found: missing",
+		},
+		{
+			name: "tags include dependencies",
+			opts: RunOptions{Tags: []string{"ci"}},
+			want: []string{"setup", "build", "test"},
+		},
+		{
+			name: "any of several tags",
+			opts: RunOptions{Tags: []string{"docs", "build"}},
+			want: []string{"setup", "build", "docs"},
+		},
+		{
+			name: "skip tags",
+			opts: RunOptions{SkipTags: []string{"optional"}},
+			want: []string{"setup", "build", "test", "deploy"},
+		},
+		{
+			name: "skip tags drop dependencies",
+			opts: RunOptions{Tags: []string{"ci"}, SkipTags: []string{"tools"}},
+			want: []string{"build", "test"},
+		},
+		{
+			name: "only combined with tags",
+			opts: RunOptions{Only: []string{"test", "docs"}, Tags: []string{"optional"}},
+			want: []string{"docs"},
+		},
+		{
+			name:    "unknown tag",
+			opts:    RunOptions{SkipTags: []string{"missing"}},
+			wantErr: "tag not found: missing",
+		},
+		{
+			name:    "nothing selected",
+			opts:    RunOptions{Only: []string{"docs"}, Tags: []string{"ci"}},
+			wantErr: "no steps selected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectSteps(optionsTestPlan(), tt.opts)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUnmetDependencies(t *testing.T) {
+	plan := optionsTestPlan()
+	order, err := SelectSteps(plan, RunOptions{Tags: []string{"ci"}, SkipTags: []string{"tools", "build"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"test"}, order)
+
+	assert.Equal(t, []string{"build"}, UnmetDependencies(plan, order, &ExecutionState{}))
+	assert.Equal(t, []string{"build"}, UnmetDependencies(plan, order, &ExecutionState{CompletedSteps: []string{"setup"}}))
+	assert.Empty(t, UnmetDependencies(plan, order, &ExecutionState{CompletedSteps: []string{"setup", "build"}}))
+}
+
+func TestWithDependents(t *testing.T) {
+	plan := optionsTestPlan()
+
+	steps, err := WithDependents(plan, []string{"build"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"build", "test", "deploy"}, steps)
+
+	steps, err = WithDependents(plan, []string{"docs", "test"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test", "deploy", "docs"}, steps)
+
+	_, err = WithDependents(plan, []string{"missing"})
+	assert.EqualError(t, err, "step not found: missing")
+}
+
+func TestParseStepList(t *testing.T) {
+	assert.Equal(t, []string{"test", "deploy"}, ParseStepList(" test, deploy ,"))
+	assert.Nil(t, ParseStepList(""))
+}
+
+func TestRunnerOptions(t *testing.T) {
+	newRunner := func(t *testing.T, plan *config.ExecutionPlan, stateFile string) (*Runner, *MockExecutor) {
+		runner, err := NewRunner(plan, stateFile)
+		require.NoError(t, err)
+		mockExec := &MockExecutor{name: "mock", executedFiles: []string{}}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+		return runner, mockExec
+	}
+
+	t.Run("SetOptions rejects unknown steps and modes", func(t *testing.T) {
+		runner, _ := newRunner(t, optionsTestPlan(), filepath.Join(t.TempDir(), "state.json"))
+
+		err := runner.SetOptions(RunOptions{FromStep: "missing"})
+		assert.EqualError(t, err, "step not found: missing")
+
+		err = runner.SetOptions(RunOptions{Only: []string{"build", "missing"}})
+		assert.EqualError(t, err, "step not found: missing")
+
+		err = runner.SetOptions(RunOptions{Tags: []string{"ci", "nightly"}})
+		assert.EqualError(t, err, "tag not found: nightly")
+
+		err = runner.SetOptions(RunOptions{Dependencies: "ignore"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid dependency mode")
+	})
+
+	t.Run("Execute from step assumes dependencies", func(t *testing.T) {
+		runner, mockExec := newRunner(t, optionsTestPlan(), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, runner.SetOptions(RunOptions{FromStep: "test"}))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Equal(t, []string{"test.sh", "deploy.sh", "docs.sh"}, mockExec.GetExecutedFiles())
+	})
+
+	t.Run("Execute with tags", func(t *testing.T) {
+		runner, mockExec := newRunner(t, optionsTestPlan(), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, runner.SetOptions(RunOptions{Tags: []string{"ci", "docs"}, SkipTags: []string{"optional"}}))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Equal(t, []string{"setup.sh", "build.sh", "test.sh"}, mockExec.GetExecutedFiles())
+	})
+
+	t.Run("Execute with skipped tags enforces dependencies", func(t *testing.T) {
+		runner, mockExec := newRunner(t, optionsTestPlan(), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, runner.SetOptions(RunOptions{SkipTags: []string{"tools"}, Dependencies: DependenciesEnforce}))
+
+		err := runner.Execute(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "dependency setup has not been completed")
+		assert.Empty(t, mockExec.GetExecutedFiles())
+	})
+
+	t.Run("Execute from step enforces dependencies", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+
+		runner, mockExec := newRunner(t, optionsTestPlan(), stateFile)
+		require.NoError(t, runner.SetOptions(RunOptions{FromStep: "test", Dependencies: DependenciesEnforce}))
+		err := runner.Execute(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "dependency build has not been completed")
+		assert.Empty(t, mockExec.GetExecutedFiles())
+
+		// Complete the dependencies, then starting from the step succeeds
+		runner, _ = newRunner(t, optionsTestPlan(), stateFile)
+		require.NoError(t, runner.SetOptions(RunOptions{Only: []string{"build"}}))
+		require.NoError(t, runner.Execute(context.Background()))
+
+		runner, mockExec = newRunner(t, optionsTestPlan(), stateFile)
+		require.NoError(t, runner.SetOptions(RunOptions{FromStep: "test", Dependencies: DependenciesEnforce}))
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Equal(t, []string{"test.sh", "deploy.sh", "docs.sh"}, mockExec.GetExecutedFiles())
+	})
+
+	t.Run("Execute only selected steps", func(t *testing.T) {
+		runner, mockExec := newRunner(t, optionsTestPlan(), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, runner.SetOptions(RunOptions{Only: []string{"build"}}))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Equal(t, []string{"setup.sh", "build.sh"}, mockExec.GetExecutedFiles())
+
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"setup", "build"}, state.CompletedSteps)
+	})
+
+	t.Run("Platform override filters files", func(t *testing.T) {
+		other := "windows"
+		if runtime.GOOS == "windows" {
+			other = "linux"
+		}
+
+		plan := optionsTestPlan()
+		plan.Steps = []config.Step{{
+			ID:       "install",
+			Executor: "mock",
+			SkipIf:   `os == "` + runtime.GOOS + `"`,
+			Files: []config.FileConfig{
+				{Path: "common.sh"},
+				{Path: "native.sh", Platform: runtime.GOOS},
+				{Path: "other.sh", Platform: other},
+			},
+		}}
+
+		runner, mockExec := newRunner(t, plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, runner.SetOptions(RunOptions{Platform: other}))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Equal(t, []string{"common.sh", "other.sh"}, mockExec.GetExecutedFiles())
+		assert.Equal(t, other, runner.State().Platform)
+	})
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"github.com/SphereStacking/plexr/internal/executors"
+)
+
+// outputEnv exposes the recorded outputs of all steps as PLEXR_STEP_<STEP>_<KEY>
+// environment variables
+func (r *Runner) outputEnv() map[string]string {
+	env := make(map[string]string)
+	for stepID, outputs := range r.stateManager.StepOutputs() {
+		for key, value := range outputs {
+			env[executors.OutputEnvName(stepID, key)] = value
+		}
+	}
+	return env
+}
+
+// expandOutputs replaces {{ steps.<id>.outputs.<key> }} references with the
+// recorded outputs
+func (r *Runner) expandOutputs(s string) (string, error) {
+	outputs := r.stateManager.StepOutputs()
+	return executors.ExpandOutputRefs(s, func(stepID, key string) (string, bool) {
+		value, ok := outputs[stepID][key]
+		return value, ok
+	})
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/executors"
+	"github.com/SphereStacking/plexr/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnerStepOutputs(t *testing.T) {
+	outputsPlan := func() *config.ExecutionPlan {
+		return &config.ExecutionPlan{
+			Name:    "Outputs Test",
+			Version: "1.0.0",
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{
+					ID:       "create_db",
+					Executor: "mock",
+					Files:    []config.FileConfig{{Path: "create.sh"}, {Path: "grant.sh"}},
+				},
+				{
+					ID:        "migrate",
+					Executor:  "mock",
+					DependsOn: []string{"create_db"},
+					Files:     []config.FileConfig{{Path: "migrate.sh"}},
+				},
+			},
+		}
+	}
+
+	// recording returns an executor that emits outputs for create.sh and
+	// records the environment every file was executed with
+	recording := func(envs map[string]map[string]string) *MockExecutor {
+		var mu sync.Mutex
+		return &MockExecutor{
+			name:          "mock",
+			executedFiles: []string{},
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				mu.Lock()
+				envs[file.Path] = file.Env
+				mu.Unlock()
+				if file.Path == "create.sh" {
+					return &executors.ExecutionResult{
+						Success: true,
+						Outputs: map[string]string{"db_url": "postgres://localhost/app", "db_user": "app"},
+					}, nil
+				}
+				return &executors.ExecutionResult{Success: true}, nil
+			},
+		}
+	}
+
+	t.Run("Outputs are passed to later files and steps", func(t *testing.T) {
+		runner, err := NewRunner(outputsPlan(), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		envs := make(map[string]map[string]string)
+		require.NoError(t, runner.RegisterExecutor("mock", recording(envs)))
+
+		require.NoError(t, runner.Execute(context.Background()))
+
+		assert.Empty(t, envs["create.sh"])
+		assert.Equal(t, "postgres://localhost/app", envs["grant.sh"]["PLEXR_STEP_CREATE_DB_DB_URL"])
+		assert.Equal(t, "app", envs["migrate.sh"]["PLEXR_STEP_CREATE_DB_DB_USER"])
+		assert.Equal(t, map[string]map[string]string{
+			"create_db": {"db_url": "postgres://localhost/app", "db_user": "app"},
+		}, runner.State().StepOutputs)
+	})
+
+	t.Run("Outputs containing secrets reach later steps", func(t *testing.T) {
+		utils.AddSecret("localhost/app")
+		t.Cleanup(utils.ResetSecrets)
+
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		runner, err := NewRunner(outputsPlan(), stateFile)
+		require.NoError(t, err)
+		envs := make(map[string]map[string]string)
+		require.NoError(t, runner.RegisterExecutor("mock", recording(envs)))
+		// Progress displays read the state while the plan runs
+		runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+			runner.State()
+		})
+
+		require.NoError(t, runner.Execute(context.Background()))
+
+		assert.Equal(t, "postgres://localhost/app", envs["migrate.sh"]["PLEXR_STEP_CREATE_DB_DB_URL"])
+		assert.Equal(t, "postgres://********", runner.State().StepOutputs["create_db"]["db_url"])
+		data, err := os.ReadFile(stateFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "postgres://********")
+		assert.NotContains(t, string(data), "localhost/app")
+	})
+
+	t.Run("Outputs of earlier runs remain available", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+
+		runner, err := NewRunner(outputsPlan(), stateFile)
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", recording(make(map[string]map[string]string))))
+		require.NoError(t, runner.SetOptions(RunOptions{Only: []string{"create_db"}}))
+		require.NoError(t, runner.Execute(context.Background()))
+
+		runner, err = NewRunner(outputsPlan(), stateFile)
+		require.NoError(t, err)
+		envs := make(map[string]map[string]string)
+		require.NoError(t, runner.RegisterExecutor("mock", recording(envs)))
+		require.NoError(t, runner.Execute(context.Background()))
+
+		assert.Equal(t, "postgres://localhost/app", envs["migrate.sh"]["PLEXR_STEP_CREATE_DB_DB_URL"])
+	})
+
+	t.Run("Rolled back steps lose their outputs", func(t *testing.T) {
+		plan := outputsPlan()
+		plan.Steps[0].Files = []config.FileConfig{{Path: "create.sh"}, {Path: "fail.sh"}}
+		plan.Steps[0].Rollback = []config.FileConfig{{Path: "drop.sh"}}
+
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		envs := make(map[string]map[string]string)
+		mockExec := recording(envs)
+		executeFunc := mockExec.executeFunc
+		mockExec.executeFunc = func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+			if file.Path == "fail.sh" {
+				return &executors.ExecutionResult{Success: false}, nil
+			}
+			return executeFunc(ctx, file)
+		}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		require.Error(t, runner.Execute(context.Background()))
+
+		// The rollback can clean up what the step created
+		assert.Equal(t, "postgres://localhost/app", envs["drop.sh"]["PLEXR_STEP_CREATE_DB_DB_URL"])
+		assert.Empty(t, runner.State().StepOutputs)
+	})
+
+	t.Run("Check commands expand output references", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("Skipping shell command test on Windows")
+		}
+
+		plan := outputsPlan()
+		plan.Steps[1].CheckCommand = `test "{{ steps.create_db.outputs.db_user }}" = app && test "$PLEXR_STEP_CREATE_DB_DB_USER" = app`
+
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		envs := make(map[string]map[string]string)
+		require.NoError(t, runner.RegisterExecutor("mock", recording(envs)))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.NotContains(t, envs, "migrate.sh")
+		assert.Contains(t, runner.State().CompletedSteps, "migrate")
+	})
+
+	t.Run("Undefined output references fail the step", func(t *testing.T) {
+		plan := outputsPlan()
+		plan.Steps[1].CheckCommand = "test -n '{{ steps.create_db.outputs.password }}'"
+
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", recording(make(map[string]map[string]string))))
+
+		err = runner.Execute(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "undefined output: steps.create_db.outputs.password")
+	})
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/SphereStacking/plexr/internal/config"
+)
+
+// PlanChangedError reports a state that was recorded for another plan, or
+// for another version of the plan
+type PlanChangedError struct {
+	StateName    string
+	StateVersion string
+	PlanName     string
+	PlanVersion  string
+}
+
+func (e *PlanChangedError) Error() string {
+	if e.StateName != e.PlanName {
+		return fmt.Sprintf("the state was recorded for plan %q (v%s), not for %q (v%s)", e.StateName, e.StateVersion, e.PlanName, e.PlanVersion)
+	}
+	return fmt.Sprintf("the state was recorded for version %s of plan %q, not for version %s", e.StateVersion, e.PlanName, e.PlanVersion)
+}
+
+// DetectPlanChange compares the plan a state was recorded for with a plan.
+// It returns nil if they match; a state that does not record a name or
+// version matches any.
+func DetectPlanChange(state *ExecutionState, plan *config.ExecutionPlan) *PlanChangedError {
+	if (state.SetupName == "" || state.SetupName == plan.Name) &&
+		(state.SetupVersion == "" || state.SetupVersion == plan.Version) {
+		return nil
+	}
+	return &PlanChangedError{
+		StateName:    state.SetupName,
+		StateVersion: state.SetupVersion,
+		PlanName:     plan.Name,
+		PlanVersion:  plan.Version,
+	}
+}
+
+// NewPlanSteps returns the steps of a plan that the plan the state was
+// recorded for did not have, in plan order
+func NewPlanSteps(state *ExecutionState, plan *config.ExecutionPlan) []string {
+	var steps []string
+	for _, step := range plan.Steps {
+		if !containsString(state.PlanSteps, step.ID) {
+			steps = append(steps, step.ID)
+		}
+	}
+	return steps
+}
+
+// planStepIDs returns the IDs of the steps of a plan
+func planStepIDs(plan *config.ExecutionPlan) []string {
+	ids := make([]string, len(plan.Steps))
+	for i, step := range plan.Steps {
+		ids[i] = step.ID
+	}
+	return ids
+}
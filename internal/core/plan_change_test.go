@@ -0,0 +1,177 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectPlanChange(t *testing.T) {
+	plan := &config.ExecutionPlan{Name: "Dev Setup", Version: "2.0.0"}
+
+	tests := []struct {
+		name    string
+		state   ExecutionState
+		changed bool
+		message string
+	}{
+		{name: "Same plan", state: ExecutionState{SetupName: "Dev Setup", SetupVersion: "2.0.0"}},
+		{name: "Unrecorded plan", state: ExecutionState{}},
+		{
+			name:    "Other version",
+			state:   ExecutionState{SetupName: "Dev Setup", SetupVersion: "1.0.0"},
+			changed: true,
+			message: `the state was recorded for version 1.0.0 of plan "Dev Setup", not for version 2.0.0`,
+		},
+		{
+			name:    "Other plan",
+			state:   ExecutionState{SetupName: "CI Setup", SetupVersion: "2.0.0"},
+			changed: true,
+			message: `the state was recorded for plan "CI Setup" (v2.0.0), not for "Dev Setup" (v2.0.0)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change := DetectPlanChange(&tt.state, plan)
+			if !tt.changed {
+				assert.Nil(t, change)
+				return
+			}
+			require.NotNil(t, change)
+			assert.Equal(t, tt.message, change.Error())
+		})
+	}
+}
+
+func TestRunnerPlanChange(t *testing.T) {
+	newPlan := func(version string, steps ...string) *config.ExecutionPlan {
+		plan := &config.ExecutionPlan{
+			Name:    "Dev Setup",
+			Version: version,
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+		}
+		for _, id := range steps {
+			plan.Steps = append(plan.Steps, config.Step{ID: id, Executor: "mock", Files: []config.FileConfig{{Path: id + ".sh"}}})
+		}
+		return plan
+	}
+
+	// run executes a plan on a state file and returns the executed files
+	run := func(t *testing.T, plan *config.ExecutionPlan, stateFile string, mode PlanChangeMode) ([]string, error) {
+		t.Helper()
+		runner, err := NewRunner(plan, stateFile)
+		require.NoError(t, err)
+		defer runner.Close()
+		require.NoError(t, runner.SetOptions(RunOptions{OnPlanChange: mode}))
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		return mockExec.GetExecutedFiles(), err
+	}
+
+	// setup completes version 1.0.0 of the plan
+	setup := func(t *testing.T) string {
+		t.Helper()
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		files, err := run(t, newPlan("1.0.0", "install", "configure"), stateFile, "")
+		require.NoError(t, err)
+		require.Equal(t, []string{"install.sh", "configure.sh"}, files)
+		return stateFile
+	}
+
+	loadState := func(t *testing.T, stateFile string) *ExecutionState {
+		t.Helper()
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		state, err := sm.Load()
+		require.NoError(t, err)
+		return state
+	}
+
+	t.Run("Records the plan", func(t *testing.T) {
+		state := loadState(t, setup(t))
+		assert.Equal(t, "1.0.0", state.SetupVersion)
+		assert.Equal(t, []string{"install", "configure"}, state.PlanSteps)
+		assert.Equal(t, StateSchemaVersion, state.SchemaVersion)
+	})
+
+	t.Run("Aborts by default", func(t *testing.T) {
+		stateFile := setup(t)
+		files, err := run(t, newPlan("2.0.0", "install", "configure", "seed"), stateFile, "")
+		var change *PlanChangedError
+		require.True(t, errors.As(err, &change))
+		assert.Equal(t, "1.0.0", change.StateVersion)
+		assert.Empty(t, files)
+		assert.Equal(t, "1.0.0", loadState(t, stateFile).SetupVersion)
+
+		// Only the run of version 1.0.0 is recorded
+		runs, err := ReadHistory(stateFile)
+		require.NoError(t, err)
+		assert.Len(t, runs, 1)
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		stateFile := setup(t)
+		files, err := run(t, newPlan("2.0.0", "install", "configure", "seed"), stateFile, PlanChangeReset)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"install.sh", "configure.sh", "seed.sh"}, files)
+
+		state := loadState(t, stateFile)
+		assert.Equal(t, "2.0.0", state.SetupVersion)
+		assert.Equal(t, []string{"install", "configure", "seed"}, state.CompletedSteps)
+	})
+
+	t.Run("Continue", func(t *testing.T) {
+		stateFile := setup(t)
+		plan := newPlan("2.0.0", "install", "configure", "seed")
+		plan.Steps[2].DependsOn = []string{"install"}
+		files, err := run(t, plan, stateFile, PlanChangeContinue)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"seed.sh"}, files)
+
+		state := loadState(t, stateFile)
+		assert.Equal(t, "2.0.0", state.SetupVersion)
+		assert.Equal(t, []string{"install", "configure", "seed"}, state.PlanSteps)
+
+		// The state now belongs to the new version
+		files, err = run(t, plan, stateFile, "")
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("New steps only", func(t *testing.T) {
+		stateFile := setup(t)
+
+		// A step of the old version that failed is not run again
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		_, err = sm.Load()
+		require.NoError(t, err)
+		require.NoError(t, sm.ResetSteps([]string{"configure"}))
+
+		files, err := run(t, newPlan("2.0.0", "install", "configure", "seed", "docs"), stateFile, PlanChangeNewSteps)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"seed.sh", "docs.sh"}, files)
+		assert.NotContains(t, loadState(t, stateFile).CompletedSteps, "configure")
+
+		// Later runs of the new version run every pending step
+		files, err = run(t, newPlan("2.0.0", "install", "configure", "seed", "docs"), stateFile, "")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"configure.sh"}, files)
+	})
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		runner, err := NewRunner(newPlan("1.0.0", "install"), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		assert.ErrorContains(t, runner.SetOptions(RunOptions{OnPlanChange: "merge"}), "invalid plan change mode 'merge'")
+	})
+}
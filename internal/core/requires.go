@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/version"
+)
+
+// toolCheckTimeout bounds how long a tool may take to print its version
+const toolCheckTimeout = 10 * time.Second
+
+// unknownVersion is recorded for tools whose version could not be determined
+const unknownVersion = "unknown"
+
+// versionArgs are the arguments tried in order to make a tool print its version
+var versionArgs = [][]string{{"--version"}, {"version"}, {"-version"}}
+
+// checkRequirements detects the required tools, records their versions in
+// the state and reports every tool that is missing or has an unsupported
+// version. Version commands run with env as their environment.
+func (r *Runner) checkRequirements(ctx context.Context, requires map[string]config.ToolRequirement, env map[string]string) error {
+	if len(requires) == 0 {
+		return nil
+	}
+
+	tools := make([]string, 0, len(requires))
+	for tool := range requires {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+
+	var problems []string
+	for _, tool := range tools {
+		req := requires[tool]
+		constraint, err := version.ParseConstraint(req.Version)
+		if err != nil {
+			return fmt.Errorf("invalid version for required tool '%s': %w", tool, err)
+		}
+
+		detected, err := r.detectTool(ctx, tool, req.Command, env)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		recorded := unknownVersion
+		if detected != nil {
+			recorded = detected.String()
+		}
+		if err := r.stateManager.AddInstalledTool(tool, recorded); err != nil {
+			return fmt.Errorf("failed to record tool %s: %w", tool, err)
+		}
+		r.notifyProgress("", "tool_detected", map[string]interface{}{"tool": tool, "version": recorded})
+
+		switch {
+		case constraint.Any():
+		case detected == nil:
+			problems = append(problems, fmt.Sprintf("could not determine the version of %s (required %s)", tool, constraint))
+		case !constraint.Check(*detected):
+			problems = append(problems, fmt.Sprintf("%s %s does not satisfy %s", tool, detected, constraint))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("missing prerequisites: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// detectTool finds a tool on PATH and returns its version, or nil if the tool
+// is installed but its version could not be determined. Detected tools are
+// cached for the rest of the run, as steps often require the same tools.
+// The cache is not locked while the tool runs, so a slow tool does not hold
+// up parallel steps requiring other tools; steps detecting the same tool at
+// the same time both run it.
+func (r *Runner) detectTool(ctx context.Context, tool, command string, env map[string]string) (*version.Version, error) {
+	key := tool + "\x00" + command
+	r.toolsMu.Lock()
+	detected, ok := r.tools[key]
+	r.toolsMu.Unlock()
+	if ok {
+		return detected, nil
+	}
+
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil, fmt.Errorf("%s not found on PATH", tool)
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, toolCheckTimeout)
+	defer cancel()
+
+	if command != "" {
+		result, err := r.shell.RunCommand(checkCtx, command, r.plan.WorkDirectory, env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run version command of %s: %w", tool, err)
+		}
+		if !result.Success {
+			return nil, fmt.Errorf("version command of %s failed: %s", tool, strings.TrimSpace(result.Output))
+		}
+		if v, ok := version.Extract(result.Output); ok {
+			detected = &v
+		}
+	} else {
+		for _, args := range versionArgs {
+			var output bytes.Buffer
+			cmd := exec.CommandContext(checkCtx, tool, args...) // #nosec G204 - tools are required by the plan author
+			cmd.Stdout = &output
+			cmd.Stderr = &output
+			if err := cmd.Run(); err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				continue
+			}
+			if v, ok := version.Extract(output.String()); ok {
+				detected = &v
+				break
+			}
+		}
+	}
+
+	r.toolsMu.Lock()
+	defer r.toolsMu.Unlock()
+	if r.tools == nil {
+		r.tools = make(map[string]*version.Version)
+	}
+	r.tools[key] = detected
+	return detected, nil
+}
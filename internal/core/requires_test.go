@@ -0,0 +1,151 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTools puts scripts printing the given outputs on PATH
+func fakeTools(t *testing.T, outputs map[string]string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping fake tool test on Windows")
+	}
+
+	dir := t.TempDir()
+	for tool, output := range outputs {
+		script := "#!/bin/sh\necho '" + output + "'\n"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, tool), []byte(script), 0700)) // #nosec G306 - test script must be executable
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRunnerRequires(t *testing.T) {
+	newPlan := func(planRequires, stepRequires map[string]config.ToolRequirement) *config.ExecutionPlan {
+		return &config.ExecutionPlan{
+			Name:     "Requires Test",
+			Version:  "1.0.0",
+			Requires: planRequires,
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{ID: "setup", Executor: "mock", Files: []config.FileConfig{{Path: "setup.sh"}}},
+				{ID: "migrate", Executor: "mock", DependsOn: []string{"setup"}, Requires: stepRequires, Files: []config.FileConfig{{Path: "migrate.sh"}}},
+			},
+		}
+	}
+
+	t.Run("Satisfied requirements are recorded", func(t *testing.T) {
+		fakeTools(t, map[string]string{
+			"fakego":   "go version go1.21.5 linux/amd64",
+			"fakepsql": "psql (PostgreSQL) 16.1",
+			"faketool": "no version here",
+		})
+
+		plan := newPlan(
+			map[string]config.ToolRequirement{"fakego": {Version: ">=1.21"}, "faketool": {}},
+			map[string]config.ToolRequirement{"fakepsql": {Version: "^16"}},
+		)
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Equal(t, []string{"setup.sh", "migrate.sh"}, mockExec.GetExecutedFiles())
+		assert.Equal(t, map[string]string{
+			"fakego":   "1.21.5",
+			"fakepsql": "16.1",
+			"faketool": "unknown",
+		}, runner.State().InstalledTools)
+	})
+
+	t.Run("Unsatisfied plan requirements stop before any step", func(t *testing.T) {
+		fakeTools(t, map[string]string{"fakego": "go version go1.20.3 linux/amd64"})
+
+		plan := newPlan(map[string]config.ToolRequirement{
+			"fakego":          {Version: ">=1.21"},
+			"plexr-not-found": {},
+		}, nil)
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "fakego 1.20.3 does not satisfy >=1.21")
+		assert.Contains(t, err.Error(), "plexr-not-found not found on PATH")
+		assert.Empty(t, mockExec.GetExecutedFiles())
+		assert.Equal(t, "1.20.3", runner.State().InstalledTools["fakego"])
+	})
+
+	t.Run("Unsatisfied step requirements fail the step", func(t *testing.T) {
+		fakeTools(t, map[string]string{"fakepsql": "psql (PostgreSQL) 15.4"})
+
+		plan := newPlan(nil, map[string]config.ToolRequirement{"fakepsql": {Version: ">=16"}})
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "prerequisites of step migrate are not met")
+		assert.Equal(t, []string{"setup.sh"}, mockExec.GetExecutedFiles())
+
+		state := runner.State()
+		assert.Contains(t, state.CompletedSteps, "setup")
+		assert.NotContains(t, state.CompletedSteps, "migrate")
+	})
+
+	t.Run("Custom version command", func(t *testing.T) {
+		fakeTools(t, map[string]string{"faketool": "no version here"})
+
+		plan := newPlan(map[string]config.ToolRequirement{
+			"faketool": {Version: "~2.3", Command: "echo faketool 2.3.7"},
+		}, nil)
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", &MockExecutor{name: "mock"}))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Equal(t, "2.3.7", runner.State().InstalledTools["faketool"])
+	})
+
+	t.Run("Version commands see env file variables", func(t *testing.T) {
+		fakeTools(t, map[string]string{"faketool": "no version here"})
+
+		plan := newPlan(map[string]config.ToolRequirement{
+			"faketool": {Version: ">=3", Command: "echo faketool ${FAKETOOL_VERSION}"},
+		}, nil)
+		plan.Environment = map[string]string{"FAKETOOL_VERSION": "3.1.0"}
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", &MockExecutor{name: "mock"}))
+
+		require.NoError(t, runner.Execute(context.Background()))
+		assert.Equal(t, "3.1.0", runner.State().InstalledTools["faketool"])
+	})
+
+	t.Run("Unknown version fails a version constraint", func(t *testing.T) {
+		fakeTools(t, map[string]string{"faketool": "no version here"})
+
+		plan := newPlan(map[string]config.ToolRequirement{"faketool": {Version: ">=1"}}, nil)
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", &MockExecutor{name: "mock"}))
+
+		err = runner.Execute(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not determine the version of faketool")
+	})
+}
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/executors"
+)
+
+const (
+	// defaultRetryDelay is used when a retry policy does not specify a delay
+	defaultRetryDelay = time.Second
+	// maxRetryDelay bounds exponential backoff when no max_delay is configured
+	maxRetryDelay = time.Hour
+)
+
+// executeFile executes a single file, retrying failed attempts according to the
+// file's retry policy. Every failed attempt and the outcome of the file are
+// recorded in the execution state. The outcome of a rollback file is recorded
+// with the step's rollback files; its attempts are not recorded, so they are
+// never mistaken for failures of the step's files.
+func (r *Runner) executeFile(ctx context.Context, stepID string, executor Executor, file executors.ExecutionFile, policy config.RetryConfig, rollback bool) (result *executors.ExecutionResult, err error) {
+	maxAttempts := policy.Max + 1
+	var attempts []AttemptRecord
+
+	start := time.Now()
+	attempt := 0
+	defer func() {
+		if recordErr := r.recordFile(ctx, stepID, file.Path, start, attempt, result, err, rollback); recordErr != nil && err == nil {
+			err = recordErr
+		}
+	}()
+
+	for attempt = 1; ; attempt++ {
+		r.notifyProgress(stepID, "executing_file", map[string]interface{}{
+			"file":         file.Path,
+			"attempt":      attempt,
+			"max_attempts": maxAttempts,
+		})
+
+		result, err = executor.Execute(ctx, file)
+		if err == nil && result != nil && !result.Success {
+			if result.Error != nil {
+				err = fmt.Errorf("execution failed: %w", result.Error)
+			} else {
+				err = fmt.Errorf("execution failed")
+			}
+		}
+
+		if err == nil {
+			if rollback {
+				return result, nil
+			}
+			if len(attempts) == 0 {
+				// Drop attempts left over from earlier runs
+				if serr := r.stateManager.SetFileAttempts(stepID, file.Path, nil); serr != nil {
+					return result, serr
+				}
+			}
+			if serr := r.stateManager.SetFileFailed(stepID, file.Path, false); serr != nil {
+				return result, serr
+			}
+			return result, nil
+		}
+
+		// An interrupted attempt is not a failure of the file
+		if ctx.Err() != nil {
+			return result, err
+		}
+
+		record := AttemptRecord{
+			Attempt: attempt,
+			Error:   err.Error(),
+			Time:    time.Now(),
+		}
+		if result != nil {
+			record.ExitCode = result.ExitCode
+			record.SQLState = result.SQLState
+		}
+		attempts = append(attempts, record)
+		if !rollback {
+			if serr := r.stateManager.SetFileAttempts(stepID, file.Path, attempts); serr != nil {
+				return result, serr
+			}
+		}
+
+		if attempt >= maxAttempts || !isRetryable(policy, result) {
+			if !rollback {
+				if serr := r.stateManager.SetFileFailed(stepID, file.Path, true); serr != nil {
+					return result, serr
+				}
+			}
+			if attempt > 1 {
+				return result, fmt.Errorf("%s failed after %d attempts: %w", file.Path, attempt, err)
+			}
+			return result, err
+		}
+
+		delay := retryDelay(policy, attempt)
+		r.notifyProgress(stepID, "retrying", map[string]interface{}{
+			"file":         file.Path,
+			"attempt":      attempt,
+			"max_attempts": maxAttempts,
+			"delay":        delay,
+			"error":        err.Error(),
+		})
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+	}
+}
+
+// recordFile records the outcome of executing a file in the record of its step
+func (r *Runner) recordFile(ctx context.Context, stepID, path string, start time.Time, attempts int, result *executors.ExecutionResult, err error, rollback bool) error {
+	now := time.Now()
+	record := FileRecord{
+		Path:       path,
+		Status:     StatusCompleted,
+		StartedAt:  start,
+		FinishedAt: &now,
+		Duration:   now.Sub(start).Milliseconds(),
+		Attempts:   attempts,
+	}
+	if result != nil {
+		record.ExitCode = result.ExitCode
+		record.Output = result.Output
+	}
+	if err != nil {
+		record.Status = StatusFailed
+		if ctx.Err() != nil {
+			record.Status = StatusInterrupted
+		}
+		record.Error = err.Error()
+	}
+
+	if recordErr := r.recordFileRecord(stepID, record, rollback); recordErr != nil {
+		return fmt.Errorf("failed to record file %s: %w", path, recordErr)
+	}
+	return nil
+}
+
+// recordFileRecord records a file with the files or the rollback files of its step
+func (r *Runner) recordFileRecord(stepID string, record FileRecord, rollback bool) error {
+	if rollback {
+		return r.stateManager.RecordRollbackFile(stepID, record)
+	}
+	return r.stateManager.RecordFile(stepID, record)
+}
+
+// isRetryable reports whether a failed result qualifies for another attempt.
+// Without exit code or SQLSTATE filters every failure is retryable.
+func isRetryable(policy config.RetryConfig, result *executors.ExecutionResult) bool {
+	if len(policy.ExitCodes) == 0 && len(policy.SQLStates) == 0 {
+		return true
+	}
+	if result == nil {
+		return false
+	}
+
+	for _, code := range policy.ExitCodes {
+		if result.ExitCode == code {
+			return true
+		}
+	}
+	if result.SQLState != "" {
+		for _, state := range policy.SQLStates {
+			// Two character entries match a whole SQLSTATE class
+			if strings.HasPrefix(result.SQLState, strings.ToUpper(state)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryDelay calculates the wait time before the retry following the given
+// attempt. Jitter is applied before the delay is capped, so that no retry
+// waits longer than max_delay.
+func retryDelay(policy config.RetryConfig, attempt int) time.Duration {
+	delay := policy.Delay
+	if delay == 0 {
+		delay = defaultRetryDelay
+	}
+
+	limit := policy.MaxDelay
+	if limit == 0 {
+		limit = maxRetryDelay
+	}
+
+	if policy.Backoff == "exponential" {
+		for i := 1; i < attempt && delay < limit; i++ {
+			delay *= 2
+		}
+	}
+	if delay > limit {
+		delay = limit
+	}
+
+	if policy.Jitter > 0 {
+		spread := float64(delay) * policy.Jitter
+		delay += time.Duration((rand.Float64()*2 - 1) * spread) // #nosec G404 - Jitter does not need a secure random source
+		if delay > limit {
+			delay = limit
+		}
+	}
+	return delay
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/executors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnerRetry(t *testing.T) {
+	newPlan := func(retry config.RetryConfig) *config.ExecutionPlan {
+		return &config.ExecutionPlan{
+			Name:    "Retry Test",
+			Version: "1.0.0",
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{
+					ID:       "flaky",
+					Executor: "mock",
+					Files:    []config.FileConfig{{Path: "flaky.sh", Retry: retry}},
+				},
+			},
+		}
+	}
+
+	// failingExecutor fails the first failures executions with the given exit code
+	failingExecutor := func(failures int, exitCode int) *MockExecutor {
+		calls := 0
+		return &MockExecutor{
+			name: "mock",
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				calls++
+				if calls <= failures {
+					err := errors.New("temporary failure")
+					return &executors.ExecutionResult{Success: false, Error: err, ExitCode: exitCode}, err
+				}
+				return &executors.ExecutionResult{Success: true}, nil
+			},
+		}
+	}
+
+	t.Run("Succeeds after retries", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		runner, err := NewRunner(newPlan(config.RetryConfig{Max: 3, Delay: time.Millisecond}), filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+
+		var mu sync.Mutex
+		var retries []map[string]interface{}
+		runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+			if event == "retrying" {
+				mu.Lock()
+				retries = append(retries, data.(map[string]interface{}))
+				mu.Unlock()
+			}
+		})
+
+		mockExec := failingExecutor(2, 75)
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, mockExec.GetExecutedFiles(), 3)
+
+		require.Len(t, retries, 2)
+		assert.Equal(t, 1, retries[0]["attempt"])
+		assert.Equal(t, 4, retries[0]["max_attempts"])
+		assert.Equal(t, "temporary failure", retries[1]["error"])
+
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		assert.Contains(t, state.CompletedSteps, "flaky")
+		attempts := state.FileAttempts["flaky:flaky.sh"]
+		require.Len(t, attempts, 2)
+		assert.Equal(t, 1, attempts[0].Attempt)
+		assert.Equal(t, 75, attempts[1].ExitCode)
+		assert.Empty(t, state.FailedFiles)
+	})
+
+	t.Run("Gives up after max retries", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		runner, err := NewRunner(newPlan(config.RetryConfig{Max: 2, Delay: time.Millisecond}), filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+
+		mockExec := failingExecutor(10, 1)
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "flaky.sh failed after 3 attempts")
+		assert.Len(t, mockExec.GetExecutedFiles(), 3)
+
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		assert.Len(t, state.FileAttempts["flaky:flaky.sh"], 3)
+		assert.Equal(t, []string{"flaky:flaky.sh"}, state.FailedFiles)
+	})
+
+	t.Run("Does not retry non-retryable exit codes", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		policy := config.RetryConfig{Max: 3, Delay: time.Millisecond, ExitCodes: []int{75}}
+		runner, err := NewRunner(newPlan(policy), filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+
+		mockExec := failingExecutor(10, 2)
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		assert.Error(t, err)
+		assert.Len(t, mockExec.GetExecutedFiles(), 1)
+	})
+
+	t.Run("Successful run clears earlier attempts", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		stateFile := filepath.Join(tmpDir, "state.json")
+
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, sm.Save(&ExecutionState{
+			SetupName:   "Retry Test",
+			FailedFiles: []string{"flaky:flaky.sh"},
+			FileAttempts: map[string][]AttemptRecord{
+				"flaky:flaky.sh": {{Attempt: 1, Error: "old failure"}},
+			},
+		}))
+
+		runner, err := NewRunner(newPlan(config.RetryConfig{}), stateFile)
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", &MockExecutor{name: "mock"}))
+
+		err = runner.Execute(context.Background())
+		require.NoError(t, err)
+
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		assert.Empty(t, state.FileAttempts)
+		assert.Empty(t, state.FailedFiles)
+	})
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy config.RetryConfig
+		result *executors.ExecutionResult
+		want   bool
+	}{
+		{"no filters", config.RetryConfig{Max: 1}, &executors.ExecutionResult{ExitCode: 1}, true},
+		{"no filters without result", config.RetryConfig{Max: 1}, nil, true},
+		{"matching exit code", config.RetryConfig{ExitCodes: []int{75}}, &executors.ExecutionResult{ExitCode: 75}, true},
+		{"other exit code", config.RetryConfig{ExitCodes: []int{75}}, &executors.ExecutionResult{ExitCode: 1}, false},
+		{"matching sql class", config.RetryConfig{SQLStates: []string{"40"}}, &executors.ExecutionResult{SQLState: "40P01"}, true},
+		{"matching sql code", config.RetryConfig{SQLStates: []string{"08006"}}, &executors.ExecutionResult{SQLState: "08006"}, true},
+		{"other sql class", config.RetryConfig{SQLStates: []string{"40"}}, &executors.ExecutionResult{SQLState: "23505"}, false},
+		{"filters without result", config.RetryConfig{ExitCodes: []int{75}}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isRetryable(tt.policy, tt.result))
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	t.Run("fixed backoff", func(t *testing.T) {
+		policy := config.RetryConfig{Delay: 2 * time.Second}
+		assert.Equal(t, 2*time.Second, retryDelay(policy, 1))
+		assert.Equal(t, 2*time.Second, retryDelay(policy, 4))
+	})
+
+	t.Run("default delay", func(t *testing.T) {
+		assert.Equal(t, defaultRetryDelay, retryDelay(config.RetryConfig{}, 1))
+	})
+
+	t.Run("exponential backoff with max delay", func(t *testing.T) {
+		policy := config.RetryConfig{Backoff: "exponential", Delay: time.Second, MaxDelay: 5 * time.Second}
+		assert.Equal(t, 1*time.Second, retryDelay(policy, 1))
+		assert.Equal(t, 2*time.Second, retryDelay(policy, 2))
+		assert.Equal(t, 4*time.Second, retryDelay(policy, 3))
+		assert.Equal(t, 5*time.Second, retryDelay(policy, 4))
+		assert.Equal(t, 5*time.Second, retryDelay(policy, 40))
+	})
+
+	t.Run("jitter stays within bounds", func(t *testing.T) {
+		policy := config.RetryConfig{Delay: time.Second, Jitter: 0.5}
+		for i := 0; i < 50; i++ {
+			delay := retryDelay(policy, 1)
+			assert.GreaterOrEqual(t, delay, 500*time.Millisecond)
+			assert.LessOrEqual(t, delay, 1500*time.Millisecond)
+		}
+	})
+
+	t.Run("jitter does not exceed max delay", func(t *testing.T) {
+		policy := config.RetryConfig{Backoff: "exponential", Delay: time.Second, MaxDelay: 5 * time.Second, Jitter: 0.5}
+		for i := 0; i < 50; i++ {
+			delay := retryDelay(policy, 10)
+			assert.GreaterOrEqual(t, delay, 2500*time.Millisecond)
+			assert.LessOrEqual(t, delay, 5*time.Second)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Reasons recorded for a rollback
+const (
+	rollbackReasonFailed  = "failed"  // The step itself failed
+	rollbackReasonCascade = "cascade" // The step completed earlier in a failed run
+)
+
+// rollback runs the rollback files of the failed steps. When the run was
+// aborted and the plan's rollback_scope is "run", every step completed during
+// this run is rolled back as well, in reverse completion order so dependents
+// are undone before their dependencies. Rollbacks run even if ctx has been
+// canceled.
+func (r *Runner) rollback(ctx context.Context, failed []string, aborted bool) {
+	ctx = context.WithoutCancel(ctx)
+
+	for _, stepID := range failed {
+		r.rollbackStep(ctx, stepID, rollbackReasonFailed)
+	}
+
+	if !aborted || r.plan.RollbackScope != "run" {
+		return
+	}
+
+	r.executedMu.Lock()
+	executed := append([]string(nil), r.executed...)
+	r.executedMu.Unlock()
+
+	for i := len(executed) - 1; i >= 0; i-- {
+		r.rollbackStep(ctx, executed[i], rollbackReasonCascade)
+	}
+}
+
+// rollbackStep runs the rollback files of a single step and records the outcome
+func (r *Runner) rollbackStep(ctx context.Context, stepID, reason string) {
+	step := r.findStep(stepID)
+	if step == nil || len(step.Rollback) == 0 {
+		return
+	}
+
+	r.notifyProgress(stepID, "rolling_back", map[string]interface{}{"reason": reason})
+
+	err := fmt.Errorf("executor not found: %s", step.Executor)
+	if executor, ok := r.executors[step.Executor]; ok {
+		err = r.runFiles(ctx, step, executor, step.Rollback, true)
+	}
+
+	record := RollbackRecord{
+		StepID:  stepID,
+		Reason:  reason,
+		Success: err == nil,
+		Time:    time.Now(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+		r.notifyProgress(stepID, "rollback_failed", map[string]interface{}{"error": err.Error()})
+	} else {
+		r.notifyProgress(stepID, "rolled_back", map[string]interface{}{"reason": reason})
+	}
+
+	if recordErr := r.stateManager.RecordRollback(record); recordErr != nil {
+		r.notifyProgress(stepID, "rollback_failed", map[string]interface{}{"error": recordErr.Error()})
+	}
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/executors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnerRollback(t *testing.T) {
+	// rollbackPlan returns a plan where install -> configure -> verify,
+	// and verify fails
+	rollbackPlan := func(scope string) *config.ExecutionPlan {
+		return &config.ExecutionPlan{
+			Name:          "Rollback Test",
+			Version:       "1.0.0",
+			RollbackScope: scope,
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{
+					ID:       "install",
+					Executor: "mock",
+					Files:    []config.FileConfig{{Path: "install.sh"}},
+					Rollback: []config.FileConfig{{Path: "uninstall.sh"}},
+				},
+				{
+					ID:        "configure",
+					Executor:  "mock",
+					DependsOn: []string{"install"},
+					Files:     []config.FileConfig{{Path: "configure.sh"}},
+					Rollback:  []config.FileConfig{{Path: "unconfigure.sh"}},
+				},
+				{
+					ID:        "verify",
+					Executor:  "mock",
+					DependsOn: []string{"configure"},
+					Files:     []config.FileConfig{{Path: "verify.sh"}},
+					Rollback:  []config.FileConfig{{Path: "cleanup.sh"}},
+				},
+			},
+		}
+	}
+
+	failing := func(failures map[string]bool) *MockExecutor {
+		return &MockExecutor{
+			name:          "mock",
+			executedFiles: []string{},
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				if failures[file.Path] {
+					err := errors.New(file.Path + " failed")
+					return &executors.ExecutionResult{Success: false, Error: err}, err
+				}
+				return &executors.ExecutionResult{Success: true}, nil
+			},
+		}
+	}
+
+	t.Run("Rolls back only the failed step by default", func(t *testing.T) {
+		runner, err := NewRunner(rollbackPlan(""), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		mockExec := failing(map[string]bool{"verify.sh": true})
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "verify.sh failed")
+
+		assert.Equal(t, []string{"install.sh", "configure.sh", "verify.sh", "cleanup.sh"}, mockExec.GetExecutedFiles())
+
+		state := runner.State()
+		assert.Equal(t, []string{"install", "configure"}, state.CompletedSteps)
+		require.Len(t, state.Rollbacks, 1)
+		assert.Equal(t, "verify", state.Rollbacks[0].StepID)
+		assert.Equal(t, "failed", state.Rollbacks[0].Reason)
+		assert.True(t, state.Rollbacks[0].Success)
+	})
+
+	t.Run("Rolls back the whole run in reverse order", func(t *testing.T) {
+		runner, err := NewRunner(rollbackPlan("run"), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		mockExec := failing(map[string]bool{"verify.sh": true})
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		var events []string
+		runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+			if event == "rolled_back" {
+				events = append(events, stepID)
+			}
+		})
+
+		err = runner.Execute(context.Background())
+		require.Error(t, err)
+
+		assert.Equal(t, []string{
+			"install.sh", "configure.sh", "verify.sh",
+			"cleanup.sh", "unconfigure.sh", "uninstall.sh",
+		}, mockExec.GetExecutedFiles())
+		assert.Equal(t, []string{"verify", "configure", "install"}, events)
+
+		// Rolled back steps run again on the next execution
+		state := runner.State()
+		assert.Empty(t, state.CompletedSteps)
+		require.Len(t, state.Rollbacks, 3)
+		assert.Equal(t, "cascade", state.Rollbacks[2].Reason)
+	})
+
+	t.Run("Steps completed before this run are not rolled back", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+
+		runner, err := NewRunner(rollbackPlan("run"), stateFile)
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", failing(nil)))
+		require.NoError(t, runner.SetOptions(RunOptions{Only: []string{"install"}}))
+		require.NoError(t, runner.Execute(context.Background()))
+
+		runner, err = NewRunner(rollbackPlan("run"), stateFile)
+		require.NoError(t, err)
+		mockExec := failing(map[string]bool{"verify.sh": true})
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		require.Error(t, runner.Execute(context.Background()))
+		assert.Equal(t, []string{"configure.sh", "verify.sh", "cleanup.sh", "unconfigure.sh"}, mockExec.GetExecutedFiles())
+		assert.Equal(t, []string{"install"}, runner.State().CompletedSteps)
+	})
+
+	t.Run("Records failed rollbacks", func(t *testing.T) {
+		runner, err := NewRunner(rollbackPlan("run"), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		mockExec := failing(map[string]bool{"verify.sh": true, "unconfigure.sh": true})
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "verify.sh failed")
+
+		state := runner.State()
+		require.Len(t, state.Rollbacks, 3)
+		assert.False(t, state.Rollbacks[1].Success)
+		assert.Contains(t, state.Rollbacks[1].Error, "unconfigure.sh failed")
+		// The step whose rollback failed is still considered completed
+		assert.Equal(t, []string{"configure"}, state.CompletedSteps)
+	})
+
+	t.Run("Records rollback files apart from the step's files", func(t *testing.T) {
+		runner, err := NewRunner(rollbackPlan("run"), filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		mockExec := failing(map[string]bool{"verify.sh": true, "unconfigure.sh": true})
+		executeFunc := mockExec.executeFunc
+		mockExec.executeFunc = func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+			result, err := executeFunc(ctx, file)
+			result.Outputs = map[string]string{"FILE": file.Path}
+			return result, err
+		}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		require.Error(t, runner.Execute(context.Background()))
+
+		state := runner.State()
+		for stepID, files := range map[string][2]string{
+			"install":   {"install.sh", "uninstall.sh"},
+			"configure": {"configure.sh", "unconfigure.sh"},
+			"verify":    {"verify.sh", "cleanup.sh"},
+		} {
+			record := state.Steps[stepID]
+			require.NotNil(t, record, stepID)
+			require.Len(t, record.Files, 1, stepID)
+			assert.Equal(t, files[0], record.Files[0].Path)
+			require.Len(t, record.Rollback, 1, stepID)
+			assert.Equal(t, files[1], record.Rollback[0].Path)
+		}
+		assert.Equal(t, StatusFailed, state.Steps["configure"].Rollback[0].Status)
+
+		// Outputs and failures of rollback files are not the step's
+		assert.Equal(t, map[string]string{"FILE": "configure.sh"}, state.StepOutputs["configure"])
+		assert.Equal(t, []string{attemptKey("verify", "verify.sh")}, state.FailedFiles)
+		assert.NotContains(t, state.FileAttempts, attemptKey("configure", "unconfigure.sh"))
+	})
+}
@@ -2,40 +2,62 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/SphereStacking/plexr/internal/config"
 	"github.com/SphereStacking/plexr/internal/executors"
+	"github.com/SphereStacking/plexr/internal/utils"
+	"github.com/SphereStacking/plexr/internal/version"
 )
 
+// checkCommandTimeout bounds how long a check command may run
+const checkCommandTimeout = time.Minute
+
 // Runner manages the execution of an execution plan
 type Runner struct {
 	plan         *config.ExecutionPlan
+	stateFile    string // The lock file and the run history are kept next to it, whatever the state backend
 	stateManager *StateManager
 	executors    map[string]Executor
+	shell        *executors.ShellExecutor // Runs check commands
 	platform     string
+	maxParallel  int
+	options      RunOptions
+	// Steps executed during the current run, in completion order
+	executed   []string
+	executedMu sync.Mutex
+	// ID of the current run and the steps it selected, recorded in the history
+	runID    string
+	selected []string
+	// Versions of the tools detected during the current run
+	tools   map[string]*version.Version
+	toolsMu sync.Mutex
 	// Progress tracking
 	progressCallback func(stepID string, event string, data interface{})
+	progressMu       sync.Mutex
 }
 
 // NewRunner creates a new runner
 func NewRunner(plan *config.ExecutionPlan, stateFile string) (*Runner, error) {
-	sm, err := NewStateManager(stateFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create state manager: %w", err)
+	if stateFile == "" {
+		return nil, fmt.Errorf("failed to create state manager: state file path cannot be empty")
 	}
 
 	r := &Runner{
-		plan:         plan,
-		stateManager: sm,
-		executors:    make(map[string]Executor),
-		platform:     runtime.GOOS,
+		plan:      plan,
+		stateFile: stateFile,
+		executors: make(map[string]Executor),
+		shell:     executors.NewShellExecutor(),
+		platform:  runtime.GOOS,
 	}
 
 	// Register built-in executors
-	r.executors["shell"] = executors.NewShellExecutor()
+	r.executors["shell"] = r.shell
 	r.executors["sql"] = executors.NewSQLExecutor()
 
 	// Initialize and validate executors with their configurations
@@ -80,9 +102,20 @@ func NewRunner(plan *config.ExecutionPlan, stateFile string) (*Runner, error) {
 		}
 	}
 
+	store, err := OpenStateStore(plan, stateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state manager: %w", err)
+	}
+	r.stateManager = NewStateManagerWithStore(store)
+
 	return r, nil
 }
 
+// Close releases the state store of the runner
+func (r *Runner) Close() error {
+	return r.stateManager.Close()
+}
+
 // RegisterExecutor registers a custom executor
 func (r *Runner) RegisterExecutor(name string, executor Executor) error {
 	if name == "" {
@@ -100,24 +133,140 @@ func (r *Runner) SetProgressCallback(callback func(stepID string, event string,
 	r.progressCallback = callback
 }
 
-// notifyProgress sends a progress notification if a callback is registered
+// SetMaxParallel sets the maximum number of steps executed concurrently.
+// A value of 0 falls back to the plan's max_parallel setting.
+func (r *Runner) SetMaxParallel(n int) {
+	r.maxParallel = n
+}
+
+// parallelism returns the effective number of concurrent steps
+func (r *Runner) parallelism() int {
+	if r.maxParallel > 0 {
+		return r.maxParallel
+	}
+	if r.plan.MaxParallel > 0 {
+		return r.plan.MaxParallel
+	}
+	return 1
+}
+
+// notifyProgress sends a progress notification if a callback is registered.
+// Notifications are serialized so callbacks never run concurrently.
 func (r *Runner) notifyProgress(stepID string, event string, data interface{}) {
 	if r.progressCallback != nil {
-		r.progressCallback(stepID, event, data)
+		r.progressMu.Lock()
+		defer r.progressMu.Unlock()
+		r.progressCallback(stepID, event, maskProgressData(data))
 	}
 }
 
-// State returns the current execution state
+// maskProgressData masks secret values in the strings of progress event data
+func maskProgressData(data interface{}) interface{} {
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+
+	masked := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		switch v := value.(type) {
+		case string:
+			masked[key] = utils.MaskSecrets(v)
+		case []string:
+			values := make([]string, len(v))
+			for i, s := range v {
+				values[i] = utils.MaskSecrets(s)
+			}
+			masked[key] = values
+		default:
+			masked[key] = value
+		}
+	}
+	return masked
+}
+
+// State returns the current execution state, with secret values masked
 func (r *Runner) State() *ExecutionState {
+	// Reloading the stored state during a run would replace the values of
+	// step outputs with their masked form
+	if state, err := r.stateManager.Snapshot(); err == nil {
+		return state
+	}
 	state, _ := r.stateManager.Load()
 	return state
 }
 
-// Execute runs the execution plan
+// Execute runs the execution plan. The state is locked for the duration of
+// the run, so that the plan cannot run twice at the same time.
+// Every run is recorded in the history journal next to the state file.
 func (r *Runner) Execute(ctx context.Context) error {
-	// Load or create state
-	_, err := r.stateManager.Load()
+	lock, err := r.stateManager.Lock(r.stateFile)
 	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	r.runID = newRunID(start)
+	r.selected = nil
+
+	err = r.execute(ctx)
+	// A run stopped by a plan change has not started and is not recorded
+	var change *PlanChangedError
+	if errors.As(err, &change) {
+		r.runID = ""
+	} else {
+		if historyErr := AppendRun(r.stateFile, r.runRecord(r.runID, start, err)); historyErr != nil && err == nil {
+			err = historyErr
+		}
+	}
+	if releaseErr := lock.Release(); releaseErr != nil && err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+// RunID returns the ID of the current or last run in the history journal
+func (r *Runner) RunID() string {
+	return r.runID
+}
+
+// execute runs the execution plan while holding the lock of the state file
+func (r *Runner) execute(ctx context.Context) error {
+	// Load or create state. A state file that cannot be read is an error,
+	// as starting over would silently lose the progress it records.
+	state, err := r.stateManager.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	// A state recorded for another plan or plan version is only used as the
+	// options allow
+	var newSteps []string
+	onlyNewSteps := false
+	if state != nil {
+		if change := DetectPlanChange(state, r.plan); change != nil {
+			switch r.options.OnPlanChange {
+			case PlanChangeReset:
+				if err := r.stateManager.Reset(); err != nil {
+					return fmt.Errorf("failed to reset state: %w", err)
+				}
+				state = nil
+			case PlanChangeContinue:
+			case PlanChangeNewSteps:
+				newSteps = NewPlanSteps(state, r.plan)
+				onlyNewSteps = true
+			default:
+				return change
+			}
+			r.notifyProgress("", "plan_changed", map[string]interface{}{
+				"change": change.Error(),
+				"action": string(r.options.OnPlanChange),
+				"steps":  newSteps,
+			})
+		}
+	}
+
+	if state == nil {
 		// Create new state
 		state := &ExecutionState{
 			SetupName:      r.plan.Name,
@@ -131,70 +280,227 @@ func (r *Runner) Execute(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("failed to save initial state: %w", err)
 		}
+	} else if len(state.InterruptedSteps) > 0 {
+		// Report that the previous run did not finish
+		data := map[string]interface{}{"steps": state.InterruptedSteps}
+		if state.InterruptedAt != nil {
+			data["time"] = *state.InterruptedAt
+		}
+		r.notifyProgress("", "resuming_interrupted", data)
+		if err := r.stateManager.ClearInterrupted(); err != nil {
+			return fmt.Errorf("failed to update state: %w", err)
+		}
+	}
+
+	// The state now belongs to this version of the plan
+	if err := r.stateManager.SetPlan(r.plan.Name, r.plan.Version, planStepIDs(r.plan)); err != nil {
+		return fmt.Errorf("failed to update state: %w", err)
 	}
 
-	// Build execution order based on dependencies
-	order, err := r.buildExecutionOrder()
+	// Build execution order based on dependencies and the selected steps
+	order, err := SelectSteps(r.plan, r.options)
 	if err != nil {
 		return fmt.Errorf("failed to build execution order: %w", err)
 	}
+	if onlyNewSteps {
+		order = filterStrings(order, func(id string) bool { return containsString(newSteps, id) })
+	}
+	r.selected = order
 
-	// Execute steps in order
-	for _, stepID := range order {
-		step := r.findStep(stepID)
-		if step == nil {
-			return fmt.Errorf("step not found: %s", stepID)
+	if r.options.Dependencies == DependenciesEnforce {
+		for _, dep := range UnselectedDependencies(r.plan, order) {
+			if !r.stateManager.IsStepCompleted(dep) {
+				return fmt.Errorf("dependency %s has not been completed", dep)
+			}
+		}
+	}
+
+	r.executedMu.Lock()
+	r.executed = nil
+	r.executedMu.Unlock()
+
+	r.toolsMu.Lock()
+	r.tools = nil
+	r.toolsMu.Unlock()
+
+	// Check the plan's prerequisites before any step runs
+	if err := r.checkRequirements(ctx, r.plan.Requires, r.stepEnv(&config.Step{})); err != nil {
+		return err
+	}
+
+	// Execute steps, running independent branches concurrently
+	return r.schedule(ctx, order)
+}
+
+// runStep runs a single step unless it is already completed or skipped
+func (r *Runner) runStep(ctx context.Context, stepID string) error {
+	step := r.findStep(stepID)
+	if step == nil {
+		return fmt.Errorf("step not found: %s", stepID)
+	}
+
+	start := time.Now()
+
+	// Check if already completed. A completed step whose files changed runs
+	// again if rerun_on_change is set, and is otherwise reported.
+	rerun := false
+	if r.stateManager.IsStepCompleted(stepID) {
+		changed, err := r.stepChanged(step)
+		if err != nil {
+			return r.stepFailed(ctx, step, start, err, err)
+		}
+		if !changed {
+			r.notifyProgress(stepID, "skipped", map[string]interface{}{"reason": "already_completed"})
+			return nil
 		}
 
-		// Check if already completed
-		if r.stateManager.IsStepCompleted(stepID) {
+		rerun = r.rerunOnChange(step)
+		r.notifyProgress(stepID, "changed", map[string]interface{}{"rerun": rerun})
+		if !rerun {
 			r.notifyProgress(stepID, "skipped", map[string]interface{}{"reason": "already_completed"})
-			continue
+			return nil
+		}
+		if err := r.stateManager.MarkStepIncomplete(stepID); err != nil {
+			return fmt.Errorf("failed to update step %s: %w", stepID, err)
+		}
+	}
+
+	// Check skip condition
+	if step.SkipIf != "" {
+		shouldSkip, err := r.evaluateCondition(ctx, step, step.SkipIf)
+		if err != nil {
+			return r.stepFailed(ctx, step, start, err, fmt.Errorf("failed to evaluate skip_if for step %s: %w", stepID, err))
 		}
 
-		// Check skip condition
-		if step.SkipIf != "" {
-			// Evaluate skip condition
-			shouldSkip := false
-			switch step.SkipIf {
-			case "true":
-				shouldSkip = true
-			case "false":
-				shouldSkip = false
-			default:
-				// Check if the condition is a step ID that has been completed
-				shouldSkip = r.stateManager.IsStepCompleted(step.SkipIf)
+		if shouldSkip {
+			r.notifyProgress(stepID, "skipped", map[string]interface{}{"reason": "skip_if_condition", "condition": step.SkipIf})
+			// Mark as completed even if skipped
+			if err := r.stateManager.MarkStepCompleted(stepID); err != nil {
+				return fmt.Errorf("failed to mark skipped step %s as completed: %w", stepID, err)
 			}
-
-			if shouldSkip {
-				r.notifyProgress(stepID, "skipped", map[string]interface{}{"reason": "skip_if_condition", "condition": step.SkipIf})
-				// Mark as completed even if skipped
-				err = r.stateManager.MarkStepCompleted(stepID)
-				if err != nil {
-					return fmt.Errorf("failed to mark skipped step %s as completed: %w", stepID, err)
-				}
-				continue
+			if err := r.stateManager.FinishStep(stepID, StatusSkipped, "skip_if_condition", ""); err != nil {
+				return fmt.Errorf("failed to update step %s: %w", stepID, err)
 			}
+			return r.recordFingerprint(step)
 		}
+	}
 
-		// Execute step
-		err = r.executeStep(ctx, step)
+	// Check whether the step's goal is already satisfied. A step that runs
+	// again because it changed has to apply the change.
+	if step.CheckCommand != "" && !rerun {
+		satisfied, err := r.runCheck(ctx, step, step.CheckCommand)
 		if err != nil {
-			r.notifyProgress(stepID, "failed", map[string]interface{}{"error": err.Error()})
-			return fmt.Errorf("failed to execute step %s: %w", stepID, err)
+			return r.stepFailed(ctx, step, start, err, fmt.Errorf("failed to run check command for step %s: %w", stepID, err))
 		}
+		if satisfied {
+			r.notifyProgress(stepID, "skipped", map[string]interface{}{"reason": "check_command_satisfied", "command": step.CheckCommand})
+			if err := r.stateManager.MarkStepCompleted(stepID); err != nil {
+				return fmt.Errorf("failed to mark satisfied step %s as completed: %w", stepID, err)
+			}
+			if err := r.stateManager.FinishStep(stepID, StatusSkipped, "check_command_satisfied", ""); err != nil {
+				return fmt.Errorf("failed to update step %s: %w", stepID, err)
+			}
+			return r.recordFingerprint(step)
+		}
+	}
+
+	if err := r.stateManager.StartStep(stepID, start); err != nil {
+		return fmt.Errorf("failed to update step %s: %w", stepID, err)
+	}
+	fingerprint, err := r.stepFingerprint(step)
+	if err != nil {
+		return r.stepFailed(ctx, step, start, err, err)
+	}
+	if err := r.stateManager.SetStepFingerprint(stepID, fingerprint); err != nil {
+		return fmt.Errorf("failed to update step %s: %w", stepID, err)
+	}
+
+	// Check the tools the step requires
+	if err := r.checkRequirements(ctx, step.Requires, r.stepEnv(step)); err != nil {
+		return r.stepFailed(ctx, step, start, err, fmt.Errorf("prerequisites of step %s are not met: %w", stepID, err))
+	}
 
-		// Mark as completed
-		err = r.stateManager.MarkStepCompleted(stepID)
+	// Execute step
+	if err := r.executeStep(ctx, step); err != nil {
+		return r.stepFailed(ctx, step, start, err, fmt.Errorf("failed to execute step %s: %w", stepID, err))
+	}
+
+	// Verify that the step achieved its goal
+	if step.CheckAfter {
+		satisfied, err := r.runCheck(ctx, step, step.CheckCommand)
+		if err == nil && !satisfied {
+			err = fmt.Errorf("check command still fails after execution: %s", step.CheckCommand)
+		}
 		if err != nil {
-			return fmt.Errorf("failed to mark step %s as completed: %w", stepID, err)
+			return r.stepFailed(ctx, step, start, err, fmt.Errorf("failed to verify step %s: %w", stepID, err))
+		}
+	}
+
+	return r.completeStep(step, start, nil)
+}
+
+// completeStep records a step executed during this run as completed.
+// ignored is the failure of a step whose failures are ignored, if any.
+func (r *Runner) completeStep(step *config.Step, start time.Time, ignored error) error {
+	if err := r.stateManager.MarkStepCompleted(step.ID); err != nil {
+		return fmt.Errorf("failed to mark step %s as completed: %w", step.ID, err)
+	}
+	if err := r.stateManager.SetStepFailed(step.ID, false); err != nil {
+		return fmt.Errorf("failed to update step %s: %w", step.ID, err)
+	}
+	message := ""
+	if ignored != nil {
+		message = ignored.Error()
+	}
+	if err := r.stateManager.FinishStep(step.ID, StatusCompleted, "", message); err != nil {
+		return fmt.Errorf("failed to update step %s: %w", step.ID, err)
+	}
+	// Steps that failed before they started executing have no fingerprint yet
+	if r.stateManager.StepFingerprint(step.ID) == "" {
+		if err := r.recordFingerprint(step); err != nil {
+			return err
 		}
-		r.notifyProgress(stepID, "completed", nil)
 	}
 
+	r.executedMu.Lock()
+	r.executed = append(r.executed, step.ID)
+	r.executedMu.Unlock()
+
+	r.notifyProgress(step.ID, "completed", map[string]interface{}{"duration": time.Since(start)})
 	return nil
 }
 
+// stepFailed applies the step's on_failure policy to a failure. cause is
+// reported to the progress callback and err is returned to the scheduler.
+// Ignored failures are reported as a warning and the step is completed.
+// Failures caused by a cancellation are recorded as interruptions instead.
+func (r *Runner) stepFailed(ctx context.Context, step *config.Step, start time.Time, cause, err error) error {
+	if ctx.Err() != nil {
+		r.notifyProgress(step.ID, "interrupted", map[string]interface{}{"error": cause.Error()})
+		if stateErr := r.stateManager.MarkStepInterrupted(step.ID); stateErr != nil {
+			return fmt.Errorf("%w (failed to record interruption: %v)", err, stateErr)
+		}
+		if stateErr := r.stateManager.FinishStep(step.ID, StatusInterrupted, "", cause.Error()); stateErr != nil {
+			return fmt.Errorf("%w (failed to record interruption: %v)", err, stateErr)
+		}
+		return err
+	}
+
+	if step.OnFailure == "ignore" {
+		r.notifyProgress(step.ID, "failure_ignored", map[string]interface{}{"error": cause.Error()})
+		return r.completeStep(step, start, cause)
+	}
+
+	r.notifyProgress(step.ID, "failed", map[string]interface{}{"error": cause.Error()})
+	if stateErr := r.stateManager.SetStepFailed(step.ID, true); stateErr != nil {
+		return fmt.Errorf("%w (failed to record failure: %v)", err, stateErr)
+	}
+	if stateErr := r.stateManager.FinishStep(step.ID, StatusFailed, "", cause.Error()); stateErr != nil {
+		return fmt.Errorf("%w (failed to record failure: %v)", err, stateErr)
+	}
+	return err
+}
+
 // executeStep executes a single step
 func (r *Runner) executeStep(ctx context.Context, step *config.Step) error {
 	executor, ok := r.executors[step.Executor]
@@ -208,43 +514,134 @@ func (r *Runner) executeStep(ctx context.Context, step *config.Step) error {
 		return err
 	}
 
-	for _, fileConfig := range step.Files {
-		// Use step work_directory if specified, otherwise use global work_directory
-		workDir := step.WorkDirectory
-		if workDir == "" {
-			workDir = r.plan.WorkDirectory
+	// Outputs of a previous execution of the step are replaced
+	if err := r.stateManager.ClearStepOutputs(step.ID); err != nil {
+		return err
+	}
+
+	return r.runFiles(ctx, step, executor, step.Files, false)
+}
+
+// runFiles executes files of a step in order, honoring platform and skip_if.
+// Outputs emitted by a file are recorded and passed to the files after it.
+// Rollback files are recorded apart from the step's files and their outputs
+// are discarded.
+func (r *Runner) runFiles(ctx context.Context, step *config.Step, executor Executor, files []config.FileConfig, rollback bool) error {
+	workDir := r.stepWorkDir(step)
+	for _, fileConfig := range files {
+		if fileConfig.Platform != "" && fileConfig.Platform != r.platform {
+			r.notifyProgress(step.ID, "file_skipped", map[string]interface{}{"file": fileConfig.Path, "reason": "platform", "platform": fileConfig.Platform})
+			if err := r.recordSkippedFile(step.ID, fileConfig.Path, "platform", rollback); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Check file skip condition
+		if fileConfig.SkipIf != "" {
+			shouldSkip, err := r.evaluateCondition(ctx, step, fileConfig.SkipIf)
+			if err != nil {
+				return fmt.Errorf("failed to evaluate skip_if for file %s: %w", fileConfig.Path, err)
+			}
+			if shouldSkip {
+				r.notifyProgress(step.ID, "file_skipped", map[string]interface{}{"file": fileConfig.Path, "reason": "skip_if_condition", "condition": fileConfig.SkipIf})
+				if err := r.recordSkippedFile(step.ID, fileConfig.Path, "skip_if_condition", rollback); err != nil {
+					return err
+				}
+				continue
+			}
 		}
 
 		file := executors.ExecutionFile{
 			Path:            fileConfig.Path,
 			Timeout:         fileConfig.Timeout,
-			Retry:           fileConfig.Retry,
+			Retry:           fileConfig.Retry.Max,
 			Platform:        fileConfig.Platform,
 			WorkDirectory:   workDir,
 			TransactionMode: step.TransactionMode,
+			Env:             r.stepEnv(step),
 		}
 
-		r.notifyProgress(step.ID, "executing_file", map[string]interface{}{"file": file.Path})
-		result, err := executor.Execute(ctx, file)
+		result, err := r.executeFile(ctx, step.ID, executor, file, fileConfig.Retry, rollback)
 		if err != nil {
 			return err
 		}
 
-		if !result.Success {
-			return fmt.Errorf("execution failed")
-		}
-
 		// Show output if available
 		if result.Output != "" {
 			r.notifyProgress(step.ID, "output", map[string]interface{}{"output": result.Output})
 		}
+		if rollback {
+			continue
+		}
+
+		// Later steps get the values as emitted; secrets are masked when the
+		// state is written
+		if err := r.stateManager.AddStepOutputs(step.ID, result.Outputs); err != nil {
+			return fmt.Errorf("failed to record outputs of %s: %w", fileConfig.Path, err)
+		}
 	}
 
 	return nil
 }
 
-// buildExecutionOrder builds the execution order based on dependencies
-func (r *Runner) buildExecutionOrder() ([]string, error) {
+// recordSkippedFile records a file that was not executed
+func (r *Runner) recordSkippedFile(stepID, path, reason string, rollback bool) error {
+	now := time.Now()
+	record := FileRecord{Path: path, Status: StatusSkipped, StartedAt: now, FinishedAt: &now, Reason: reason}
+	if err := r.recordFileRecord(stepID, record, rollback); err != nil {
+		return fmt.Errorf("failed to record skipped file %s: %w", path, err)
+	}
+	return nil
+}
+
+// runCheck runs a check command of a step and reports whether it succeeded
+func (r *Runner) runCheck(ctx context.Context, step *config.Step, check string) (bool, error) {
+	command, err := r.expandOutputs(check)
+	if err != nil {
+		return false, err
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, checkCommandTimeout)
+	defer cancel()
+
+	r.notifyProgress(step.ID, "checking", map[string]interface{}{"command": command})
+	result, err := r.shell.RunCommand(checkCtx, command, r.stepWorkDir(step), r.stepEnv(step))
+	if err != nil {
+		return false, err
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	return result.Success, nil
+}
+
+// stepWorkDir returns the step work_directory if specified, otherwise the global work_directory
+func (r *Runner) stepWorkDir(step *config.Step) string {
+	if step.WorkDirectory != "" {
+		return step.WorkDirectory
+	}
+	return r.plan.WorkDirectory
+}
+
+// stepEnv returns the environment variables of the step's files and commands:
+// the variables of the plan's and the step's env files, the outputs of
+// earlier steps and the plan's secrets, each overriding the ones before
+func (r *Runner) stepEnv(step *config.Step) map[string]string {
+	env := make(map[string]string)
+	for _, vars := range []map[string]string{r.plan.Environment, step.Environment, r.outputEnv()} {
+		for name, value := range vars {
+			env[name] = value
+		}
+	}
+	for name, value := range r.plan.SecretValues {
+		env[config.SecretEnvName(name)] = value
+	}
+	return env
+}
+
+// executionOrder builds the execution order based on dependencies
+func executionOrder(plan *config.ExecutionPlan) ([]string, error) {
 	var order []string
 	visited := make(map[string]bool)
 	recStack := make(map[string]bool)
@@ -261,7 +658,7 @@ func (r *Runner) buildExecutionOrder() ([]string, error) {
 
 		recStack[stepID] = true
 
-		step := r.findStep(stepID)
+		step := findStep(plan, stepID)
 		if step == nil {
 			return fmt.Errorf("step not found: %s", stepID)
 		}
@@ -279,7 +676,7 @@ func (r *Runner) buildExecutionOrder() ([]string, error) {
 		return nil
 	}
 
-	for _, step := range r.plan.Steps {
+	for _, step := range plan.Steps {
 		if !visited[step.ID] {
 			err := visit(step.ID)
 			if err != nil {
@@ -293,9 +690,14 @@ func (r *Runner) buildExecutionOrder() ([]string, error) {
 
 // findStep finds a step by ID
 func (r *Runner) findStep(id string) *config.Step {
-	for i := range r.plan.Steps {
-		if r.plan.Steps[i].ID == id {
-			return &r.plan.Steps[i]
+	return findStep(r.plan, id)
+}
+
+// findStep finds a step of a plan by ID
+func findStep(plan *config.ExecutionPlan, id string) *config.Step {
+	for i := range plan.Steps {
+		if plan.Steps[i].ID == id {
+			return &plan.Steps[i]
 		}
 	}
 	return nil
@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/SphereStacking/plexr/internal/config"
 	"github.com/SphereStacking/plexr/internal/executors"
+	"github.com/SphereStacking/plexr/internal/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -350,6 +352,149 @@ func TestRunner(t *testing.T) {
 		assert.Equal(t, stepWorkDir, workDirs["custom.sh"])
 	})
 
+	t.Run("Execute records steps and files", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		stateFile := filepath.Join(tmpDir, "state.json")
+
+		plan := &config.ExecutionPlan{
+			Name:    "Records Test",
+			Version: "1.0.0",
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{
+					ID:       "build",
+					Executor: "mock",
+					Files: []config.FileConfig{
+						{Path: "build.sh"},
+						{Path: "other.sh", Platform: "plan9"},
+					},
+				},
+				{ID: "docs", Executor: "mock", SkipIf: "true", Files: []config.FileConfig{{Path: "docs.sh"}}},
+				{ID: "lint", Executor: "mock", OnFailure: "ignore", Files: []config.FileConfig{{Path: "lint.sh"}}},
+				{ID: "test", Executor: "mock", DependsOn: []string{"build"}, Files: []config.FileConfig{{Path: "test.sh"}}},
+			},
+		}
+
+		runner, err := NewRunner(plan, stateFile)
+		require.NoError(t, err)
+		mockExec := &MockExecutor{
+			name: "mock",
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				if file.Path == "build.sh" {
+					return &executors.ExecutionResult{Success: true, Output: "built\n"}, nil
+				}
+				return &executors.ExecutionResult{Success: false, Output: "FAIL\n", ExitCode: 2, Error: errors.New("exit status 2")}, nil
+			},
+		}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		require.Error(t, runner.Execute(context.Background()))
+		steps := runner.State().Steps
+
+		require.Contains(t, steps, "build")
+		assert.Equal(t, StatusCompleted, steps["build"].Status)
+		assert.Equal(t, 1, steps["build"].Attempts)
+		require.NotNil(t, steps["build"].FinishedAt)
+		require.Len(t, steps["build"].Files, 2)
+		assert.Equal(t, "build.sh", steps["build"].Files[0].Path)
+		assert.Equal(t, StatusCompleted, steps["build"].Files[0].Status)
+		assert.Equal(t, "built\n", steps["build"].Files[0].Output)
+		assert.Equal(t, StatusSkipped, steps["build"].Files[1].Status)
+		assert.Equal(t, "platform", steps["build"].Files[1].Reason)
+
+		require.Contains(t, steps, "docs")
+		assert.Equal(t, StatusSkipped, steps["docs"].Status)
+		assert.Equal(t, "skip_if_condition", steps["docs"].Reason)
+
+		require.Contains(t, steps, "lint")
+		assert.Equal(t, StatusCompleted, steps["lint"].Status)
+		assert.Contains(t, steps["lint"].Error, "exit status 2")
+
+		require.Contains(t, steps, "test")
+		assert.Equal(t, StatusFailed, steps["test"].Status)
+		assert.Contains(t, steps["test"].Error, "exit status 2")
+		require.Len(t, steps["test"].Files, 1)
+		assert.Equal(t, StatusFailed, steps["test"].Files[0].Status)
+		assert.Equal(t, 2, steps["test"].Files[0].ExitCode)
+		assert.Equal(t, "FAIL\n", steps["test"].Files[0].Output)
+	})
+
+	t.Run("Execute fails on a corrupted state file", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		stateFile := filepath.Join(tmpDir, "state.json")
+		require.NoError(t, os.WriteFile(stateFile, []byte(`{"completed_steps": ["step1"`), 0600))
+
+		plan := &config.ExecutionPlan{
+			Name:    "Corrupt Test",
+			Version: "1.0.0",
+			Steps:   []config.Step{{ID: "step1", Executor: "mock", Files: []config.FileConfig{{Path: "step1.sh"}}}},
+		}
+		runner, err := NewRunner(plan, stateFile)
+		require.NoError(t, err)
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		var corrupt *CorruptStateError
+		require.True(t, errors.As(err, &corrupt))
+		assert.Empty(t, mockExec.GetExecutedFiles())
+
+		// The state file is left for recovery
+		data, err := os.ReadFile(stateFile)
+		require.NoError(t, err)
+		assert.Equal(t, `{"completed_steps": ["step1"`, string(data))
+	})
+
+	t.Run("Execute with env files", func(t *testing.T) {
+		plan := &config.ExecutionPlan{
+			Name:        "Env Test",
+			Version:     "1.0.0",
+			Environment: map[string]string{"DB_HOST": "db.internal", "DB_USER": "app"},
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{
+					ID:       "migrate",
+					Executor: "mock",
+					Files:    []config.FileConfig{{Path: "migrate.sql"}},
+				},
+				{
+					ID:          "seed",
+					Executor:    "mock",
+					DependsOn:   []string{"migrate"},
+					Environment: map[string]string{"DB_USER": "seeder"},
+					Files: []config.FileConfig{
+						{Path: "seed.sql"},
+						{Path: "admin.sql", SkipIf: `env.DB_USER == "seeder"`},
+					},
+				},
+			},
+		}
+
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+
+		envs := make(map[string]map[string]string)
+		mockExec := &MockExecutor{
+			name: "mock",
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				envs[file.Path] = file.Env
+				return &executors.ExecutionResult{Success: true}, nil
+			},
+		}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		require.NoError(t, runner.Execute(context.Background()))
+
+		assert.Equal(t, map[string]string{"DB_HOST": "db.internal", "DB_USER": "app"}, envs["migrate.sql"])
+		assert.Equal(t, map[string]string{"DB_HOST": "db.internal", "DB_USER": "seeder"}, envs["seed.sql"])
+		// skip_if sees the step's env files
+		assert.NotContains(t, envs, "admin.sql")
+	})
+
 	t.Run("Skip completed steps", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		stateFile := filepath.Join(tmpDir, "state.json")
@@ -447,13 +592,13 @@ func TestRunner(t *testing.T) {
 				{
 					ID:       "skip-if-file-exists",
 					Executor: "mock",
-					SkipIf:   "test -f " + testFile,
+					SkipIf:   `file_exists("` + testFile + `")`,
 					Files:    []config.FileConfig{{Path: "skip-exists.sh"}},
 				},
 				{
 					ID:       "skip-if-file-not-exists",
 					Executor: "mock",
-					SkipIf:   "test -f /non/existent/file",
+					SkipIf:   `file_exists("/non/existent/file")`,
 					Files:    []config.FileConfig{{Path: "skip-not-exists.sh"}},
 				},
 			},
@@ -474,10 +619,7 @@ func TestRunner(t *testing.T) {
 		err = runner.Execute(ctx)
 		assert.NoError(t, err)
 
-		// Since "test -f ..." is treated as a step ID (not a shell command),
-		// and no steps with those IDs exist, they won't skip.
-		// Only skip-always (with SkipIf: "true") will be skipped.
-		expectedFiles := []string{"skip-never.sh", "skip-exists.sh", "skip-not-exists.sh"}
+		expectedFiles := []string{"skip-never.sh", "skip-not-exists.sh"}
 		assert.Equal(t, expectedFiles, mockExec.GetExecutedFiles())
 
 		// Verify all steps are marked as completed (even skipped ones)
@@ -486,6 +628,96 @@ func TestRunner(t *testing.T) {
 		assert.Len(t, state.CompletedSteps, 4)
 	})
 
+	t.Run("Execute with skip_if expressions", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		stateFile := filepath.Join(tmpDir, "state.json")
+		t.Setenv("PLEXR_TEST_SKIP", "yes")
+
+		plan := &config.ExecutionPlan{
+			Name:    "Skip If Expression Test",
+			Version: "1.0.0",
+			Vars:    map[string]string{"env": "ci"},
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{
+					ID:       "first",
+					Executor: "mock",
+					Files:    []config.FileConfig{{Path: "first.sh"}},
+				},
+				{
+					ID:        "after-first",
+					Executor:  "mock",
+					DependsOn: []string{"first"},
+					SkipIf:    "first && env.PLEXR_TEST_SKIP == \"yes\"",
+					Files:     []config.FileConfig{{Path: "after-first.sh"}},
+				},
+				{
+					ID:        "files",
+					Executor:  "mock",
+					DependsOn: []string{"after-first"},
+					Files: []config.FileConfig{
+						{Path: "kept.sh", SkipIf: "os == \"plan9\""},
+						{Path: "dropped.sh", SkipIf: `command("true")`},
+						{Path: "local.sh", SkipIf: `vars.env == "ci"`},
+					},
+				},
+			},
+		}
+
+		runner, err := NewRunner(plan, stateFile)
+		require.NoError(t, err)
+
+		mockExec := &MockExecutor{
+			name:          "mock",
+			executedFiles: []string{},
+		}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		var skippedFiles []string
+		runner.SetProgressCallback(func(stepID string, status string, data interface{}) {
+			if status == "file_skipped" {
+				skippedFiles = append(skippedFiles, data.(map[string]interface{})["file"].(string))
+			}
+		})
+
+		err = runner.Execute(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"first.sh", "kept.sh"}, mockExec.GetExecutedFiles())
+		assert.Equal(t, []string{"dropped.sh", "local.sh"}, skippedFiles)
+	})
+
+	t.Run("Execute fails on invalid skip_if", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		stateFile := filepath.Join(tmpDir, "state.json")
+
+		plan := &config.ExecutionPlan{
+			Name:    "Invalid Skip If Test",
+			Version: "1.0.0",
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{
+					ID:       "bad",
+					Executor: "mock",
+					SkipIf:   "vars.missing == \"x\"",
+					Files:    []config.FileConfig{{Path: "bad.sh"}},
+				},
+			},
+		}
+
+		runner, err := NewRunner(plan, stateFile)
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", &MockExecutor{name: "mock"}))
+
+		err = runner.Execute(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to evaluate skip_if for step bad")
+	})
+
 	t.Run("Execute with multiple files per step", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		stateFile := filepath.Join(tmpDir, "state.json")
@@ -694,3 +926,283 @@ func TestRunner(t *testing.T) {
 		assert.Contains(t, err.Error(), "executor not found: nonexistent")
 	})
 }
+
+func TestRunnerParallel(t *testing.T) {
+	newPlan := func(maxParallel int) *config.ExecutionPlan {
+		return &config.ExecutionPlan{
+			Name:        "Parallel Test",
+			Version:     "1.0.0",
+			MaxParallel: maxParallel,
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{ID: "main", Executor: "mock", Files: []config.FileConfig{{Path: "main.sh"}}},
+				{ID: "analytics", Executor: "mock", Files: []config.FileConfig{{Path: "analytics.sh"}}},
+				{ID: "logs", Executor: "mock", Files: []config.FileConfig{{Path: "logs.sh"}}},
+				{
+					ID:        "fdw",
+					Executor:  "mock",
+					DependsOn: []string{"main", "analytics"},
+					Files:     []config.FileConfig{{Path: "fdw.sh"}},
+				},
+			},
+		}
+	}
+
+	t.Run("Independent steps run concurrently", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		runner, err := NewRunner(newPlan(3), filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+
+		var mu sync.Mutex
+		running, peak := 0, 0
+		finished := make(map[string]bool)
+		mockExec := &MockExecutor{
+			name: "mock",
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				mu.Lock()
+				if file.Path == "fdw.sh" {
+					// Dependencies must have finished before fdw starts
+					assert.True(t, finished["main.sh"])
+					assert.True(t, finished["analytics.sh"])
+				}
+				running++
+				if running > peak {
+					peak = running
+				}
+				mu.Unlock()
+
+				time.Sleep(100 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				finished[file.Path] = true
+				mu.Unlock()
+				return &executors.ExecutionResult{Success: true}, nil
+			},
+		}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, peak)
+		assert.Len(t, mockExec.GetExecutedFiles(), 4)
+
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"main", "analytics", "logs", "fdw"}, state.CompletedSteps)
+	})
+
+	t.Run("SetMaxParallel overrides plan setting", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		runner, err := NewRunner(newPlan(3), filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+		runner.SetMaxParallel(1)
+
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		require.NoError(t, err)
+
+		// Sequential execution keeps the dependency order
+		assert.Equal(t, []string{"main.sh", "analytics.sh", "logs.sh", "fdw.sh"}, mockExec.GetExecutedFiles())
+	})
+
+	t.Run("No new steps are launched after a failure", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		runner, err := NewRunner(newPlan(2), filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+
+		mockExec := &MockExecutor{
+			name: "mock",
+			executionDelays: map[string]time.Duration{
+				"analytics.sh": 100 * time.Millisecond,
+			},
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				if file.Path == "main.sh" {
+					return &executors.ExecutionResult{Success: false}, errors.New("intentional failure")
+				}
+				return &executors.ExecutionResult{Success: true}, nil
+			},
+		}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to execute step main")
+
+		// The running analytics step finishes, but logs and fdw never start
+		assert.ElementsMatch(t, []string{"main.sh", "analytics.sh"}, mockExec.GetExecutedFiles())
+
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		assert.Contains(t, state.CompletedSteps, "analytics")
+		assert.NotContains(t, state.CompletedSteps, "main")
+	})
+}
+
+func TestRunnerCheckCommand(t *testing.T) {
+	newPlan := func(checkCommand string, checkAfter bool) *config.ExecutionPlan {
+		return &config.ExecutionPlan{
+			Name:    "Check Command Test",
+			Version: "1.0.0",
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{
+					ID:           "install",
+					Executor:     "mock",
+					CheckCommand: checkCommand,
+					CheckAfter:   checkAfter,
+					Files:        []config.FileConfig{{Path: "install.sh"}},
+				},
+			},
+		}
+	}
+
+	t.Run("Satisfied check skips the step", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		runner, err := NewRunner(newPlan("exit 0", false), filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+
+		var reason interface{}
+		runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+			if event == "skipped" {
+				reason = data.(map[string]interface{})["reason"]
+			}
+		})
+
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, mockExec.GetExecutedFiles())
+		assert.Equal(t, "check_command_satisfied", reason)
+
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		assert.Contains(t, state.CompletedSteps, "install")
+	})
+
+	t.Run("Failing check runs the step", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		runner, err := NewRunner(newPlan("exit 1", false), filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"install.sh"}, mockExec.GetExecutedFiles())
+	})
+
+	t.Run("Check after execution verifies the step", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		marker := filepath.Join(tmpDir, "installed")
+		runner, err := NewRunner(newPlan("test -f "+marker, true), filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+
+		mockExec := &MockExecutor{
+			name: "mock",
+			executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+				return &executors.ExecutionResult{Success: true}, os.WriteFile(marker, nil, 0600)
+			},
+		}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"install.sh"}, mockExec.GetExecutedFiles())
+	})
+
+	t.Run("Check after execution fails the step", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		runner, err := NewRunner(newPlan("exit 1", true), filepath.Join(tmpDir, "state.json"))
+		require.NoError(t, err)
+
+		mockExec := &MockExecutor{name: "mock"}
+		require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+		err = runner.Execute(context.Background())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "check command still fails after execution")
+
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		assert.NotContains(t, state.CompletedSteps, "install")
+	})
+}
+
+func TestRunnerSecrets(t *testing.T) {
+	utils.AddSecret("s3cr3t-token")
+	t.Cleanup(utils.ResetSecrets)
+
+	plan := &config.ExecutionPlan{
+		Name:         "Secrets Test",
+		Version:      "1.0.0",
+		SecretValues: map[string]string{"api_token": "s3cr3t-token"},
+		Executors: map[string]config.ExecutorConfig{
+			"mock": {"type": "mock"},
+		},
+		Steps: []config.Step{
+			{
+				ID:       "deploy",
+				Executor: "mock",
+				Files:    []config.FileConfig{{Path: "deploy.sh"}, {Path: "verify.sh"}},
+			},
+		},
+	}
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	runner, err := NewRunner(plan, stateFile)
+	require.NoError(t, err)
+
+	var outputs []string
+	runner.SetProgressCallback(func(stepID string, event string, data interface{}) {
+		fields := data.(map[string]interface{})
+		switch event {
+		case "output":
+			outputs = append(outputs, fields["output"].(string))
+		case "failed":
+			outputs = append(outputs, fields["error"].(string))
+		}
+	})
+
+	var env map[string]string
+	mockExec := &MockExecutor{
+		name: "mock",
+		executeFunc: func(ctx context.Context, file executors.ExecutionFile) (*executors.ExecutionResult, error) {
+			if file.Path == "verify.sh" {
+				return nil, fmt.Errorf("token s3cr3t-token was rejected")
+			}
+			env = file.Env
+			return &executors.ExecutionResult{
+				Success: true,
+				Output:  "using s3cr3t-token",
+				Outputs: map[string]string{"token": "s3cr3t-token"},
+			}, nil
+		},
+	}
+	require.NoError(t, runner.RegisterExecutor("mock", mockExec))
+
+	require.Error(t, runner.Execute(context.Background()))
+
+	// Scripts receive the secrets
+	assert.Equal(t, "s3cr3t-token", env["PLEXR_SECRET_API_TOKEN"])
+
+	// but they are masked everywhere else
+	require.Len(t, outputs, 2)
+	assert.Equal(t, "using ********", outputs[0])
+	assert.Contains(t, outputs[1], "token ******** was rejected")
+	assert.Equal(t, "********", runner.State().StepOutputs["deploy"]["token"])
+
+	data, err := os.ReadFile(stateFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "s3cr3t-token")
+}
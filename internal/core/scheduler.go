@@ -0,0 +1,178 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// stepResult carries the outcome of a step launched by the scheduler
+type stepResult struct {
+	stepID string
+	err    error
+}
+
+// RunError reports the steps that failed or were blocked during a run
+type RunError struct {
+	Failed  []string // Steps that failed
+	Blocked []string // Steps not run because a dependency failed
+	// Steps that were running when the run was interrupted
+	Interrupted []string
+	Err         error // Error that aborted the run, nil if every failed step continued
+}
+
+// Error implements the error interface
+func (e *RunError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+
+	msg := fmt.Sprintf("%d step(s) failed: %s", len(e.Failed), strings.Join(e.Failed, ", "))
+	if len(e.Blocked) > 0 {
+		msg += fmt.Sprintf("; %d step(s) blocked: %s", len(e.Blocked), strings.Join(e.Blocked, ", "))
+	}
+	return msg
+}
+
+// Unwrap returns the error that aborted the run
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// Aborted reports whether a failure or an interruption stopped the run
+func (e *RunError) Aborted() bool {
+	return e.Err != nil
+}
+
+// WasInterrupted reports whether the run was stopped by a cancellation
+func (e *RunError) WasInterrupted() bool {
+	return errors.Is(e.Err, context.Canceled) || errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// schedule executes the steps of a dependency-ordered list. Every step whose
+// dependencies have finished is started as long as fewer than parallelism()
+// steps are running. Dependencies that are not part of order are treated as
+// finished.
+//
+// A failed step with on_failure: continue blocks its dependents while
+// independent branches keep running. Any other failure aborts the run: no new
+// steps are launched, but steps that are already running are allowed to
+// finish. Failed steps are rolled back once nothing is running.
+//
+// When ctx is canceled no new steps are launched and the run stops as soon as
+// the running steps have returned. Nothing is rolled back after an interruption.
+func (r *Runner) schedule(ctx context.Context, order []string) error {
+	limit := r.parallelism()
+	started := make(map[string]bool, len(order))
+	done := make(map[string]bool, len(order))
+	for _, dep := range UnselectedDependencies(r.plan, order) {
+		done[dep] = true
+	}
+	results := make(chan stepResult)
+	running := 0
+	finished := 0
+
+	var firstErr error
+	var failed, blocked, interrupted []string
+	unavailable := make(map[string]bool) // Failed or blocked steps
+	for {
+		// Launch ready steps in execution order while capacity allows
+		if firstErr == nil && ctx.Err() == nil {
+			for _, stepID := range order {
+				if running >= limit {
+					break
+				}
+				if started[stepID] {
+					continue
+				}
+				if dep := r.failedDependency(stepID, unavailable); dep != "" {
+					started[stepID] = true
+					unavailable[stepID] = true
+					blocked = append(blocked, stepID)
+					r.notifyProgress(stepID, "blocked", map[string]interface{}{"blocked_by": dep})
+					continue
+				}
+				if !r.dependenciesDone(stepID, done) {
+					continue
+				}
+
+				started[stepID] = true
+				running++
+				go func(id string) {
+					results <- stepResult{stepID: id, err: r.runStep(ctx, id)}
+				}(stepID)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		if result.err != nil && ctx.Err() != nil {
+			interrupted = append(interrupted, result.stepID)
+			continue
+		}
+		if result.err != nil {
+			failed = append(failed, result.stepID)
+			unavailable[result.stepID] = true
+			if step := r.findStep(result.stepID); step != nil && step.OnFailure == "continue" {
+				continue
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			continue
+		}
+		done[result.stepID] = true
+		finished++
+	}
+
+	if err := ctx.Err(); err != nil && (len(interrupted) > 0 || finished+len(failed)+len(blocked) < len(order)) {
+		return &RunError{
+			Failed:      failed,
+			Blocked:     blocked,
+			Interrupted: interrupted,
+			Err:         fmt.Errorf("execution interrupted: %w", err),
+		}
+	}
+	if firstErr != nil {
+		r.rollback(ctx, failed, true)
+		return &RunError{Failed: failed, Blocked: blocked, Err: firstErr}
+	}
+	if len(failed) > 0 {
+		r.rollback(ctx, failed, false)
+		return &RunError{Failed: failed, Blocked: blocked}
+	}
+	return nil
+}
+
+// failedDependency returns a dependency of the step that failed or was blocked
+func (r *Runner) failedDependency(stepID string, unavailable map[string]bool) string {
+	step := r.findStep(stepID)
+	if step == nil {
+		return ""
+	}
+	for _, dep := range step.DependsOn {
+		if unavailable[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+// dependenciesDone reports whether all dependencies of a step have finished
+func (r *Runner) dependenciesDone(stepID string, done map[string]bool) bool {
+	step := r.findStep(stepID)
+	if step == nil {
+		return true
+	}
+	for _, dep := range step.DependsOn {
+		if !done[dep] {
+			return false
+		}
+	}
+	return true
+}
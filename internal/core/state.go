@@ -2,63 +2,284 @@ package core
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
+
+	"github.com/SphereStacking/plexr/internal/utils"
 )
 
 // ExecutionState represents the current state of an execution
 type ExecutionState struct {
-	SetupName      string            `json:"setup_name"`
-	SetupVersion   string            `json:"setup_version"`
-	Platform       string            `json:"platform"`
-	StartedAt      time.Time         `json:"started_at"`
-	UpdatedAt      time.Time         `json:"updated_at"`
-	CompletedSteps []string          `json:"completed_steps"`
-	CurrentStep    string            `json:"current_step"`
-	FailedFiles    []string          `json:"failed_files"`
-	InstalledTools map[string]string `json:"installed_tools"`
+	// SchemaVersion is the version of the state format (see StateSchemaVersion)
+	SchemaVersion int       `json:"schema_version"`
+	SetupName     string    `json:"setup_name"`
+	SetupVersion  string    `json:"setup_version"`
+	Platform      string    `json:"platform"`
+	StartedAt     time.Time `json:"started_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// PlanSteps lists the steps of the plan version the state was last run with
+	PlanSteps      []string `json:"plan_steps,omitempty"`
+	CompletedSteps []string `json:"completed_steps"`
+	// CurrentStep is the step that started executing last. Steps may run
+	// concurrently; RunningSteps returns all of them.
+	CurrentStep string `json:"current_step"`
+	// FailedFiles lists the files whose last execution failed, as "<step>:<file>"
+	FailedFiles []string `json:"failed_files"`
+	FailedSteps []string `json:"failed_steps,omitempty"`
+	// InterruptedSteps lists the steps that were running when the last run was interrupted
+	InterruptedSteps []string          `json:"interrupted_steps,omitempty"`
+	InterruptedAt    *time.Time        `json:"interrupted_at,omitempty"`
+	InstalledTools   map[string]string `json:"installed_tools"`
+	// FileAttempts records failed execution attempts, keyed by "<step>:<file>"
+	FileAttempts map[string][]AttemptRecord `json:"file_attempts,omitempty"`
+	// Rollbacks records the rollback files run after failures
+	Rollbacks []RollbackRecord `json:"rollbacks,omitempty"`
+	// StepOutputs holds the key/value outputs emitted by each step
+	StepOutputs map[string]map[string]string `json:"step_outputs,omitempty"`
+	// Steps records the last execution of each step. State files written by
+	// older versions have no records; CompletedSteps stays authoritative.
+	Steps map[string]*StepRecord `json:"steps,omitempty"`
+}
+
+// RunningSteps returns the steps that are executing, in sorted order. State
+// files written by older versions have no step records; their current step is
+// reported unless it completed.
+func (s *ExecutionState) RunningSteps() []string {
+	var running []string
+	if len(s.Steps) == 0 {
+		if s.CurrentStep != "" && !containsString(s.CompletedSteps, s.CurrentStep) {
+			running = append(running, s.CurrentStep)
+		}
+		return running
+	}
+	for stepID, record := range s.Steps {
+		if record.Status == StatusRunning {
+			running = append(running, stepID)
+		}
+	}
+	sort.Strings(running)
+	return running
+}
+
+// Statuses of step and file records
+const (
+	StatusRunning     = "running"
+	StatusCompleted   = "completed"
+	StatusFailed      = "failed"
+	StatusSkipped     = "skipped"
+	StatusInterrupted = "interrupted"
+	StatusRolledBack  = "rolled_back"
+)
+
+// maxOutputTail is the number of bytes of a file's output kept in its record
+const maxOutputTail = 4096
+
+// StepRecord describes the last execution of a step
+type StepRecord struct {
+	Status     string       `json:"status"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Duration   int64        `json:"duration_ms"`      // in milliseconds
+	Attempts   int          `json:"attempts"`         // Number of times the step has been executed
+	Reason     string       `json:"reason,omitempty"` // Why the step was skipped
+	Error      string       `json:"error,omitempty"`  // Failure of the step, also kept for ignored failures
+	Files      []FileRecord `json:"files,omitempty"`  // Files executed or skipped
+	// Rollback records the rollback files executed or skipped after the step failed
+	Rollback []FileRecord `json:"rollback,omitempty"`
+	// Fingerprint hashes the files, executor configuration and environment
+	// the step was executed with, to detect changes
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// FileRecord describes the execution of a file during the last execution of its step
+type FileRecord struct {
+	Path       string     `json:"path"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Duration   int64      `json:"duration_ms"` // in milliseconds
+	Attempts   int        `json:"attempts,omitempty"`
+	ExitCode   int        `json:"exit_code,omitempty"`
+	Output     string     `json:"output_tail,omitempty"` // Last bytes of the output
+	Reason     string     `json:"reason,omitempty"`      // Why the file was skipped
+	Error      string     `json:"error,omitempty"`
+}
+
+// outputTail returns the end of output, at most maxOutputTail bytes long
+func outputTail(output string) string {
+	if len(output) <= maxOutputTail {
+		return output
+	}
+	tail := output[len(output)-maxOutputTail:]
+	// Do not start in the middle of a multi-byte character
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	return tail
+}
+
+// RollbackRecord describes the outcome of rolling back a step
+type RollbackRecord struct {
+	StepID  string    `json:"step_id"`
+	Reason  string    `json:"reason"` // failed (the step itself failed) or cascade (completed earlier in the run)
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// AttemptRecord describes a single failed attempt to execute a file
+type AttemptRecord struct {
+	Attempt  int       `json:"attempt"`
+	Error    string    `json:"error"`
+	ExitCode int       `json:"exit_code,omitempty"`
+	SQLState string    `json:"sql_state,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// attemptKey builds the FileAttempts key for a file of a step
+func attemptKey(stepID, path string) string {
+	return stepID + ":" + path
 }
 
 // StateManager manages the execution state
 type StateManager struct {
-	filePath string
-	state    *ExecutionState
-	mu       sync.RWMutex
+	store StateStore
+	state *ExecutionState
+	mu    sync.RWMutex
 }
 
-// NewStateManager creates a new state manager
+// CorruptStateError reports a stored state that cannot be parsed
+type CorruptStateError struct {
+	Path   string // Location of the state
+	Backup string // Location of a readable backup of the previous state, empty if there is none
+	Err    error
+}
+
+func (e *CorruptStateError) Error() string {
+	message := fmt.Sprintf("state %s is corrupted: %v", e.Path, e.Err)
+	if e.Backup != "" {
+		return message + fmt.Sprintf(" (a backup of the previous state is available at %s)", e.Backup)
+	}
+	return message + " (no backup is available)"
+}
+
+func (e *CorruptStateError) Unwrap() error {
+	return e.Err
+}
+
+// BackupPath returns the path of the backup of a state file
+func BackupPath(stateFile string) string {
+	return stateFile + ".bak"
+}
+
+// NewStateManager creates a new state manager for a state file
 func NewStateManager(filePath string) (*StateManager, error) {
-	if filePath == "" {
-		return nil, fmt.Errorf("state file path cannot be empty")
+	store, err := NewFileStateStore(filePath)
+	if err != nil {
+		return nil, err
 	}
+	return NewStateManagerWithStore(store), nil
+}
+
+// NewStateManagerWithStore creates a new state manager for a state store
+func NewStateManagerWithStore(store StateStore) *StateManager {
+	return &StateManager{store: store}
+}
 
-	return &StateManager{
-		filePath: filePath,
-	}, nil
+// Close closes the state store
+func (sm *StateManager) Close() error {
+	return sm.store.Close()
 }
 
-// Load loads the state from file
+// Load loads the state from the store. A stored state that cannot be parsed
+// is reported as a *CorruptStateError rather than being treated as missing.
 func (sm *StateManager) Load() (*ExecutionState, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	data, err := os.ReadFile(sm.filePath)
+	data, err := sm.store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := parseState(data)
+	var versionErr *StateVersionError
+	if errors.As(err, &versionErr) {
+		versionErr.Path = sm.store.Location()
+		return nil, versionErr
+	}
+	if err != nil {
+		corrupt := &CorruptStateError{Path: sm.store.Location(), Err: err}
+		if _, backupErr := sm.loadBackup(); backupErr == nil {
+			corrupt.Backup = sm.store.BackupLocation()
+		}
+		return nil, corrupt
+	}
+
+	sm.state = state
+	return state, nil
+}
+
+// parseState parses a serialized state, migrating it from older formats
+func parseState(data []byte) (*ExecutionState, error) {
+	data, err := migrateState(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read state file: %w", err)
+		return nil, err
 	}
 
 	var state ExecutionState
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, fmt.Errorf("failed to parse state file: %w", err)
 	}
-
-	sm.state = &state
 	return &state, nil
 }
 
-// Save saves the state to file
+// Backup returns the backup of the state without restoring it. A missing
+// backup is reported as an error matching fs.ErrNotExist.
+func (sm *StateManager) Backup() (*ExecutionState, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return sm.loadBackup()
+}
+
+// loadBackup reads and parses the backup of the state
+func (sm *StateManager) loadBackup() (*ExecutionState, error) {
+	data, err := sm.store.LoadBackup()
+	if err != nil {
+		return nil, err
+	}
+	state, err := parseState(data)
+	if err != nil {
+		return nil, fmt.Errorf("state backup is corrupted: %w", err)
+	}
+	return state, nil
+}
+
+// RestoreBackup replaces the state with its backup
+func (sm *StateManager) RestoreBackup() (*ExecutionState, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	state, err := sm.loadBackup()
+	if err != nil {
+		return nil, err
+	}
+	if err := sm.store.RestoreBackup(); err != nil {
+		return nil, err
+	}
+	sm.state = state
+	return state, nil
+}
+
+// Save saves the state to the store
 func (sm *StateManager) Save(state *ExecutionState) error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
@@ -66,31 +287,168 @@ func (sm *StateManager) Save(state *ExecutionState) error {
 	state.UpdatedAt = time.Now()
 	sm.state = state
 
-	data, err := json.MarshalIndent(state, "", "  ")
+	return sm.write()
+}
+
+// write persists the state with secret values masked. The store keeps the
+// previous state as its backup. The caller must hold the lock.
+func (sm *StateManager) write() error {
+	sm.state.SchemaVersion = StateSchemaVersion
+	data, err := json.MarshalIndent(sm.state, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
+	return sm.store.Save(utils.MaskSecretsJSON(data))
+}
+
+// writeFileAtomic replaces a file so that it holds either its old or its new
+// contents, even if the process crashes: the data is written to a temporary
+// file in the same directory, flushed to disk and renamed over the file.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // #nosec G104 - the file is gone after a successful rename
 
-	if err := os.WriteFile(sm.filePath, data, 0600); err != nil { // #nosec G306 - State file needs to be readable by other processes
-		return fmt.Errorf("failed to write state file: %w", err)
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
 	}
 
+	// Persist the rename; directories cannot be synced on every platform
+	if d, err := os.Open(dir); err == nil { // #nosec G304 - the directory of the state file
+		_ = d.Sync()
+		_ = d.Close()
+	}
 	return nil
 }
 
-// Reset removes the state file
+// Reset removes the state. The store keeps it as the backup.
 func (sm *StateManager) Reset() error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	if err := os.Remove(sm.filePath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove state file: %w", err)
+	if err := sm.store.Reset(); err != nil {
+		return err
 	}
 
 	sm.state = nil
 	return nil
 }
 
+// ResetSteps forgets that steps have run, so that the next run executes
+// them again: they are no longer completed and lose their records, outputs,
+// failures and attempts. Steps without state are ignored.
+func (sm *StateManager) ResetSteps(stepIDs []string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	reset := make(map[string]bool, len(stepIDs))
+	for _, stepID := range stepIDs {
+		reset[stepID] = true
+	}
+	keep := func(stepID string) bool { return !reset[stepID] }
+
+	for _, stepID := range stepIDs {
+		prefix := attemptKey(stepID, "")
+		for key := range sm.state.FileAttempts {
+			if strings.HasPrefix(key, prefix) {
+				delete(sm.state.FileAttempts, key)
+			}
+		}
+		sm.state.FailedFiles = filterStrings(sm.state.FailedFiles, func(key string) bool {
+			return !strings.HasPrefix(key, prefix)
+		})
+		delete(sm.state.Steps, stepID)
+		delete(sm.state.StepOutputs, stepID)
+	}
+
+	sm.state.CompletedSteps = filterStrings(sm.state.CompletedSteps, keep)
+	sm.state.FailedSteps = filterStrings(sm.state.FailedSteps, keep)
+	sm.state.InterruptedSteps = filterStrings(sm.state.InterruptedSteps, keep)
+	if len(sm.state.InterruptedSteps) == 0 {
+		sm.state.InterruptedAt = nil
+	}
+	if reset[sm.state.CurrentStep] {
+		sm.state.CurrentStep = ""
+	}
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// ClearFailures forgets the failures and interruptions of earlier runs while
+// keeping completed steps, and returns the steps whose failures were cleared
+func (sm *StateManager) ClearFailures() ([]string, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return nil, fmt.Errorf("state not loaded")
+	}
+
+	cleared := make(map[string]bool)
+	for _, stepID := range append(append([]string{}, sm.state.FailedSteps...), sm.state.InterruptedSteps...) {
+		cleared[stepID] = true
+	}
+	for stepID, record := range sm.state.Steps {
+		if record.Status == StatusFailed || record.Status == StatusInterrupted {
+			cleared[stepID] = true
+			delete(sm.state.Steps, stepID)
+		}
+	}
+
+	steps := make([]string, 0, len(cleared))
+	for stepID := range cleared {
+		steps = append(steps, stepID)
+	}
+	sort.Strings(steps)
+	if len(steps) == 0 && len(sm.state.FailedFiles) == 0 && len(sm.state.FileAttempts) == 0 {
+		return steps, nil
+	}
+
+	sm.state.FailedSteps = nil
+	sm.state.FailedFiles = nil
+	sm.state.FileAttempts = nil
+	sm.state.InterruptedSteps = nil
+	sm.state.InterruptedAt = nil
+	if cleared[sm.state.CurrentStep] {
+		sm.state.CurrentStep = ""
+	}
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return steps, sm.write()
+}
+
+// filterStrings returns the items of list for which keep returns true
+func filterStrings(list []string, keep func(string) bool) []string {
+	var kept []string
+	for _, item := range list {
+		if keep(item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
 // IsStepCompleted checks if a step has been completed
 func (sm *StateManager) IsStepCompleted(stepID string) bool {
 	sm.mu.RLock()
@@ -128,16 +486,7 @@ func (sm *StateManager) MarkStepCompleted(stepID string) error {
 	sm.state.UpdatedAt = time.Now()
 
 	// Save immediately
-	data, err := json.MarshalIndent(sm.state, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal state: %w", err)
-	}
-
-	if err := os.WriteFile(sm.filePath, data, 0600); err != nil { // #nosec G306 - State file needs to be readable by other processes
-		return fmt.Errorf("failed to write state file: %w", err)
-	}
-
-	return nil
+	return sm.write()
 }
 
 // SetCurrentStep sets the current step being executed
@@ -153,16 +502,7 @@ func (sm *StateManager) SetCurrentStep(stepID string) error {
 	sm.state.UpdatedAt = time.Now()
 
 	// Save immediately
-	data, err := json.MarshalIndent(sm.state, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal state: %w", err)
-	}
-
-	if err := os.WriteFile(sm.filePath, data, 0600); err != nil { // #nosec G306 - State file needs to be readable by other processes
-		return fmt.Errorf("failed to write state file: %w", err)
-	}
-
-	return nil
+	return sm.write()
 }
 
 // AddInstalledTool records an installed tool and its version
@@ -182,14 +522,445 @@ func (sm *StateManager) AddInstalledTool(name, version string) error {
 	sm.state.UpdatedAt = time.Now()
 
 	// Save immediately
-	data, err := json.MarshalIndent(sm.state, "", "  ")
+	return sm.write()
+}
+
+// SetFileAttempts replaces the recorded failed attempts of a file.
+// Passing no records clears the history of the file.
+func (sm *StateManager) SetFileAttempts(stepID, path string, attempts []AttemptRecord) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	key := attemptKey(stepID, path)
+	if len(attempts) == 0 {
+		if _, exists := sm.state.FileAttempts[key]; !exists {
+			return nil
+		}
+		delete(sm.state.FileAttempts, key)
+	} else {
+		if sm.state.FileAttempts == nil {
+			sm.state.FileAttempts = make(map[string][]AttemptRecord)
+		}
+		sm.state.FileAttempts[key] = append([]AttemptRecord(nil), attempts...)
+	}
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// SetFileFailed adds a file of a step to or removes it from the failed files list
+func (sm *StateManager) SetFileFailed(stepID, path string, failed bool) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	key := attemptKey(stepID, path)
+	index := -1
+	for i, file := range sm.state.FailedFiles {
+		if file == key {
+			index = i
+			break
+		}
+	}
+
+	switch {
+	case failed && index < 0:
+		sm.state.FailedFiles = append(sm.state.FailedFiles, key)
+	case !failed && index >= 0:
+		sm.state.FailedFiles = append(sm.state.FailedFiles[:index], sm.state.FailedFiles[index+1:]...)
+	default:
+		return nil
+	}
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// Snapshot returns a copy of the loaded state as it is written to the store,
+// with secret values masked
+func (sm *StateManager) Snapshot() (*ExecutionState, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if sm.state == nil {
+		return nil, fmt.Errorf("state not loaded")
+	}
+	data, err := json.Marshal(sm.state)
 	if err != nil {
-		return fmt.Errorf("failed to marshal state: %w", err)
+		return nil, fmt.Errorf("failed to marshal state: %w", err)
+	}
+	var state ExecutionState
+	if err := json.Unmarshal(utils.MaskSecretsJSON(data), &state); err != nil {
+		return nil, fmt.Errorf("failed to copy state: %w", err)
+	}
+	return &state, nil
+}
+
+// StepOutputs returns a copy of the outputs recorded for all steps
+func (sm *StateManager) StepOutputs() map[string]map[string]string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	outputs := make(map[string]map[string]string)
+	if sm.state == nil {
+		return outputs
 	}
+	for stepID, values := range sm.state.StepOutputs {
+		copied := make(map[string]string, len(values))
+		for key, value := range values {
+			copied[key] = value
+		}
+		outputs[stepID] = copied
+	}
+	return outputs
+}
+
+// AddStepOutputs records outputs of a step, replacing values of existing keys
+func (sm *StateManager) AddStepOutputs(stepID string, outputs map[string]string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
-	if err := os.WriteFile(sm.filePath, data, 0600); err != nil { // #nosec G306 - State file needs to be readable by other processes
-		return fmt.Errorf("failed to write state file: %w", err)
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
 	}
 
-	return nil
+	if len(outputs) == 0 {
+		return nil
+	}
+	if sm.state.StepOutputs == nil {
+		sm.state.StepOutputs = make(map[string]map[string]string)
+	}
+	if sm.state.StepOutputs[stepID] == nil {
+		sm.state.StepOutputs[stepID] = make(map[string]string)
+	}
+	for key, value := range outputs {
+		sm.state.StepOutputs[stepID][key] = value
+	}
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// ClearStepOutputs forgets the outputs of a step before it runs again
+func (sm *StateManager) ClearStepOutputs(stepID string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	if _, exists := sm.state.StepOutputs[stepID]; !exists {
+		return nil
+	}
+	delete(sm.state.StepOutputs, stepID)
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// RecordRollback records the outcome of a rollback. A successfully rolled
+// back step is no longer considered completed.
+func (sm *StateManager) RecordRollback(record RollbackRecord) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	sm.state.Rollbacks = append(sm.state.Rollbacks, record)
+	if record.Success {
+		if step := sm.state.Steps[record.StepID]; step != nil {
+			step.Status = StatusRolledBack
+		}
+		for i, completed := range sm.state.CompletedSteps {
+			if completed == record.StepID {
+				sm.state.CompletedSteps = append(sm.state.CompletedSteps[:i], sm.state.CompletedSteps[i+1:]...)
+				break
+			}
+		}
+		delete(sm.state.StepOutputs, record.StepID)
+	}
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// SetStepFailed adds a step to or removes it from the failed steps list
+func (sm *StateManager) SetStepFailed(stepID string, failed bool) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	index := -1
+	for i, id := range sm.state.FailedSteps {
+		if id == stepID {
+			index = i
+			break
+		}
+	}
+
+	switch {
+	case failed && index < 0:
+		sm.state.FailedSteps = append(sm.state.FailedSteps, stepID)
+	case !failed && index >= 0:
+		sm.state.FailedSteps = append(sm.state.FailedSteps[:index], sm.state.FailedSteps[index+1:]...)
+	default:
+		return nil
+	}
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// MarkStepInterrupted records that a step was stopped by a cancellation
+func (sm *StateManager) MarkStepInterrupted(stepID string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	now := time.Now()
+	sm.state.InterruptedSteps = append(sm.state.InterruptedSteps, stepID)
+	sm.state.InterruptedAt = &now
+	if sm.state.CurrentStep == stepID {
+		sm.state.CurrentStep = ""
+	}
+	sm.state.UpdatedAt = now
+
+	// Save immediately
+	return sm.write()
+}
+
+// ClearInterrupted forgets a previous interruption
+func (sm *StateManager) ClearInterrupted() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	if len(sm.state.InterruptedSteps) == 0 && sm.state.InterruptedAt == nil {
+		return nil
+	}
+	sm.state.InterruptedSteps = nil
+	sm.state.InterruptedAt = nil
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// StartStep records that a step starts executing, replacing the records of
+// its previous execution
+func (sm *StateManager) StartStep(stepID string, start time.Time) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	attempts := 1
+	if previous := sm.state.Steps[stepID]; previous != nil {
+		attempts = previous.Attempts + 1
+	}
+	if sm.state.Steps == nil {
+		sm.state.Steps = make(map[string]*StepRecord)
+	}
+	sm.state.Steps[stepID] = &StepRecord{
+		Status:    StatusRunning,
+		StartedAt: start,
+		Attempts:  attempts,
+	}
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// SetStepFingerprint records the fingerprint of a step that started executing
+func (sm *StateManager) SetStepFingerprint(stepID, fingerprint string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	record := sm.state.Steps[stepID]
+	if record == nil {
+		return fmt.Errorf("step %s has not started", stepID)
+	}
+	record.Fingerprint = fingerprint
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// StepFingerprint returns the fingerprint recorded for the last execution of
+// a step, if any
+func (sm *StateManager) StepFingerprint(stepID string) string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if sm.state == nil || sm.state.Steps[stepID] == nil {
+		return ""
+	}
+	return sm.state.Steps[stepID].Fingerprint
+}
+
+// SetPlan records the name, version and steps of the plan a run uses
+func (sm *StateManager) SetPlan(name, version string, steps []string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	if sm.state.SetupName == name && sm.state.SetupVersion == version && equalStrings(sm.state.PlanSteps, steps) {
+		return nil
+	}
+	sm.state.SetupName = name
+	sm.state.SetupVersion = version
+	sm.state.PlanSteps = append([]string{}, steps...)
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// equalStrings reports whether two lists hold the same strings in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MarkStepIncomplete removes a step from the completed steps, so that it is
+// not considered completed while it runs again
+func (sm *StateManager) MarkStepIncomplete(stepID string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	sm.state.CompletedSteps = filterStrings(sm.state.CompletedSteps, func(id string) bool { return id != stepID })
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
+}
+
+// FinishStep records the outcome of a step. reason explains skipped steps
+// and message describes failures.
+func (sm *StateManager) FinishStep(stepID, status, reason, message string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	now := time.Now()
+	if sm.state.Steps == nil {
+		sm.state.Steps = make(map[string]*StepRecord)
+	}
+	record := sm.state.Steps[stepID]
+	if record == nil || record.Status != StatusRunning {
+		// The step finished before it started executing, e.g. it was skipped.
+		// The fingerprint of its last execution is kept.
+		previous := StepRecord{}
+		if record != nil {
+			previous = *record
+		}
+		record = &StepRecord{StartedAt: now, Attempts: previous.Attempts, Fingerprint: previous.Fingerprint}
+		sm.state.Steps[stepID] = record
+	}
+
+	record.Status = status
+	record.FinishedAt = &now
+	record.Duration = now.Sub(record.StartedAt).Milliseconds()
+	record.Reason = reason
+	record.Error = message
+	sm.state.UpdatedAt = now
+
+	// Save immediately
+	return sm.write()
+}
+
+// RecordFile records the execution of a file of a step, replacing an earlier
+// record of the same file
+func (sm *StateManager) RecordFile(stepID string, file FileRecord) error {
+	return sm.recordFile(stepID, file, false)
+}
+
+// RecordRollbackFile records the execution of a rollback file of a step,
+// replacing an earlier record of the same file
+func (sm *StateManager) RecordRollbackFile(stepID string, file FileRecord) error {
+	return sm.recordFile(stepID, file, true)
+}
+
+// recordFile records a file in the files or the rollback files of a step
+func (sm *StateManager) recordFile(stepID string, file FileRecord, rollback bool) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.state == nil {
+		return fmt.Errorf("state not loaded")
+	}
+
+	record := sm.state.Steps[stepID]
+	if record == nil {
+		return fmt.Errorf("step %s has not started", stepID)
+	}
+
+	files := &record.Files
+	if rollback {
+		files = &record.Rollback
+	}
+
+	file.Output = outputTail(file.Output)
+	for i := range *files {
+		if (*files)[i].Path == file.Path {
+			(*files)[i] = file
+			sm.state.UpdatedAt = time.Now()
+			return sm.write()
+		}
+	}
+	*files = append(*files, file)
+	sm.state.UpdatedAt = time.Now()
+
+	// Save immediately
+	return sm.write()
 }
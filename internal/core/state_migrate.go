@@ -0,0 +1,188 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StateSchemaVersion is the version of the state format written by this
+// version of plexr. States written before the format was versioned have no
+// schema_version and are version 0.
+const StateSchemaVersion = 1
+
+// stateMigrations upgrade a serialized state by one schema version each:
+// stateMigrations[i] turns a state of version i into a state of version i+1
+var stateMigrations = []func(state map[string]json.RawMessage) error{
+	migrateStateV0,
+}
+
+// StateVersionError reports a state written by a newer version of plexr,
+// whose format this version does not know
+type StateVersionError struct {
+	Path    string // Location of the state
+	Version int    // Schema version of the state
+}
+
+func (e *StateVersionError) Error() string {
+	return fmt.Sprintf("state %s has schema version %d, but this version of plexr supports up to version %d; upgrade plexr to use it",
+		e.Path, e.Version, StateSchemaVersion)
+}
+
+// migrateState upgrades a serialized state to StateSchemaVersion. States
+// of the current version are returned unchanged.
+func migrateState(data []byte) ([]byte, error) {
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if header.SchemaVersion == StateSchemaVersion {
+		return data, nil
+	}
+	if header.SchemaVersion > StateSchemaVersion || header.SchemaVersion < 0 {
+		return nil, &StateVersionError{Version: header.SchemaVersion}
+	}
+
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if state == nil {
+		return nil, fmt.Errorf("failed to parse state file: not a JSON object")
+	}
+	for version := header.SchemaVersion; version < StateSchemaVersion; version++ {
+		if err := stateMigrations[version](state); err != nil {
+			return nil, fmt.Errorf("failed to migrate state from schema version %d: %w", version, err)
+		}
+		state["schema_version"], _ = json.Marshal(version + 1)
+	}
+	return json.Marshal(state)
+}
+
+// migrateStateV0 records the steps of the plan the state was run with and
+// keys failed files by their step
+func migrateStateV0(state map[string]json.RawMessage) error {
+	if err := migratePlanSteps(state); err != nil {
+		return err
+	}
+	return migrateFailedFiles(state)
+}
+
+// migratePlanSteps records the steps of the plan the state was run with.
+// Unversioned states do not know them, so the steps the state has any
+// record of stand in for them.
+func migratePlanSteps(state map[string]json.RawMessage) error {
+	if _, ok := state["plan_steps"]; ok {
+		return nil
+	}
+
+	var known struct {
+		CompletedSteps   []string                   `json:"completed_steps"`
+		FailedSteps      []string                   `json:"failed_steps"`
+		InterruptedSteps []string                   `json:"interrupted_steps"`
+		StepOutputs      map[string]json.RawMessage `json:"step_outputs"`
+		Steps            map[string]json.RawMessage `json:"steps"`
+		Rollbacks        []RollbackRecord           `json:"rollbacks"`
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &known); err != nil {
+		return err
+	}
+
+	steps := make(map[string]bool)
+	for _, list := range [][]string{known.CompletedSteps, known.FailedSteps, known.InterruptedSteps} {
+		for _, id := range list {
+			steps[id] = true
+		}
+	}
+	for id := range known.StepOutputs {
+		steps[id] = true
+	}
+	for id := range known.Steps {
+		steps[id] = true
+	}
+	for _, rollback := range known.Rollbacks {
+		steps[rollback.StepID] = true
+	}
+
+	planSteps := make([]string, 0, len(steps))
+	for id := range steps {
+		planSteps = append(planSteps, id)
+	}
+	sort.Strings(planSteps)
+	state["plan_steps"], err = json.Marshal(planSteps)
+	return err
+}
+
+// migrateFailedFiles keys failed files by their step, as "<step>:<file>". A
+// file belongs to the step whose record shows it failed, or else to the
+// current step, as runs stopped at the first failure. Files keyed already
+// and files of unknown steps are kept as they are.
+func migrateFailedFiles(state map[string]json.RawMessage) error {
+	var known struct {
+		CompletedSteps []string `json:"completed_steps"`
+		CurrentStep    string   `json:"current_step"`
+		FailedFiles    []string `json:"failed_files"`
+		FailedSteps    []string `json:"failed_steps"`
+		Steps          map[string]struct {
+			Files []FileRecord `json:"files"`
+		} `json:"steps"`
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &known); err != nil {
+		return err
+	}
+	if len(known.FailedFiles) == 0 {
+		return nil
+	}
+
+	stepIDs := append(append([]string{known.CurrentStep}, known.CompletedSteps...), known.FailedSteps...)
+	for stepID := range known.Steps {
+		stepIDs = append(stepIDs, stepID)
+	}
+	sort.Strings(stepIDs)
+
+	failedIn := make(map[string]string)
+	for _, stepID := range stepIDs {
+		for _, file := range known.Steps[stepID].Files {
+			if _, ok := failedIn[file.Path]; !ok && file.Status == StatusFailed {
+				failedIn[file.Path] = stepID
+			}
+		}
+	}
+
+	failedFiles := make([]string, 0, len(known.FailedFiles))
+	for _, path := range known.FailedFiles {
+		if !isAttemptKey(path, stepIDs) {
+			stepID, ok := failedIn[path]
+			if !ok {
+				stepID = known.CurrentStep
+			}
+			if stepID != "" {
+				path = attemptKey(stepID, path)
+			}
+		}
+		failedFiles = append(failedFiles, path)
+	}
+	state["failed_files"], err = json.Marshal(failedFiles)
+	return err
+}
+
+// isAttemptKey reports whether key is keyed by one of stepIDs
+func isAttemptKey(key string, stepIDs []string) bool {
+	for _, stepID := range stepIDs {
+		if stepID != "" && strings.HasPrefix(key, attemptKey(stepID, "")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateMigrations(t *testing.T) {
+	assert.Len(t, stateMigrations, StateSchemaVersion, "every schema version needs a migration")
+
+	t.Run("Unversioned state", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		require.NoError(t, os.WriteFile(stateFile, []byte(`{
+  "setup_name": "Dev Setup",
+  "setup_version": "1.0.0",
+  "completed_steps": ["install", "configure"],
+  "failed_steps": ["migrate"],
+  "step_outputs": {"database": {"url": "postgres://localhost/app"}},
+  "rollbacks": [{"step_id": "seed", "reason": "failed", "success": true, "time": "2025-01-01T10:00:00Z"}]
+}`), 0600))
+
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		state, err := sm.Load()
+		require.NoError(t, err)
+		assert.Equal(t, StateSchemaVersion, state.SchemaVersion)
+		assert.Equal(t, []string{"configure", "database", "install", "migrate", "seed"}, state.PlanSteps)
+		assert.Equal(t, []string{"install", "configure"}, state.CompletedSteps)
+		assert.Equal(t, "postgres://localhost/app", state.StepOutputs["database"]["url"])
+
+		// The migrated state is written with the current version
+		require.NoError(t, sm.Save(state))
+		data, err := os.ReadFile(stateFile)
+		require.NoError(t, err)
+		var saved map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &saved))
+		assert.Equal(t, float64(StateSchemaVersion), saved["schema_version"])
+	})
+
+	t.Run("Failed files are keyed by step", func(t *testing.T) {
+		migrated, err := migrateState([]byte(`{
+  "current_step": "deploy",
+  "failed_files": ["build.sh", "deploy.sh", "test:test.sh"],
+  "failed_steps": ["test"],
+  "steps": {
+    "build": {"status": "failed", "files": [{"path": "build.sh", "status": "failed"}]},
+    "lint": {"status": "completed", "files": [{"path": "deploy.sh", "status": "completed"}]}
+  }
+}`))
+		require.NoError(t, err)
+
+		var state ExecutionState
+		require.NoError(t, json.Unmarshal(migrated, &state))
+		assert.Equal(t, []string{"build:build.sh", "deploy:deploy.sh", "test:test.sh"}, state.FailedFiles)
+	})
+
+	t.Run("Current state is unchanged", func(t *testing.T) {
+		data := []byte(`{"schema_version": 1, "setup_name": "Dev Setup", "completed_steps": ["install"]}`)
+		migrated, err := migrateState(data)
+		require.NoError(t, err)
+		assert.Equal(t, data, migrated)
+	})
+
+	t.Run("State of a newer version", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		require.NoError(t, os.WriteFile(stateFile, []byte(`{"schema_version": 99, "setup_name": "Dev Setup"}`), 0600))
+
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		_, err = sm.Load()
+		var versionErr *StateVersionError
+		require.ErrorAs(t, err, &versionErr)
+		assert.Equal(t, 99, versionErr.Version)
+		assert.Equal(t, stateFile, versionErr.Path)
+		assert.Contains(t, err.Error(), "upgrade plexr")
+
+		var corrupt *CorruptStateError
+		assert.False(t, errors.As(err, &corrupt))
+	})
+
+	t.Run("Not an object", func(t *testing.T) {
+		_, err := migrateState([]byte(`null`))
+		assert.ErrorContains(t, err, "not a JSON object")
+
+		_, err = migrateState([]byte(`["install"]`))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// defaultStateTable is the table of the postgres state backend
+	defaultStateTable = "plexr_state"
+	// stateQueryTimeout bounds every query of the postgres state backend
+	stateQueryTimeout = 10 * time.Second
+)
+
+// PostgresStateStore stores the state in a row of a PostgreSQL table, keyed
+// by plan. The backup is kept in a column of the same row, so that a state
+// and its backup are replaced in a single statement.
+type PostgresStateStore struct {
+	db    *sql.DB
+	table string // Table name, as given
+	ident string // Quoted table name for queries
+	key   string
+	saved bool // Whether the state has been saved, so that the backup is taken once
+}
+
+// NewPostgresStateStore creates a store for the state stored under key in a
+// table, which is created if it does not exist. The store owns db and closes
+// it when it is closed.
+func NewPostgresStateStore(db *sql.DB, table, key string) (*PostgresStateStore, error) {
+	if key == "" {
+		return nil, fmt.Errorf("state key cannot be empty")
+	}
+	if table == "" {
+		table = defaultStateTable
+	}
+
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+	s := &PostgresStateStore{db: db, table: table, ident: strings.Join(parts, "."), key: key}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stateQueryTimeout)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	state_key TEXT PRIMARY KEY,
+	state TEXT,
+	backup TEXT,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`, s.ident)); err != nil {
+		return nil, fmt.Errorf("failed to create state table %s: %w", table, err)
+	}
+	return s, nil
+}
+
+// Load reads the state of the plan
+func (s *PostgresStateStore) Load() ([]byte, error) {
+	return s.load("state")
+}
+
+// LoadBackup reads the backup of the state of the plan
+func (s *PostgresStateStore) LoadBackup() ([]byte, error) {
+	return s.load("backup")
+}
+
+// load reads a column of the row of the plan. A missing row and a NULL value
+// both mean that nothing is stored.
+func (s *PostgresStateStore) load(column string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), stateQueryTimeout)
+	defer cancel()
+
+	var value sql.NullString
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE state_key = $1", column, s.ident), s.key).Scan(&value)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to read %s from %s: %w", column, s.Location(), err)
+	}
+	if !value.Valid {
+		return nil, fmt.Errorf("no %s stored in %s: %w", column, s.Location(), fs.ErrNotExist)
+	}
+	return []byte(value.String), nil
+}
+
+// Save replaces the state of the plan. On the first save, the previous
+// state becomes the backup; after a reset, the backup taken by the reset is
+// kept. Later saves of the same run leave the backup alone.
+func (s *PostgresStateStore) Save(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stateQueryTimeout)
+	defer cancel()
+
+	update := "state = EXCLUDED.state"
+	if !s.saved {
+		update = "backup = COALESCE(s.state, s.backup), " + update
+	}
+	query := fmt.Sprintf(`INSERT INTO %[1]s AS s (state_key, state, updated_at) VALUES ($1, $2, now())
+ON CONFLICT (state_key) DO UPDATE SET %[2]s, updated_at = now()`, s.ident, update)
+	if _, err := s.db.ExecContext(ctx, query, s.key, string(data)); err != nil {
+		return fmt.Errorf("failed to write state to %s: %w", s.Location(), err)
+	}
+	s.saved = true
+	return nil
+}
+
+// RestoreBackup replaces the state of the plan with its backup
+func (s *PostgresStateStore) RestoreBackup() error {
+	return s.update("UPDATE %s SET state = backup, updated_at = now() WHERE state_key = $1 AND backup IS NOT NULL", "restore", "backup")
+}
+
+// Reset removes the state of the plan, keeping it as the backup. A
+// corrupted state is dropped instead, as it must not replace the backup.
+func (s *PostgresStateStore) Reset() error {
+	query := "UPDATE %s SET backup = state, state = NULL, updated_at = now() WHERE state_key = $1 AND state IS NOT NULL"
+	data, err := s.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil && !json.Valid(data) {
+		query = "UPDATE %s SET state = NULL, updated_at = now() WHERE state_key = $1 AND state IS NOT NULL"
+	}
+	err = s.update(query, "reset", "state")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// update runs a statement on the row of the plan and reports an error
+// matching fs.ErrNotExist if it did not change anything
+func (s *PostgresStateStore) update(query, action, column string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stateQueryTimeout)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, fmt.Sprintf(query, s.ident), s.key)
+	if err != nil {
+		return fmt.Errorf("failed to %s state in %s: %w", action, s.Location(), err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("no %s stored in %s: %w", column, s.Location(), fs.ErrNotExist)
+	}
+	return nil
+}
+
+// lock takes a session-level advisory lock keyed by the table and key of the
+// state, on a connection held until the lock is released. PostgreSQL releases
+// the lock when the session ends, so the lock of a killed run does not remain.
+func (s *PostgresStateStore) lock() (func() error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), stateQueryTimeout)
+	defer cancel()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock state in %s: %w", s.Location(), err)
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1), hashtext($2))", s.table, s.key).Scan(&locked); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to lock state in %s: %w", s.Location(), err)
+	}
+	if !locked {
+		_ = conn.Close()
+		return nil, &LockedError{Path: s.Location(), Shared: true}
+	}
+
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), stateQueryTimeout)
+		defer cancel()
+
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1), hashtext($2))", s.table, s.key); err != nil {
+			// End the session rather than returning a connection holding the lock to the pool
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			_ = conn.Close()
+			return fmt.Errorf("failed to unlock state in %s: %w", s.Location(), err)
+		}
+		return conn.Close()
+	}, nil
+}
+
+// Location describes the row of the plan
+func (s *PostgresStateStore) Location() string {
+	return fmt.Sprintf("postgres table %s (key %q)", s.table, s.key)
+}
+
+// BackupLocation describes the backup column of the row of the plan
+func (s *PostgresStateStore) BackupLocation() string {
+	return fmt.Sprintf("postgres table %s (key %q, column backup)", s.table, s.key)
+}
+
+// Close closes the database connection
+func (s *PostgresStateStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockStateStore creates a postgres state store on a mock database
+func newMockStateStore(t *testing.T, table string) (*PostgresStateStore, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS "plexr_state"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	store, err := NewPostgresStateStore(db, table, "Dev Setup")
+	require.NoError(t, err)
+	return store, mock
+}
+
+func TestPostgresStateStore(t *testing.T) {
+	t.Run("Creates the table", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS "setup"."plexr_state" (`)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		store, err := NewPostgresStateStore(db, "setup.plexr_state", "Dev Setup")
+		require.NoError(t, err)
+		assert.Equal(t, `postgres table setup.plexr_state (key "Dev Setup")`, store.Location())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Table creation fails", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectExec("CREATE TABLE").WillReturnError(errors.New("permission denied for schema public"))
+		_, err = NewPostgresStateStore(db, "", "Dev Setup")
+		assert.ErrorContains(t, err, "failed to create state table plexr_state: permission denied")
+	})
+
+	t.Run("Empty key", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		_, err = NewPostgresStateStore(db, "", "")
+		assert.ErrorContains(t, err, "state key cannot be empty")
+	})
+
+	t.Run("Load", func(t *testing.T) {
+		store, mock := newMockStateStore(t, "")
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT state FROM "plexr_state" WHERE state_key = $1`)).
+			WithArgs("Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"state"}).AddRow(`{"setup_name": "Dev Setup"}`))
+		data, err := store.Load()
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"setup_name": "Dev Setup"}`, string(data))
+
+		// No row
+		mock.ExpectQuery("SELECT state FROM").WithArgs("Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"state"}))
+		_, err = store.Load()
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+		// A row whose state was reset
+		mock.ExpectQuery("SELECT state FROM").WithArgs("Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"state"}).AddRow(nil))
+		_, err = store.Load()
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+		mock.ExpectQuery("SELECT state FROM").WithArgs("Dev Setup").
+			WillReturnError(errors.New("connection refused"))
+		_, err = store.Load()
+		assert.ErrorContains(t, err, "connection refused")
+		assert.False(t, errors.Is(err, fs.ErrNotExist))
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Save keeps the previous state as the backup", func(t *testing.T) {
+		store, mock := newMockStateStore(t, "")
+
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "plexr_state" AS s (state_key, state, updated_at) VALUES ($1, $2, now())
+ON CONFLICT (state_key) DO UPDATE SET backup = COALESCE(s.state, s.backup), state = EXCLUDED.state`)).
+			WithArgs("Dev Setup", `{"setup_name": "Dev Setup"}`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		require.NoError(t, store.Save([]byte(`{"setup_name": "Dev Setup"}`)))
+
+		// Later saves of the run leave the backup alone
+		mock.ExpectExec(regexp.QuoteMeta(`ON CONFLICT (state_key) DO UPDATE SET state = EXCLUDED.state`)).
+			WithArgs("Dev Setup", `{"completed_steps": ["install"]}`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		require.NoError(t, store.Save([]byte(`{"completed_steps": ["install"]}`)))
+
+		mock.ExpectExec("INSERT INTO").WillReturnError(errors.New("connection reset"))
+		assert.ErrorContains(t, store.Save([]byte(`{}`)), "failed to write state to postgres table plexr_state")
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Reset and restore", func(t *testing.T) {
+		store, mock := newMockStateStore(t, "")
+
+		mock.ExpectQuery("SELECT state FROM").WithArgs("Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"state"}).AddRow(`{"setup_name": "Dev Setup"}`))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plexr_state" SET backup = state, state = NULL`)).
+			WithArgs("Dev Setup").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		require.NoError(t, store.Reset())
+
+		// Nothing to reset
+		mock.ExpectQuery("SELECT state FROM").WithArgs("Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"state"}))
+		mock.ExpectExec("UPDATE").WithArgs("Dev Setup").WillReturnResult(sqlmock.NewResult(0, 0))
+		require.NoError(t, store.Reset())
+
+		// A corrupted state does not replace the backup
+		mock.ExpectQuery("SELECT state FROM").WithArgs("Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"state"}).AddRow(`{"setup_name": "Dev`))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plexr_state" SET state = NULL`)).
+			WithArgs("Dev Setup").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		require.NoError(t, store.Reset())
+
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plexr_state" SET state = backup`)).
+			WithArgs("Dev Setup").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		require.NoError(t, store.RestoreBackup())
+
+		mock.ExpectExec("UPDATE").WithArgs("Dev Setup").WillReturnResult(sqlmock.NewResult(0, 0))
+		assert.True(t, errors.Is(store.RestoreBackup(), fs.ErrNotExist))
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("State manager on a postgres store", func(t *testing.T) {
+		store, mock := newMockStateStore(t, "")
+		sm := NewStateManagerWithStore(store)
+
+		mock.ExpectExec("INSERT INTO").
+			WithArgs("Dev Setup", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		require.NoError(t, sm.Save(&ExecutionState{SetupName: "Dev Setup", CompletedSteps: []string{"install"}}))
+
+		mock.ExpectQuery("SELECT state FROM").WithArgs("Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"state"}).AddRow(`{"setup_name": "Dev Setup", "completed_steps": ["install"]}`))
+		state, err := sm.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"install"}, state.CompletedSteps)
+
+		// A corrupted state points to a readable backup
+		mock.ExpectQuery("SELECT state FROM").WithArgs("Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"state"}).AddRow(`{"setup_name": "Dev`))
+		mock.ExpectQuery("SELECT backup FROM").WithArgs("Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"backup"}).AddRow(`{"setup_name": "Dev Setup"}`))
+		_, err = sm.Load()
+		var corrupt *CorruptStateError
+		require.ErrorAs(t, err, &corrupt)
+		assert.Equal(t, store.Location(), corrupt.Path)
+		assert.Equal(t, store.BackupLocation(), corrupt.Backup)
+
+		mock.ExpectQuery("SELECT backup FROM").WithArgs("Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"backup"}).AddRow(`{"setup_name": "Dev Setup", "completed_steps": ["install"]}`))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plexr_state" SET state = backup`)).
+			WithArgs("Dev Setup").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		state, err = sm.RestoreBackup()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"install"}, state.CompletedSteps)
+
+		mock.ExpectClose()
+		require.NoError(t, sm.Close())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Lock takes an advisory lock on the state key", func(t *testing.T) {
+		store, mock := newMockStateStore(t, "")
+		sm := NewStateManagerWithStore(store)
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_try_advisory_lock(hashtext($1), hashtext($2))")).
+			WithArgs("plexr_state", "Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+		lock, err := sm.Lock(stateFile)
+		require.NoError(t, err)
+		assert.FileExists(t, LockPath(stateFile))
+
+		mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock(hashtext($1), hashtext($2))")).
+			WithArgs("plexr_state", "Dev Setup").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		require.NoError(t, lock.Release())
+		assert.NoFileExists(t, LockPath(stateFile))
+
+		// Held by a run on another host
+		mock.ExpectQuery("SELECT pg_try_advisory_lock").
+			WithArgs("plexr_state", "Dev Setup").
+			WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(false))
+		_, err = sm.Lock(stateFile)
+		var lockedErr *LockedError
+		require.ErrorAs(t, err, &lockedErr)
+		assert.True(t, lockedErr.Shared)
+		assert.Contains(t, err.Error(), `the state in postgres table plexr_state (key "Dev Setup") is locked`)
+		// The lock file is not left behind
+		assert.NoFileExists(t, LockPath(stateFile))
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/executors"
+)
+
+// StateStore persists the serialized execution state of a plan. Every store
+// keeps the state it found as a backup: the state at the start of a run.
+type StateStore interface {
+	// Load returns the stored state, or an error matching fs.ErrNotExist if
+	// no state is stored
+	Load() ([]byte, error)
+	// LoadBackup returns the backup of the state, or an error matching
+	// fs.ErrNotExist if there is none
+	LoadBackup() ([]byte, error)
+	// Save replaces the stored state. The first save of a store keeps the
+	// state it replaces as the backup; later saves leave the backup alone.
+	Save(data []byte) error
+	// RestoreBackup replaces the stored state with its backup
+	RestoreBackup() error
+	// Reset removes the stored state, keeping it as the backup unless it is
+	// corrupted, in which case the backup is kept instead
+	Reset() error
+	// Location describes where the state is stored, for messages
+	Location() string
+	// BackupLocation describes where the backup is stored, for messages
+	BackupLocation() string
+	// Close releases the resources of the store
+	Close() error
+}
+
+// storeLocker is implemented by state stores shared between hosts. The lock
+// of the state file only excludes runs of the same host, so these stores
+// also lock the state in the store itself.
+type storeLocker interface {
+	// lock locks the stored state and returns the function releasing it. A
+	// state locked by another run fails with a *LockedError.
+	lock() (func() error, error)
+}
+
+// OpenStateStore opens the state store selected by the state section of a
+// plan. The file store keeps the state in stateFile.
+func OpenStateStore(plan *config.ExecutionPlan, stateFile string) (StateStore, error) {
+	switch plan.State.Backend {
+	case "", "file":
+		return NewFileStateStore(stateFile)
+	case "postgres":
+		executor, ok := plan.Executors[plan.State.Executor]
+		if !ok {
+			return nil, fmt.Errorf("undefined state executor: %s", plan.State.Executor)
+		}
+		db, err := executors.OpenDatabase(executor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open state database: %w", err)
+		}
+		key := plan.State.Key
+		if key == "" {
+			key = plan.Name
+		}
+		store, err := NewPostgresStateStore(db, plan.State.Table, key)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unsupported state backend: %s", plan.State.Backend)
+	}
+}
+
+// FileStateStore stores the state in a JSON file, with the backup in a file
+// next to it (see BackupPath). Files are replaced atomically.
+type FileStateStore struct {
+	path    string
+	written []byte // Contents of the state file, kept as the backup when it is first replaced
+	saved   bool   // Whether the state file has been saved, so that the backup is taken once
+}
+
+// CorruptPath returns the path a corrupted state file is moved to by a reset
+func CorruptPath(stateFile string) string {
+	return stateFile + ".corrupt"
+}
+
+// NewFileStateStore creates a store for the state file at path
+func NewFileStateStore(path string) (*FileStateStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("state file path cannot be empty")
+	}
+	return &FileStateStore{path: path}, nil
+}
+
+// Load reads the state file
+func (s *FileStateStore) Load() ([]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	// A corrupted state file must not replace a good backup
+	if json.Valid(data) {
+		s.written = data
+	}
+	return data, nil
+}
+
+// LoadBackup reads the backup of the state file
+func (s *FileStateStore) LoadBackup() ([]byte, error) {
+	data, err := os.ReadFile(BackupPath(s.path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state backup: %w", err)
+	}
+	return data, nil
+}
+
+// Save replaces the state file. On the first save, the contents it had when
+// it was loaded become the backup, so that the backup holds the state from
+// before the run rather than from one change ago.
+func (s *FileStateStore) Save(data []byte) error {
+	if !s.saved && s.written != nil {
+		if err := writeFileAtomic(BackupPath(s.path), s.written); err != nil {
+			return fmt.Errorf("failed to write state backup: %w", err)
+		}
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	s.written = data
+	s.saved = true
+	return nil
+}
+
+// RestoreBackup replaces the state file with its backup
+func (s *FileStateStore) RestoreBackup() error {
+	data, err := s.LoadBackup()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to restore state file: %w", err)
+	}
+	s.written = data
+	return nil
+}
+
+// Reset moves the state file to its backup. A corrupted state file must not
+// replace the backup, which may be the only readable state; it is moved
+// aside instead (see CorruptPath).
+func (s *FileStateStore) Reset() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err == nil {
+		target := BackupPath(s.path)
+		if !json.Valid(data) {
+			target = CorruptPath(s.path)
+		}
+		if err := os.Rename(s.path, target); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove state file: %w", err)
+		}
+	}
+	s.written = nil
+	return nil
+}
+
+// Location returns the path of the state file
+func (s *FileStateStore) Location() string {
+	return s.path
+}
+
+// BackupLocation returns the path of the backup of the state file
+func (s *FileStateStore) BackupLocation() string {
+	return BackupPath(s.path)
+}
+
+// Close does nothing; files are not kept open
+func (s *FileStateStore) Close() error {
+	return nil
+}
@@ -2,12 +2,16 @@ package core
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -60,7 +64,10 @@ func TestStateManager(t *testing.T) {
 				} else {
 					assert.NoError(t, err)
 					assert.NotNil(t, sm)
-					assert.Equal(t, filePath, sm.filePath)
+					store, ok := sm.store.(*FileStateStore)
+					require.True(t, ok)
+					assert.Equal(t, filePath, store.path)
+					assert.Equal(t, filePath, store.Location())
 					// Note: We can't directly check sm.mu due to copylocks warning
 					// The mutex is properly initialized by the constructor
 				}
@@ -97,7 +104,7 @@ func TestStateManager(t *testing.T) {
 					UpdatedAt:      time.Now(),
 					CompletedSteps: []string{"step1", "step2", "step3"},
 					CurrentStep:    "step4",
-					FailedFiles:    []string{"error.sh"},
+					FailedFiles:    []string{"step4:error.sh"},
 					InstalledTools: map[string]string{
 						"docker": "24.0.7",
 						"node":   "20.10.0",
@@ -246,6 +253,9 @@ func TestStateManager(t *testing.T) {
 		_, err = os.Stat(stateFile)
 		assert.True(t, os.IsNotExist(err))
 
+		// The reset state is kept as the backup
+		assert.FileExists(t, BackupPath(stateFile))
+
 		// Verify internal state is cleared - state manager doesn't expose internal state
 
 		// Load should fail
@@ -338,6 +348,198 @@ func TestStateManager(t *testing.T) {
 		assert.Equal(t, "step2", loaded.CurrentStep)
 	})
 
+	t.Run("Step records", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, sm.Save(&ExecutionState{SetupName: "Records Test", SetupVersion: "1.0.0"}))
+
+		assert.Error(t, sm.RecordFile("build", FileRecord{Path: "build.sh"}), "step has not started")
+
+		start := time.Now()
+		require.NoError(t, sm.StartStep("build", start))
+		require.NoError(t, sm.RecordFile("build", FileRecord{Path: "build.sh", Status: StatusFailed, Attempts: 1, Output: "first"}))
+		require.NoError(t, sm.RecordFile("build", FileRecord{Path: "build.sh", Status: StatusFailed, Attempts: 2, ExitCode: 3, Output: "second"}))
+		require.NoError(t, sm.FinishStep("build", StatusFailed, "", "build.sh failed"))
+		require.NoError(t, sm.FinishStep("lint", StatusSkipped, "skip_if_condition", ""))
+
+		loaded, err := sm.Load()
+		require.NoError(t, err)
+		build := loaded.Steps["build"]
+		require.NotNil(t, build)
+		assert.Equal(t, StatusFailed, build.Status)
+		assert.Equal(t, 1, build.Attempts)
+		assert.Equal(t, "build.sh failed", build.Error)
+		assert.WithinDuration(t, start, build.StartedAt, time.Second)
+		require.NotNil(t, build.FinishedAt)
+		require.Len(t, build.Files, 1)
+		assert.Equal(t, FileRecord{Path: "build.sh", Status: StatusFailed, Attempts: 2, ExitCode: 3, Output: "second"}, build.Files[0])
+
+		lint := loaded.Steps["lint"]
+		require.NotNil(t, lint)
+		assert.Equal(t, StatusSkipped, lint.Status)
+		assert.Equal(t, "skip_if_condition", lint.Reason)
+		assert.Zero(t, lint.Attempts)
+
+		// Running the step again replaces its files and counts the execution
+		require.NoError(t, sm.StartStep("build", time.Now()))
+		loaded, err = sm.Load()
+		require.NoError(t, err)
+		assert.Equal(t, StatusRunning, loaded.Steps["build"].Status)
+		assert.Equal(t, 2, loaded.Steps["build"].Attempts)
+		assert.Empty(t, loaded.Steps["build"].Files)
+		// A step finishing without executing keeps the fingerprint of its last execution
+		require.NoError(t, sm.SetStepFingerprint("build", "abc123"))
+		require.NoError(t, sm.FinishStep("build", StatusCompleted, "", ""))
+		require.NoError(t, sm.FinishStep("build", StatusSkipped, "check_command_satisfied", ""))
+		assert.Equal(t, "abc123", sm.StepFingerprint("build"))
+	})
+
+	t.Run("Output tail", func(t *testing.T) {
+		assert.Equal(t, "short", outputTail("short"))
+
+		long := strings.Repeat("a", maxOutputTail) + "end"
+		assert.Equal(t, long[3:], outputTail(long))
+
+		// A cut multi-byte character is dropped
+		multibyte := strings.Repeat("é", maxOutputTail)
+		tail := outputTail(multibyte)
+		assert.True(t, utf8.ValidString(tail))
+		assert.LessOrEqual(t, len(tail), maxOutputTail)
+	})
+
+	t.Run("State files without step records", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		legacy := `{
+  "setup_name": "Legacy",
+  "setup_version": "1.0.0",
+  "platform": "linux",
+  "started_at": "2025-01-01T00:00:00Z",
+  "updated_at": "2025-01-01T00:00:00Z",
+  "completed_steps": ["install"],
+  "current_step": "",
+  "failed_files": [],
+  "installed_tools": {}
+}`
+		require.NoError(t, os.WriteFile(stateFile, []byte(legacy), 0600))
+
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		loaded, err := sm.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"install"}, loaded.CompletedSteps)
+		assert.Nil(t, loaded.Steps)
+
+		require.NoError(t, sm.StartStep("configure", time.Now()))
+		assert.True(t, sm.IsStepCompleted("install"))
+	})
+
+	t.Run("Failed files of older states are keyed by step", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		legacy := `{
+  "completed_steps": ["install"],
+  "current_step": "deploy",
+  "failed_files": ["build.sh", "deploy.sh", "test:test.sh"],
+  "failed_steps": ["test"],
+  "steps": {
+    "build": {"status": "failed", "files": [{"path": "build.sh", "status": "failed"}]},
+    "lint": {"status": "completed", "files": [{"path": "deploy.sh", "status": "completed"}]}
+  }
+}`
+		require.NoError(t, os.WriteFile(stateFile, []byte(legacy), 0600))
+
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		loaded, err := sm.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"build:build.sh", "deploy:deploy.sh", "test:test.sh"}, loaded.FailedFiles)
+
+		// Resetting the step clears its failed files
+		require.NoError(t, sm.ResetSteps([]string{"deploy"}))
+		loaded, err = sm.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"build:build.sh", "test:test.sh"}, loaded.FailedFiles)
+	})
+
+	t.Run("ResetSteps", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, sm.Save(&ExecutionState{
+			SetupName:      "Reset Test",
+			CompletedSteps: []string{"setup", "build", "docs"},
+			CurrentStep:    "test",
+			FailedSteps:    []string{"test"},
+			FailedFiles:    []string{attemptKey("test", "test.sh"), attemptKey("docs", "test.sh")},
+			FileAttempts: map[string][]AttemptRecord{
+				attemptKey("test", "test.sh"): {{Attempt: 1, Error: "exit status 1"}},
+				attemptKey("docs", "docs.sh"): {{Attempt: 1, Error: "exit status 1"}},
+			},
+			StepOutputs: map[string]map[string]string{"build": {"ARTIFACT": "app.tar"}, "setup": {"DIR": "/opt"}},
+			Steps: map[string]*StepRecord{
+				"build": {Status: StatusCompleted, Attempts: 1, Files: []FileRecord{{Path: "build.sh", Status: StatusCompleted}}},
+				"test":  {Status: StatusFailed, Attempts: 2, Files: []FileRecord{{Path: "test.sh", Status: StatusFailed}}},
+			},
+		}))
+
+		require.NoError(t, sm.ResetSteps([]string{"build", "test", "unknown"}))
+
+		loaded, err := sm.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"setup", "docs"}, loaded.CompletedSteps)
+		assert.Empty(t, loaded.FailedSteps)
+		assert.Empty(t, loaded.CurrentStep)
+		// The same file failing in another step stays failed
+		assert.Equal(t, []string{attemptKey("docs", "test.sh")}, loaded.FailedFiles)
+		assert.Equal(t, map[string][]AttemptRecord{
+			attemptKey("docs", "docs.sh"): {{Attempt: 1, Error: "exit status 1"}},
+		}, loaded.FileAttempts)
+		assert.Equal(t, map[string]map[string]string{"setup": {"DIR": "/opt"}}, loaded.StepOutputs)
+		assert.Empty(t, loaded.Steps)
+	})
+
+	t.Run("ClearFailures", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+
+		interruptedAt := time.Now()
+		require.NoError(t, sm.Save(&ExecutionState{
+			SetupName:        "Clear Test",
+			CompletedSteps:   []string{"setup"},
+			FailedSteps:      []string{"test"},
+			FailedFiles:      []string{attemptKey("test", "test.sh")},
+			InterruptedSteps: []string{"deploy"},
+			InterruptedAt:    &interruptedAt,
+			FileAttempts:     map[string][]AttemptRecord{attemptKey("test", "test.sh"): {{Attempt: 1}}},
+			Steps: map[string]*StepRecord{
+				"setup":  {Status: StatusCompleted, Error: "ignored failure"},
+				"test":   {Status: StatusFailed},
+				"deploy": {Status: StatusInterrupted},
+				"lint":   {Status: StatusFailed},
+			},
+		}))
+
+		cleared, err := sm.ClearFailures()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"deploy", "lint", "test"}, cleared)
+
+		loaded, err := sm.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"setup"}, loaded.CompletedSteps)
+		assert.Empty(t, loaded.FailedSteps)
+		assert.Empty(t, loaded.FailedFiles)
+		assert.Empty(t, loaded.InterruptedSteps)
+		assert.Nil(t, loaded.InterruptedAt)
+		assert.Empty(t, loaded.FileAttempts)
+		assert.Equal(t, []string{"setup"}, mapKeys(loaded.Steps))
+
+		// Nothing left to clear
+		cleared, err = sm.ClearFailures()
+		require.NoError(t, err)
+		assert.Empty(t, cleared)
+	})
+
 	t.Run("Corrupted state file", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		stateFile := filepath.Join(tmpDir, "state.json")
@@ -354,6 +556,103 @@ func TestStateManager(t *testing.T) {
 		assert.Error(t, err)
 		assert.Nil(t, loaded)
 		assert.Contains(t, err.Error(), "failed to parse state file")
+
+		var corrupt *CorruptStateError
+		require.True(t, errors.As(err, &corrupt))
+		assert.Empty(t, corrupt.Backup)
+		assert.Contains(t, err.Error(), "no backup is available")
+	})
+
+	t.Run("Writes replace the file and keep a backup", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		stateFile := filepath.Join(tmpDir, "state.json")
+
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, sm.Save(&ExecutionState{SetupName: "Backup Test", SetupVersion: "1.0.0"}))
+		require.NoError(t, sm.MarkStepCompleted("step1"))
+		assert.NoFileExists(t, BackupPath(stateFile))
+
+		// The next run keeps the state it started from as the backup, once
+		sm, err = NewStateManager(stateFile)
+		require.NoError(t, err)
+		_, err = sm.Load()
+		require.NoError(t, err)
+		require.NoError(t, sm.MarkStepCompleted("step2"))
+		require.NoError(t, sm.MarkStepCompleted("step3"))
+
+		backup, err := NewStateManager(BackupPath(stateFile))
+		require.NoError(t, err)
+		previous, err := backup.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"step1"}, previous.CompletedSteps)
+
+		// No temporary files are left behind
+		entries, err := os.ReadDir(tmpDir)
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		assert.ElementsMatch(t, []string{"state.json", "state.json.bak"}, names)
+	})
+
+	t.Run("Truncated state file is recovered from the backup", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, sm.Save(&ExecutionState{SetupName: "Recovery Test", SetupVersion: "1.0.0"}))
+		require.NoError(t, sm.MarkStepCompleted("step1"))
+
+		sm, err = NewStateManager(stateFile)
+		require.NoError(t, err)
+		_, err = sm.Load()
+		require.NoError(t, err)
+		require.NoError(t, sm.MarkStepCompleted("step2"))
+
+		data, err := os.ReadFile(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(stateFile, data[:len(data)/2], 0600))
+
+		sm, err = NewStateManager(stateFile)
+		require.NoError(t, err)
+		_, err = sm.Load()
+		var corrupt *CorruptStateError
+		require.True(t, errors.As(err, &corrupt))
+		assert.Equal(t, BackupPath(stateFile), corrupt.Backup)
+		assert.Contains(t, err.Error(), "backup of the previous state is available")
+
+		restored, err := sm.RestoreBackup()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"step1"}, restored.CompletedSteps)
+
+		loaded, err := sm.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"step1"}, loaded.CompletedSteps)
+	})
+
+	t.Run("Reset of a corrupted state keeps the backup", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		require.NoError(t, os.WriteFile(BackupPath(stateFile), []byte(`{"completed_steps": ["step1"]}`), 0600))
+		require.NoError(t, os.WriteFile(stateFile, []byte(`{"completed_steps": [`), 0600))
+
+		sm, err := NewStateManager(stateFile)
+		require.NoError(t, err)
+		require.NoError(t, sm.Reset())
+		assert.NoFileExists(t, stateFile)
+		assert.FileExists(t, CorruptPath(stateFile))
+
+		restored, err := sm.RestoreBackup()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"step1"}, restored.CompletedSteps)
+	})
+
+	t.Run("RestoreBackup without a backup", func(t *testing.T) {
+		sm, err := NewStateManager(filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		_, err = sm.RestoreBackup()
+		assert.Error(t, err)
 	})
 
 	t.Run("File permissions", func(t *testing.T) {
@@ -444,7 +743,7 @@ func TestExecutionState(t *testing.T) {
 			UpdatedAt:      time.Now(),
 			CompletedSteps: []string{"step1", "step2"},
 			CurrentStep:    "step3",
-			FailedFiles:    []string{"fail.sh"},
+			FailedFiles:    []string{"step3:fail.sh"},
 			InstalledTools: map[string]string{
 				"tool1": "v1.0",
 				"tool2": "v2.0",
@@ -473,4 +772,34 @@ func TestExecutionState(t *testing.T) {
 		assert.WithinDuration(t, state.StartedAt, loaded.StartedAt, time.Second)
 		assert.WithinDuration(t, state.UpdatedAt, loaded.UpdatedAt, time.Second)
 	})
+
+	t.Run("RunningSteps", func(t *testing.T) {
+		state := &ExecutionState{
+			CurrentStep:    "c",
+			CompletedSteps: []string{"a"},
+			Steps: map[string]*StepRecord{
+				"a": {Status: StatusCompleted},
+				"c": {Status: StatusRunning},
+				"b": {Status: StatusRunning},
+				"d": {Status: StatusFailed},
+			},
+		}
+		assert.Equal(t, []string{"b", "c"}, state.RunningSteps())
+
+		// States without step records fall back to the current step
+		legacy := &ExecutionState{CurrentStep: "b", CompletedSteps: []string{"a"}}
+		assert.Equal(t, []string{"b"}, legacy.RunningSteps())
+		legacy.CompletedSteps = append(legacy.CompletedSteps, "b")
+		assert.Empty(t, legacy.RunningSteps())
+	})
+}
+
+// mapKeys returns the sorted keys of a map of step records
+func mapKeys(records map[string]*StepRecord) []string {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
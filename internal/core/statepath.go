@@ -0,0 +1,62 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// LegacyStateFileName is the name of the state file that earlier versions
+// kept next to the plan
+const LegacyStateFileName = ".plexr_state.json"
+
+// StateDir returns the per-user directory of state files: $XDG_STATE_HOME/plexr,
+// ~/.local/state/plexr, or %LocalAppData%\plexr\state on Windows
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "plexr"), nil
+	}
+
+	if runtime.GOOS == "windows" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to determine the state directory: %w", err)
+		}
+		return filepath.Join(dir, "plexr", "state"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the state directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "state", "plexr"), nil
+}
+
+// DefaultStateFile returns the state file of a plan in the per-user state
+// directory. It is named after the plan file and keyed by the plan's absolute
+// path, so plans with the same name in different checkouts do not share
+// state. A state file next to the plan, written by an earlier version, is
+// used for as long as it exists.
+func DefaultStateFile(planFile string) (string, error) {
+	legacy := filepath.Join(filepath.Dir(planFile), LegacyStateFileName)
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, nil
+	}
+
+	absPlan, err := filepath.Abs(planFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve plan path: %w", err)
+	}
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(absPlan))
+	name := strings.TrimSuffix(filepath.Base(absPlan), filepath.Ext(absPlan))
+	return filepath.Join(dir, name+"-"+hex.EncodeToString(sum[:6])+".json"), nil
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultStateFile(t *testing.T) {
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+
+	t.Run("StateDir honors XDG_STATE_HOME", func(t *testing.T) {
+		dir, err := StateDir()
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(stateHome, "plexr"), dir)
+	})
+
+	t.Run("Relative XDG_STATE_HOME is ignored", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("the fallback directory differs on Windows")
+		}
+		t.Setenv("XDG_STATE_HOME", "relative/state")
+		t.Setenv("HOME", stateHome)
+
+		dir, err := StateDir()
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(stateHome, ".local", "state", "plexr"), dir)
+	})
+
+	t.Run("Keyed by the absolute plan path", func(t *testing.T) {
+		checkouts := t.TempDir()
+		first := filepath.Join(checkouts, "a", "setup.yml")
+		second := filepath.Join(checkouts, "b", "setup.yml")
+
+		firstState, err := DefaultStateFile(first)
+		require.NoError(t, err)
+		secondState, err := DefaultStateFile(second)
+		require.NoError(t, err)
+
+		assert.Equal(t, filepath.Join(stateHome, "plexr"), filepath.Dir(firstState))
+		assert.Regexp(t, `^setup-[0-9a-f]{12}\.json$`, filepath.Base(firstState))
+		assert.NotEqual(t, firstState, secondState)
+
+		again, err := DefaultStateFile(first)
+		require.NoError(t, err)
+		assert.Equal(t, firstState, again)
+	})
+
+	t.Run("Relative plan paths resolve to the same state", func(t *testing.T) {
+		planFile := filepath.Join(t.TempDir(), "setup.yml")
+		wd, err := os.Getwd()
+		require.NoError(t, err)
+		relativePlan, err := filepath.Rel(wd, planFile)
+		if err != nil {
+			t.Skip("the plan cannot be reached by a relative path")
+		}
+
+		relative, err := DefaultStateFile(relativePlan)
+		require.NoError(t, err)
+		absolute, err := DefaultStateFile(planFile)
+		require.NoError(t, err)
+		assert.Equal(t, absolute, relative)
+	})
+
+	t.Run("State file of earlier versions next to the plan", func(t *testing.T) {
+		planDir := t.TempDir()
+		legacy := filepath.Join(planDir, LegacyStateFileName)
+		require.NoError(t, os.WriteFile(legacy, []byte(`{}`), 0600))
+
+		stateFile, err := DefaultStateFile(filepath.Join(planDir, "setup.yml"))
+		require.NoError(t, err)
+		assert.Equal(t, legacy, stateFile)
+	})
+}
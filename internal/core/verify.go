@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SphereStacking/plexr/internal/utils"
+)
+
+// Results of verifying a completed step
+const (
+	VerifySatisfied    = "satisfied"
+	VerifyDrifted      = "drifted"
+	VerifyUnverifiable = "unverifiable" // The step has no verify or check command
+	VerifyError        = "error"
+)
+
+// VerifyResult describes the verification of a completed step
+type VerifyResult struct {
+	StepID string `json:"step_id"`
+	Status string `json:"status"`
+	Check  string `json:"check,omitempty"` // The command or skip_if condition that was evaluated
+	Error  string `json:"error,omitempty"`
+}
+
+// Verify checks that the completed steps selected by the run options are
+// still satisfied, without running them, in execution order. A step is
+// verified by its verify command, or else its check command. A step that
+// was skipped by its skip_if condition is satisfied as long as the condition
+// still holds. Verify does not change the state; a missing state is an error
+// matching fs.ErrNotExist.
+func (r *Runner) Verify(ctx context.Context) ([]VerifyResult, error) {
+	state, err := r.stateManager.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	order, err := SelectSteps(r.plan, r.options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build execution order: %w", err)
+	}
+
+	var results []VerifyResult
+	for _, stepID := range order {
+		if !containsString(state.CompletedSteps, stepID) {
+			continue
+		}
+		step := r.findStep(stepID)
+		result := VerifyResult{StepID: stepID}
+
+		var satisfied bool
+		var err error
+		record := state.Steps[stepID]
+		switch {
+		case record != nil && record.Status == StatusSkipped && record.Reason == "skip_if_condition" && step.SkipIf != "":
+			result.Check = "skip_if: " + step.SkipIf
+			satisfied, err = r.evaluateCondition(ctx, step, step.SkipIf)
+		case step.Verify != "":
+			result.Check = step.Verify
+			satisfied, err = r.runCheck(ctx, step, step.Verify)
+		case step.CheckCommand != "":
+			result.Check = step.CheckCommand
+			satisfied, err = r.runCheck(ctx, step, step.CheckCommand)
+		default:
+			result.Status = VerifyUnverifiable
+			results = append(results, result)
+			continue
+		}
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+
+		switch {
+		case err != nil:
+			result.Status = VerifyError
+			result.Error = utils.MaskSecrets(err.Error())
+		case satisfied:
+			result.Status = VerifySatisfied
+		default:
+			result.Status = VerifyDrifted
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// DriftedSteps returns the IDs of the steps that are no longer satisfied
+func DriftedSteps(results []VerifyResult) []string {
+	var ids []string
+	for _, result := range results {
+		if result.Status == VerifyDrifted {
+			ids = append(ids, result.StepID)
+		}
+	}
+	return ids
+}
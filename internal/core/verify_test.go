@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnerVerify(t *testing.T) {
+	newRunner := func(t *testing.T, marker string) *Runner {
+		t.Helper()
+		plan := &config.ExecutionPlan{
+			Name:    "Verify Test",
+			Version: "1.0.0",
+			Executors: map[string]config.ExecutorConfig{
+				"mock": {"type": "mock"},
+			},
+			Steps: []config.Step{
+				{ID: "tool", Executor: "mock", CheckCommand: "test -f " + marker, Files: []config.FileConfig{{Path: "tool.sh"}}},
+				{ID: "database", Executor: "mock", Verify: "exit 1", CheckCommand: "exit 0", DependsOn: []string{"tool"}, Files: []config.FileConfig{{Path: "db.sh"}}},
+				{ID: "config", Executor: "mock", Files: []config.FileConfig{{Path: "config.sh"}}},
+				{ID: "optional", Executor: "mock", SkipIf: `file_exists("` + marker + `")`, Files: []config.FileConfig{{Path: "optional.sh"}}},
+				{ID: "pending", Executor: "mock", CheckCommand: "exit 1", Files: []config.FileConfig{{Path: "pending.sh"}}},
+			},
+		}
+		runner, err := NewRunner(plan, filepath.Join(t.TempDir(), "state.json"))
+		require.NoError(t, err)
+		require.NoError(t, runner.RegisterExecutor("mock", &MockExecutor{name: "mock"}))
+		return runner
+	}
+
+	t.Run("No state", func(t *testing.T) {
+		runner := newRunner(t, "missing")
+		_, err := runner.Verify(context.Background())
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+	})
+
+	t.Run("Reports drifted steps", func(t *testing.T) {
+		marker := filepath.Join(t.TempDir(), "installed")
+		require.NoError(t, os.WriteFile(marker, nil, 0644))
+		runner := newRunner(t, marker)
+
+		require.NoError(t, runner.stateManager.Save(&ExecutionState{
+			SetupName:      "Verify Test",
+			CompletedSteps: []string{"tool", "database", "config", "optional", "removed"},
+			Steps: map[string]*StepRecord{
+				"optional": {Status: StatusSkipped, Reason: "skip_if_condition"},
+			},
+		}))
+
+		results, err := runner.Verify(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []VerifyResult{
+			{StepID: "tool", Status: VerifySatisfied, Check: "test -f " + marker},
+			{StepID: "database", Status: VerifyDrifted, Check: "exit 1"},
+			{StepID: "config", Status: VerifyUnverifiable},
+			{StepID: "optional", Status: VerifySatisfied, Check: `skip_if: file_exists("` + marker + `")`},
+		}, results)
+		assert.Equal(t, []string{"database"}, DriftedSteps(results))
+
+		// The tool and the reason to skip the optional step are gone
+		require.NoError(t, os.Remove(marker))
+		results, err = runner.Verify(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"tool", "database", "optional"}, DriftedSteps(results))
+
+		// Verifying does not change the state
+		state, err := runner.stateManager.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"tool", "database", "config", "optional", "removed"}, state.CompletedSteps)
+	})
+
+	t.Run("Verifies the selected steps", func(t *testing.T) {
+		runner := newRunner(t, "missing")
+		require.NoError(t, runner.stateManager.Save(&ExecutionState{
+			SetupName:      "Verify Test",
+			CompletedSteps: []string{"tool", "database", "config"},
+		}))
+		require.NoError(t, runner.SetOptions(RunOptions{Only: []string{"config"}}))
+
+		results, err := runner.Verify(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []VerifyResult{{StepID: "config", Status: VerifyUnverifiable}}, results)
+	})
+
+	t.Run("Check errors", func(t *testing.T) {
+		runner := newRunner(t, "missing")
+		runner.plan.Steps[0].CheckCommand = "test -n '{{ steps.database.outputs.url }}'"
+		require.NoError(t, runner.stateManager.Save(&ExecutionState{
+			SetupName:      "Verify Test",
+			CompletedSteps: []string{"tool"},
+		}))
+
+		results, err := runner.Verify(context.Background())
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, VerifyError, results[0].Status)
+		assert.NotEmpty(t, results[0].Error)
+		assert.Empty(t, DriftedSteps(results))
+	})
+}
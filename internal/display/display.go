@@ -3,6 +3,7 @@ package display
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/SphereStacking/plexr/internal/config"
@@ -27,6 +28,7 @@ const (
 	StatusCompleted StepStatus = "completed"
 	StatusFailed    StepStatus = "failed"
 	StatusSkipped   StepStatus = "skipped"
+	StatusBlocked   StepStatus = "blocked" // A dependency failed
 )
 
 // ExecutionProgress represents the current execution state
@@ -107,19 +109,26 @@ func NewDisplay(mode DisplayMode, verbose bool) Display {
 
 // ProgressTracker provides progress information to displays
 type ProgressTracker struct {
-	plan      *config.ExecutionPlan
-	state     *core.ExecutionState
-	startTime time.Time
-	display   Display
+	plan        *config.ExecutionPlan
+	state       *core.ExecutionState
+	startTime   time.Time
+	display     Display
+	failed      map[string]bool
+	blocked     map[string]bool
+	interrupted map[string]bool
+	ignored     int
 }
 
 // NewProgressTracker creates a new progress tracker
 func NewProgressTracker(plan *config.ExecutionPlan, state *core.ExecutionState, display Display) *ProgressTracker {
 	return &ProgressTracker{
-		plan:      plan,
-		state:     state,
-		startTime: time.Now(),
-		display:   display,
+		plan:        plan,
+		state:       state,
+		startTime:   time.Now(),
+		display:     display,
+		failed:      make(map[string]bool),
+		blocked:     make(map[string]bool),
+		interrupted: make(map[string]bool),
 	}
 }
 
@@ -151,14 +160,40 @@ func (pt *ProgressTracker) StepCompleted(stepID string, duration time.Duration)
 
 // StepFailed notifies that a step has failed
 func (pt *ProgressTracker) StepFailed(stepID string, err error) error {
+	pt.failed[stepID] = true
 	return pt.display.ShowError(stepID, err)
 }
 
+// StepFailureIgnored notifies that a step failed but its failure is ignored
+func (pt *ProgressTracker) StepFailureIgnored(stepID string, err error) error {
+	pt.ignored++
+	return pt.display.UpdateStep(stepID, StatusCompleted, fmt.Sprintf("failure ignored: %v", err))
+}
+
+// StepInterrupted notifies that a step was stopped by a cancellation
+func (pt *ProgressTracker) StepInterrupted(stepID string) error {
+	pt.interrupted[stepID] = true
+	return pt.display.UpdateStep(stepID, StatusFailed, "interrupted")
+}
+
+// StepBlocked notifies that a step will not run because a dependency failed
+func (pt *ProgressTracker) StepBlocked(stepID string, blockedBy string) error {
+	pt.blocked[stepID] = true
+	return pt.display.UpdateStep(stepID, StatusBlocked, fmt.Sprintf("blocked by failed step '%s'", blockedBy))
+}
+
 // StepSkipped notifies that a step was skipped
 func (pt *ProgressTracker) StepSkipped(stepID string, reason string) error {
 	return pt.display.UpdateStep(stepID, StatusSkipped, reason)
 }
 
+// StepRetrying notifies that a failed file is about to be retried
+func (pt *ProgressTracker) StepRetrying(stepID string, file string, attempt, maxAttempts int, delay time.Duration, err error) error {
+	message := fmt.Sprintf("%s failed (attempt %d/%d), retrying in %s: %v",
+		file, attempt, maxAttempts, delay.Round(time.Millisecond), err)
+	return pt.display.UpdateStep(stepID, StatusRunning, message)
+}
+
 // Output shows output from a step
 func (pt *ProgressTracker) Output(stepID string, output string) error {
 	return pt.display.ShowOutput(stepID, output)
@@ -192,6 +227,14 @@ func (pt *ProgressTracker) buildProgress() *ExecutionProgress {
 		Steps:          make([]StepProgress, 0, len(pt.plan.Steps)),
 	}
 
+	// Steps may run concurrently
+	running := make(map[string]bool)
+	if pt.state != nil {
+		for _, stepID := range pt.state.RunningSteps() {
+			running[stepID] = true
+		}
+	}
+
 	// Build step progress
 	for _, step := range pt.plan.Steps {
 		sp := StepProgress{
@@ -214,7 +257,11 @@ func (pt *ProgressTracker) buildProgress() *ExecutionProgress {
 		switch {
 		case completed:
 			sp.Status = StatusCompleted
-		case pt.state != nil && step.ID == pt.state.CurrentStep:
+		case pt.failed[step.ID], pt.interrupted[step.ID]:
+			sp.Status = StatusFailed
+		case pt.blocked[step.ID]:
+			sp.Status = StatusBlocked
+		case running[step.ID]:
 			sp.Status = StatusRunning
 		default:
 			sp.Status = StatusPending
@@ -242,5 +289,23 @@ func (pt *ProgressTracker) buildSummary() string {
 	}
 	total := len(pt.plan.Steps)
 
-	return fmt.Sprintf("Completed %d/%d steps in %s", completed, total, elapsed.Round(time.Second))
+	summary := fmt.Sprintf("Completed %d/%d steps in %s", completed, total, elapsed.Round(time.Second))
+
+	var notes []string
+	if len(pt.failed) > 0 {
+		notes = append(notes, fmt.Sprintf("%d failed", len(pt.failed)))
+	}
+	if len(pt.interrupted) > 0 {
+		notes = append(notes, fmt.Sprintf("%d interrupted", len(pt.interrupted)))
+	}
+	if len(pt.blocked) > 0 {
+		notes = append(notes, fmt.Sprintf("%d blocked", len(pt.blocked)))
+	}
+	if pt.ignored > 0 {
+		notes = append(notes, fmt.Sprintf("%d failure(s) ignored", pt.ignored))
+	}
+	if len(notes) > 0 {
+		summary += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return summary
 }
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/SphereStacking/plexr/internal/utils"
 )
 
 // TerminalDisplay implements Display for simple terminal output
@@ -41,13 +42,14 @@ const (
 	symbolCompleted = "✓"
 	symbolFailed    = "✗"
 	symbolSkipped   = "⊘"
+	symbolBlocked   = "⊗"
 )
 
 // NewTerminalDisplay creates a new terminal display
 func NewTerminalDisplay(verbose bool) *TerminalDisplay {
 	return &TerminalDisplay{
 		verbose:       verbose,
-		writer:        os.Stdout,
+		writer:        utils.NewMaskingWriter(os.Stdout),
 		useColor:      os.Getenv("NO_COLOR") == "",
 		progressWidth: 40,
 	}
@@ -247,6 +249,8 @@ func (td *TerminalDisplay) getStatusSymbol(status StepStatus) string {
 		return symbolFailed
 	case StatusSkipped:
 		return symbolSkipped
+	case StatusBlocked:
+		return symbolBlocked
 	default:
 		return symbolPending
 	}
@@ -260,7 +264,7 @@ func (td *TerminalDisplay) getStatusColor(status StepStatus) string {
 		return td.color(colorGreen)
 	case StatusFailed:
 		return td.color(colorRed)
-	case StatusSkipped:
+	case StatusSkipped, StatusBlocked:
 		return td.color(colorYellow)
 	default:
 		return td.color(colorGray)
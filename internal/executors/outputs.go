@@ -0,0 +1,105 @@
+package executors
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// OutputFileEnv is the environment variable holding the path of the file
+// that shell scripts write their outputs to
+const OutputFileEnv = "PLEXR_OUTPUT"
+
+var (
+	outputKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+	outputRefPattern = regexp.MustCompile(`\{\{\s*steps\.([^.\s{}]+)\.outputs\.([^.\s{}]+)\s*\}\}`)
+	envNameReplacer  = regexp.MustCompile(`[^A-Z0-9]+`)
+)
+
+// OutputEnvName returns the environment variable that exposes an output of a
+// step to later steps, e.g. PLEXR_STEP_CREATE_DB_DB_URL
+func OutputEnvName(stepID, key string) string {
+	name := strings.ToUpper(stepID + "_" + key)
+	return "PLEXR_STEP_" + envNameReplacer.ReplaceAllString(name, "_")
+}
+
+// ExpandOutputRefs replaces {{ steps.<id>.outputs.<key> }} references in s
+// with the values returned by lookup. Unknown references are an error.
+func ExpandOutputRefs(s string, lookup func(stepID, key string) (string, bool)) (string, error) {
+	var missing []string
+	expanded := outputRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		match := outputRefPattern.FindStringSubmatch(ref)
+		value, ok := lookup(match[1], match[2])
+		if !ok {
+			missing = append(missing, fmt.Sprintf("steps.%s.outputs.%s", match[1], match[2]))
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined output: %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// expandEnvOutputRefs expands output references using the PLEXR_STEP_*
+// variables of env
+func expandEnvOutputRefs(s string, env map[string]string) (string, error) {
+	return ExpandOutputRefs(s, func(stepID, key string) (string, bool) {
+		value, ok := env[OutputEnvName(stepID, key)]
+		return value, ok
+	})
+}
+
+// commandEnv builds the environment of a child process: the current process
+// environment followed by the given variables in a stable order
+func commandEnv(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := os.Environ()
+	for _, key := range keys {
+		result = append(result, key+"="+env[key])
+	}
+	return result
+}
+
+// readOutputFile parses the KEY=VALUE lines a script wrote to its output file.
+// Blank lines and lines starting with # are ignored; later keys win.
+func readOutputFile(path string) (map[string]string, error) {
+	f, err := os.Open(path) // #nosec G304 - path is a temporary file created by the executor
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	outputs := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !outputKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid output on line %d: expected KEY=VALUE", lineNo)
+		}
+		outputs[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(outputs) == 0 {
+		return nil, nil
+	}
+	return outputs, nil
+}
@@ -0,0 +1,89 @@
+package executors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputEnvName(t *testing.T) {
+	tests := []struct {
+		stepID string
+		key    string
+		want   string
+	}{
+		{"create_db", "db_url", "PLEXR_STEP_CREATE_DB_DB_URL"},
+		{"setup-node", "version", "PLEXR_STEP_SETUP_NODE_VERSION"},
+		{"build.app", "out-dir", "PLEXR_STEP_BUILD_APP_OUT_DIR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, OutputEnvName(tt.stepID, tt.key))
+		})
+	}
+}
+
+func TestExpandOutputRefs(t *testing.T) {
+	outputs := map[string]map[string]string{
+		"create_db": {"db_url": "postgres://localhost/app", "port": "5432"},
+	}
+	lookup := func(stepID, key string) (string, bool) {
+		value, ok := outputs[stepID][key]
+		return value, ok
+	}
+
+	t.Run("expands references", func(t *testing.T) {
+		got, err := ExpandOutputRefs("psql {{ steps.create_db.outputs.db_url }} -p {{steps.create_db.outputs.port}}", lookup)
+		require.NoError(t, err)
+		assert.Equal(t, "psql postgres://localhost/app -p 5432", got)
+	})
+
+	t.Run("leaves other text alone", func(t *testing.T) {
+		got, err := ExpandOutputRefs("echo {{ .Vars.name }} $HOME", lookup)
+		require.NoError(t, err)
+		assert.Equal(t, "echo {{ .Vars.name }} $HOME", got)
+	})
+
+	t.Run("unknown reference", func(t *testing.T) {
+		_, err := ExpandOutputRefs("{{ steps.create_db.outputs.user }} {{ steps.missing.outputs.x }}", lookup)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "undefined output: steps.create_db.outputs.user, steps.missing.outputs.x")
+	})
+}
+
+func TestReadOutputFile(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "outputs")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	t.Run("parses key value lines", func(t *testing.T) {
+		path := write(t, "# comment\ndb_url=postgres://u:p@host/db?sslmode=disable\n\nempty=\r\nversion = 1.2\nversion=1.3\n")
+		outputs, err := readOutputFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"db_url":  "postgres://u:p@host/db?sslmode=disable",
+			"empty":   "",
+			"version": "1.3",
+		}, outputs)
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		outputs, err := readOutputFile(write(t, ""))
+		require.NoError(t, err)
+		assert.Nil(t, outputs)
+	})
+
+	t.Run("invalid lines", func(t *testing.T) {
+		for _, content := range []string{"no separator\n", "1key=value\n", "bad key=value\n"} {
+			_, err := readOutputFile(write(t, content))
+			assert.Error(t, err, content)
+			assert.Contains(t, err.Error(), "invalid output on line 1")
+		}
+	})
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package executors
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// processGroupGracePeriod is how long a canceled script may take to exit
+// after SIGTERM before its process group is killed
+const processGroupGracePeriod = 5 * time.Second
+
+// configureProcessGroup starts the command in its own process group and makes
+// cancellation terminate the whole group, so child processes are not orphaned
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = processGroupGracePeriod
+}
+
+// killProcessGroup kills any process left in the command's process group
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) // The group is usually gone already
+	}
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+
+package executors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellExecutorCancellation(t *testing.T) {
+	t.Run("Cancel terminates child processes", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		pidFile := filepath.Join(tmpDir, "child.pid")
+		scriptPath := filepath.Join(tmpDir, "spawn.sh")
+		script := "#!/bin/bash\nsleep 30 &\necho $! > " + pidFile + "\nwait\n"
+		require.NoError(t, os.WriteFile(scriptPath, []byte(script), 0755)) // #nosec G306 - Script needs to be executable
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			// Cancel once the background child has been started
+			for i := 0; i < 100; i++ {
+				// The shell creates the file before it writes the PID
+				if info, err := os.Stat(pidFile); err == nil && info.Size() > 0 {
+					break
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			cancel()
+		}()
+
+		executor := NewShellExecutor()
+		start := time.Now()
+		_, err := executor.Execute(ctx, ExecutionFile{Path: scriptPath})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "execution interrupted")
+		assert.Less(t, time.Since(start), processGroupGracePeriod)
+
+		data, err := os.ReadFile(pidFile)
+		require.NoError(t, err)
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		require.NoError(t, err)
+
+		// The child is gone (or at most a zombie about to be reaped by init)
+		assert.Eventually(t, func() bool {
+			err := syscall.Kill(pid, 0)
+			if err == syscall.ESRCH {
+				return true
+			}
+			status, readErr := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+			return readErr == nil && strings.Contains(string(status), ") Z ")
+		}, 2*time.Second, 20*time.Millisecond)
+	})
+}
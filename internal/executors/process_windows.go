@@ -0,0 +1,14 @@
+//go:build windows
+
+package executors
+
+import (
+	"os/exec"
+)
+
+// configureProcessGroup is a no-op on Windows, where canceling the context
+// kills the shell process
+func configureProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup is a no-op on Windows
+func killProcessGroup(cmd *exec.Cmd) {}
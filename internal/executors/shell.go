@@ -3,6 +3,7 @@ package executors
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -17,7 +18,8 @@ type ExecutionFile struct {
 	Retry           int
 	Platform        string
 	WorkDirectory   string
-	TransactionMode string // For SQL executor
+	TransactionMode string            // For SQL executor
+	Env             map[string]string // Additional environment variables, including outputs of earlier steps
 }
 
 // ExecutionResult represents the result of executing a file
@@ -25,12 +27,16 @@ type ExecutionResult struct {
 	Success  bool
 	Output   string
 	Error    error
-	Duration int64 // in milliseconds
+	Duration int64             // in milliseconds
+	ExitCode int               // Process exit code for shell scripts (-1 if the process did not exit normally)
+	SQLState string            // SQLSTATE code reported by the database for failed SQL
+	Outputs  map[string]string // Key/value outputs emitted by the file
 }
 
 // ShellExecutor executes shell scripts
 type ShellExecutor struct {
-	shell string
+	shell    string
+	platform string // Platform that platform-specific files are matched against
 }
 
 // NewShellExecutor creates a new shell executor
@@ -40,10 +46,19 @@ func NewShellExecutor() *ShellExecutor {
 		shell = "powershell.exe"
 	}
 	return &ShellExecutor{
-		shell: shell,
+		shell:    shell,
+		platform: runtime.GOOS,
 	}
 }
 
+// SetPlatform overrides the platform that platform-specific files are matched against
+func (e *ShellExecutor) SetPlatform(platform string) {
+	if platform == "" {
+		platform = runtime.GOOS
+	}
+	e.platform = platform
+}
+
 // Name returns the executor name
 func (e *ShellExecutor) Name() string {
 	return "shell"
@@ -64,10 +79,10 @@ func (e *ShellExecutor) Execute(ctx context.Context, file ExecutionFile) (*Execu
 	start := time.Now()
 
 	// Check platform compatibility
-	if file.Platform != "" && file.Platform != runtime.GOOS {
+	if file.Platform != "" && file.Platform != e.platform {
 		return &ExecutionResult{
 			Success:  true,
-			Output:   fmt.Sprintf("Skipping file %s (platform: %s, current: %s)", file.Path, file.Platform, runtime.GOOS),
+			Output:   fmt.Sprintf("Skipping file %s (platform: %s, current: %s)", file.Path, file.Platform, e.platform),
 			Duration: time.Since(start).Milliseconds(),
 		}, nil
 	}
@@ -101,6 +116,26 @@ func (e *ShellExecutor) Execute(ctx context.Context, file ExecutionFile) (*Execu
 	if file.WorkDirectory != "" {
 		cmd.Dir = file.WorkDirectory
 	}
+	configureProcessGroup(cmd)
+
+	// Scripts emit outputs by appending KEY=VALUE lines to $PLEXR_OUTPUT
+	outputFile, err := os.CreateTemp("", "plexr-output-*")
+	if err != nil {
+		return &ExecutionResult{
+			Success:  false,
+			Error:    err,
+			Duration: time.Since(start).Milliseconds(),
+		}, fmt.Errorf("failed to create output file: %w", err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	env := make(map[string]string, len(file.Env)+1)
+	for key, value := range file.Env {
+		env[key] = value
+	}
+	env[OutputFileEnv] = outputFile.Name()
+	cmd.Env = commandEnv(env)
 
 	// Capture output
 	var stdout, stderr bytes.Buffer
@@ -108,7 +143,10 @@ func (e *ShellExecutor) Execute(ctx context.Context, file ExecutionFile) (*Execu
 	cmd.Stderr = &stderr
 
 	// Execute
-	err := cmd.Run()
+	err = cmd.Run()
+	if execCtx.Err() != nil {
+		killProcessGroup(cmd)
+	}
 
 	output := stdout.String()
 	if stderr.Len() > 0 {
@@ -120,14 +158,83 @@ func (e *ShellExecutor) Execute(ctx context.Context, file ExecutionFile) (*Execu
 		Output:   output,
 		Error:    err,
 		Duration: time.Since(start).Milliseconds(),
+		ExitCode: exitCode(err),
 	}
 
 	if err != nil {
+		if ctx.Err() != nil {
+			return result, fmt.Errorf("execution interrupted: %w", ctx.Err())
+		}
 		if execCtx.Err() == context.DeadlineExceeded {
 			return result, fmt.Errorf("execution timeout after %d seconds", file.Timeout)
 		}
 		return result, fmt.Errorf("execution failed: %w", err)
 	}
 
+	result.Outputs, err = readOutputFile(outputFile.Name())
+	if err != nil {
+		result.Success = false
+		result.Error = err
+		return result, fmt.Errorf("failed to read outputs: %w", err)
+	}
+
+	return result, nil
+}
+
+// exitCode extracts the process exit code from an execution error
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// RunCommand runs an inline command with the executor's shell and the given
+// additional environment variables.
+// A non-zero exit code is reported through the result rather than as an error;
+// an error is only returned when the command could not be run at all.
+func (e *ShellExecutor) RunCommand(ctx context.Context, command string, workDir string, env map[string]string) (*ExecutionResult, error) {
+	start := time.Now()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, e.shell, "-Command", command) // #nosec G204 - command comes from user configuration
+	} else {
+		cmd = exec.CommandContext(ctx, e.shell, "-c", command) // #nosec G204 - command comes from user configuration
+	}
+	if workDir != "" {
+		cmd.Dir = workDir
+	}
+	if len(env) > 0 {
+		cmd.Env = commandEnv(env)
+	}
+	configureProcessGroup(cmd)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		killProcessGroup(cmd)
+	}
+	result := &ExecutionResult{
+		Success:  err == nil,
+		Output:   output.String(),
+		Error:    err,
+		Duration: time.Since(start).Milliseconds(),
+		ExitCode: exitCode(err),
+	}
+
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return result, fmt.Errorf("failed to run command: %w", err)
+		}
+	}
 	return result, nil
 }
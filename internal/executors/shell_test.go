@@ -80,11 +80,12 @@ func TestShellExecutor(t *testing.T) {
 
 	t.Run("Execute scripts with different outcomes", func(t *testing.T) {
 		tests := []struct {
-			name           string
-			script         string
-			expectedOutput string
-			expectedError  bool
-			checkDuration  bool
+			name             string
+			script           string
+			expectedOutput   string
+			expectedError    bool
+			expectedExitCode int
+			checkDuration    bool
 		}{
 			{
 				name: "successful execution with output",
@@ -101,9 +102,20 @@ exit 0`,
 				script: `#!/bin/bash
 echo "Error: Something went wrong" >&2
 exit 1`,
-				expectedOutput: "Error: Something went wrong",
-				expectedError:  true,
-				checkDuration:  true,
+				expectedOutput:   "Error: Something went wrong",
+				expectedError:    true,
+				expectedExitCode: 1,
+				checkDuration:    true,
+			},
+			{
+				name: "script with custom exit code",
+				script: `#!/bin/bash
+echo "Temporary failure"
+exit 75`,
+				expectedOutput:   "Temporary failure",
+				expectedError:    true,
+				expectedExitCode: 75,
+				checkDuration:    true,
 			},
 			{
 				name: "script with both stdout and stderr",
@@ -153,6 +165,7 @@ echo "PWD=$PWD"`,
 				}
 
 				assert.Contains(t, result.Output, tt.expectedOutput)
+				assert.Equal(t, tt.expectedExitCode, result.ExitCode)
 
 				if tt.checkDuration {
 					assert.GreaterOrEqual(t, result.Duration, int64(0))
@@ -322,6 +335,25 @@ ver`,
 		}
 	})
 
+	t.Run("Platform override", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		scriptPath := filepath.Join(tmpDir, "script.sh")
+		err := os.WriteFile(scriptPath, []byte("#!/bin/bash\necho ran"), 0755) // #nosec G306 - Script needs to be executable
+		require.NoError(t, err)
+
+		executor := NewShellExecutor()
+		executor.SetPlatform("plan9")
+
+		result, err := executor.Execute(context.Background(), ExecutionFile{Path: scriptPath, Platform: runtime.GOOS})
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.Contains(t, result.Output, "Skipping file")
+		assert.Contains(t, result.Output, "current: plan9")
+
+		executor.SetPlatform("")
+		assert.Equal(t, runtime.GOOS, executor.platform)
+	})
+
 	t.Run("Error cases", func(t *testing.T) {
 		executor := NewShellExecutor()
 		ctx := context.Background()
@@ -402,6 +434,57 @@ done`
 		assert.NotContains(t, result.Output, "Step 10")
 	})
 
+	t.Run("RunCommand", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("Skipping shell command test on Windows")
+		}
+
+		executor := NewShellExecutor()
+		tmpDir := t.TempDir()
+
+		result, err := executor.RunCommand(context.Background(), "pwd && exit 0", tmpDir, nil)
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.Contains(t, result.Output, filepath.Base(tmpDir))
+
+		result, err = executor.RunCommand(context.Background(), "echo missing >&2; exit 3", "", nil)
+		require.NoError(t, err)
+		assert.False(t, result.Success)
+		assert.Equal(t, 3, result.ExitCode)
+		assert.Contains(t, result.Output, "missing")
+	})
+
+	t.Run("Outputs and environment", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("Skipping shell script test on Windows")
+		}
+
+		tmpDir := t.TempDir()
+		scriptPath := filepath.Join(tmpDir, "outputs.sh")
+		script := `#!/bin/bash
+echo "db_url=$PLEXR_STEP_CREATE_DB_HOST/app" >> "$PLEXR_OUTPUT"
+echo "user=admin" >> "$PLEXR_OUTPUT"`
+		require.NoError(t, os.WriteFile(scriptPath, []byte(script), 0755)) // #nosec G306 - Script needs to be executable
+
+		executor := NewShellExecutor()
+		result, err := executor.Execute(context.Background(), ExecutionFile{
+			Path: scriptPath,
+			Env:  map[string]string{"PLEXR_STEP_CREATE_DB_HOST": "localhost"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"db_url": "localhost/app", "user": "admin"}, result.Outputs)
+
+		result, err = executor.RunCommand(context.Background(), `test "$GREETING" = hello`, "", map[string]string{"GREETING": "hello"})
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+
+		require.NoError(t, os.WriteFile(scriptPath, []byte("echo 'not an output' >> \"$PLEXR_OUTPUT\""), 0755)) // #nosec G306 - Script needs to be executable
+		result, err = executor.Execute(context.Background(), ExecutionFile{Path: scriptPath})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to read outputs")
+		assert.False(t, result.Success)
+	})
+
 	t.Run("Script with large output", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		scriptPath := filepath.Join(tmpDir, "large_output.sh")
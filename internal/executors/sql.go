@@ -3,12 +3,15 @@ package executors
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/SphereStacking/plexr/internal/utils"
+	"github.com/lib/pq" // PostgreSQL driver
 	"github.com/mitchellh/mapstructure"
 )
 
@@ -16,6 +19,8 @@ import (
 type SQLExecutor struct {
 	config SQLConfig
 	db     *sql.DB
+	// dbMu guards db, as steps sharing the executor may run concurrently
+	dbMu sync.Mutex
 }
 
 // SQLConfig represents the configuration for SQL executor
@@ -95,14 +100,13 @@ func (e *SQLExecutor) Execute(ctx context.Context, file ExecutionFile) (*Executi
 	start := time.Now()
 
 	// Connect to database if not connected
-	if e.db == nil {
-		if err := e.connect(); err != nil {
-			return &ExecutionResult{
-				Success:  false,
-				Error:    err,
-				Duration: time.Since(start).Milliseconds(),
-			}, nil
-		}
+	if err := e.ensureConnected(); err != nil {
+		return &ExecutionResult{
+			Success:  false,
+			Error:    err,
+			Duration: time.Since(start).Milliseconds(),
+			SQLState: sqlState(err),
+		}, nil
 	}
 
 	// Determine transaction mode
@@ -128,17 +132,33 @@ func (e *SQLExecutor) Execute(ctx context.Context, file ExecutionFile) (*Executi
 		}, nil
 	}
 
-	// Expand environment variables
-	sqlContent := os.ExpandEnv(string(content))
+	// Expand step output references and environment variables
+	sqlContent, err := expandEnvOutputRefs(string(content), file.Env)
+	if err != nil {
+		return &ExecutionResult{
+			Success:  false,
+			Error:    err,
+			Duration: time.Since(start).Milliseconds(),
+		}, nil
+	}
+	sqlContent, err = utils.ExpandEnv(sqlContent, utils.LookupEnv(file.Env))
+	if err != nil {
+		return &ExecutionResult{
+			Success:  false,
+			Error:    fmt.Errorf("failed to expand SQL file: %w", err),
+			Duration: time.Since(start).Milliseconds(),
+		}, nil
+	}
 
 	// Execute SQL
 	var output string
+	var outputs map[string]string
 	var execErr error
 
 	if useTransaction {
-		output, execErr = e.executeInTransaction(ctx, sqlContent)
+		output, outputs, execErr = e.executeInTransaction(ctx, sqlContent)
 	} else {
-		output, execErr = e.executeDirect(ctx, sqlContent)
+		output, outputs, execErr = e.executeDirect(ctx, sqlContent)
 	}
 
 	if execErr != nil {
@@ -147,6 +167,7 @@ func (e *SQLExecutor) Execute(ctx context.Context, file ExecutionFile) (*Executi
 			Error:    execErr,
 			Output:   output,
 			Duration: time.Since(start).Milliseconds(),
+			SQLState: sqlState(execErr),
 		}, nil
 	}
 
@@ -154,9 +175,35 @@ func (e *SQLExecutor) Execute(ctx context.Context, file ExecutionFile) (*Executi
 		Success:  true,
 		Output:   output,
 		Duration: time.Since(start).Milliseconds(),
+		Outputs:  outputs,
 	}, nil
 }
 
+// OpenDatabase connects to the database of a SQL executor configuration,
+// so that the executor's connection settings can be reused elsewhere
+func OpenDatabase(config map[string]interface{}) (*sql.DB, error) {
+	executor := NewSQLExecutor()
+	if err := executor.Validate(config); err != nil {
+		return nil, err
+	}
+	if err := executor.connect(); err != nil {
+		return nil, err
+	}
+	return executor.db, nil
+}
+
+// ensureConnected connects to the database on first use. A failed
+// connection is attempted again by the next call.
+func (e *SQLExecutor) ensureConnected() error {
+	e.dbMu.Lock()
+	defer e.dbMu.Unlock()
+
+	if e.db == nil {
+		return e.connect()
+	}
+	return nil
+}
+
 // connect establishes a database connection
 func (e *SQLExecutor) connect() error {
 	dsn := e.buildDSN()
@@ -180,80 +227,171 @@ func (e *SQLExecutor) connect() error {
 
 // buildDSN builds PostgreSQL connection string
 func (e *SQLExecutor) buildDSN() string {
-	// Expand environment variables in password
-	password := os.ExpandEnv(e.config.Password)
-
-	// Build DSN
+	// Environment variables are expanded when the plan is loaded; the
+	// password is used as given, as secrets may contain any character
 	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		e.config.Host,
+		dsnValue(e.config.Host),
 		e.config.Port,
-		e.config.Username,
-		password,
-		e.config.Database,
-		e.config.SSLMode,
+		dsnValue(e.config.Username),
+		dsnValue(e.config.Password),
+		dsnValue(e.config.Database),
+		dsnValue(e.config.SSLMode),
 	)
 
 	return dsn
 }
 
+// dsnValue quotes a connection string value if it is empty or contains
+// spaces, quotes or backslashes
+func dsnValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t'\\") {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// sqlRunner is implemented by both *sql.DB and *sql.Tx
+type sqlRunner interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
 // executeDirect executes SQL without transaction
-func (e *SQLExecutor) executeDirect(ctx context.Context, sqlContent string) (string, error) {
+func (e *SQLExecutor) executeDirect(ctx context.Context, sqlContent string) (string, map[string]string, error) {
+	return runStatements(ctx, e.db, sqlContent)
+}
+
+// executeInTransaction executes SQL within a transaction
+func (e *SQLExecutor) executeInTransaction(ctx context.Context, sqlContent string) (string, map[string]string, error) {
+	tx, err := e.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // Will be no-op if committed
+	}()
+
+	output, outputs, err := runStatements(ctx, tx, sqlContent)
+	if err != nil {
+		return output, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return output, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return output, outputs, nil
+}
+
+// runStatements executes SQL statements one by one. The columns of the first
+// row returned by a query become outputs; later queries override earlier ones.
+func runStatements(ctx context.Context, runner sqlRunner, sqlContent string) (string, map[string]string, error) {
 	// Split SQL statements by semicolon
 	statements := splitSQLStatements(sqlContent)
 
 	var results []string
+	var outputs map[string]string
 	for i, stmt := range statements {
 		stmt = strings.TrimSpace(stmt)
 		if stmt == "" {
 			continue
 		}
 
-		result, err := e.db.ExecContext(ctx, stmt)
+		if isQuery(stmt) {
+			row, count, err := queryFirstRow(ctx, runner, stmt)
+			if err != nil {
+				return strings.Join(results, "\n"), nil, fmt.Errorf("statement %d failed: %w", i+1, err)
+			}
+			if row != nil {
+				if outputs == nil {
+					outputs = make(map[string]string)
+				}
+				for key, value := range row {
+					outputs[key] = value
+				}
+			}
+			results = append(results, fmt.Sprintf("Statement %d: %d rows returned", i+1, count))
+			continue
+		}
+
+		result, err := runner.ExecContext(ctx, stmt)
 		if err != nil {
-			return strings.Join(results, "\n"), fmt.Errorf("statement %d failed: %w", i+1, err)
+			return strings.Join(results, "\n"), nil, fmt.Errorf("statement %d failed: %w", i+1, err)
 		}
 
 		rowsAffected, _ := result.RowsAffected()
 		results = append(results, fmt.Sprintf("Statement %d: %d rows affected", i+1, rowsAffected))
 	}
 
-	return strings.Join(results, "\n"), nil
+	return strings.Join(results, "\n"), outputs, nil
 }
 
-// executeInTransaction executes SQL within a transaction
-func (e *SQLExecutor) executeInTransaction(ctx context.Context, sqlContent string) (string, error) {
-	tx, err := e.db.BeginTx(ctx, nil)
+// isQuery reports whether a statement returns rows
+func isQuery(stmt string) bool {
+	fields := strings.Fields(stmt)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH", "SHOW", "VALUES":
+		return true
+	}
+	return false
+}
+
+// queryFirstRow runs a query and returns its first row keyed by column name
+// together with the number of rows returned
+func queryFirstRow(ctx context.Context, runner sqlRunner, query string) (map[string]string, int, error) {
+	rows, err := runner.QueryContext(ctx, query)
 	if err != nil {
-		return "", fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, 0, err
 	}
-	defer func() {
-		_ = tx.Rollback() // Will be no-op if committed
-	}()
+	defer rows.Close()
 
-	// Split SQL statements
-	statements := splitSQLStatements(sqlContent)
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, 0, err
+	}
 
-	var results []string
-	for i, stmt := range statements {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" {
+	var first map[string]string
+	count := 0
+	for rows.Next() {
+		count++
+		if first != nil {
 			continue
 		}
 
-		result, err := tx.ExecContext(ctx, stmt)
-		if err != nil {
-			return strings.Join(results, "\n"), fmt.Errorf("statement %d failed: %w", i+1, err)
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, count, err
 		}
 
-		rowsAffected, _ := result.RowsAffected()
-		results = append(results, fmt.Sprintf("Statement %d: %d rows affected", i+1, rowsAffected))
+		first = make(map[string]string, len(columns))
+		for i, column := range columns {
+			first[column] = formatSQLValue(values[i])
+		}
 	}
+	return first, count, rows.Err()
+}
 
-	if err := tx.Commit(); err != nil {
-		return strings.Join(results, "\n"), fmt.Errorf("failed to commit transaction: %w", err)
+// formatSQLValue converts a scanned column value to its output representation
+func formatSQLValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
 	}
-
-	return strings.Join(results, "\n"), nil
 }
 
 // splitSQLStatements splits SQL content into individual statements
@@ -279,10 +417,24 @@ func splitSQLStatements(sqlContent string) []string {
 	return filtered
 }
 
+// sqlState extracts the SQLSTATE code from a database error
+func sqlState(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	return ""
+}
+
 // Close closes the database connection
 func (e *SQLExecutor) Close() error {
+	e.dbMu.Lock()
+	defer e.dbMu.Unlock()
+
 	if e.db != nil {
-		return e.db.Close()
+		err := e.db.Close()
+		e.db = nil
+		return err
 	}
 	return nil
 }
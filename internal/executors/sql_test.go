@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SphereStacking/plexr/internal/config"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -143,22 +146,54 @@ func TestSQLExecutor(t *testing.T) {
 	})
 
 	t.Run("buildDSN with environment variable", func(t *testing.T) {
-		os.Setenv("TEST_DB_PASSWORD", "secret123")
-		defer os.Unsetenv("TEST_DB_PASSWORD")
+		t.Setenv("TEST_DB_PASSWORD", "secret123")
+
+		// Environment variables are expanded when the plan is loaded
+		planFile := filepath.Join(t.TempDir(), "plan.yml")
+		plan := `
+name: "SQL plan"
+version: "1.0.0"
+executors:
+  db:
+    type: sql
+    driver: postgres
+    host: localhost
+    username: testuser
+    password: ${TEST_DB_PASSWORD}
+    database: testdb
+    sslmode: require
+steps:
+  - id: migrate
+    executor: db
+    files:
+      - path: migrate.sql
+`
+		require.NoError(t, os.WriteFile(planFile, []byte(plan), 0600)) // #nosec G306 - Test file
+		loaded, err := config.LoadExecutionPlan(planFile)
+		require.NoError(t, err)
+
+		executor := NewSQLExecutor()
+		require.NoError(t, executor.Validate(loaded.Executors["db"]))
 
+		dsn := executor.buildDSN()
+		expected := "host=localhost port=5432 user=testuser password=secret123 dbname=testdb sslmode=require"
+		assert.Equal(t, expected, dsn)
+	})
+
+	t.Run("buildDSN quotes special characters", func(t *testing.T) {
 		executor := &SQLExecutor{
 			config: SQLConfig{
 				Host:     "localhost",
 				Port:     5432,
 				Username: "testuser",
-				Password: "${TEST_DB_PASSWORD}",
+				Password: `it's \o/ secret`,
 				Database: "testdb",
 				SSLMode:  "require",
 			},
 		}
 
 		dsn := executor.buildDSN()
-		expected := "host=localhost port=5432 user=testuser password=secret123 dbname=testdb sslmode=require"
+		expected := `host=localhost port=5432 user=testuser password='it\'s \\o/ secret' dbname=testdb sslmode=require`
 		assert.Equal(t, expected, dsn)
 	})
 
@@ -366,6 +401,45 @@ func TestSQLExecutorWithMock(t *testing.T) {
 		assert.NoError(t, err)
 	})
 
+	t.Run("Execute reports SQLSTATE", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		executor := &SQLExecutor{
+			config: SQLConfig{
+				Driver:   "postgres",
+				Host:     "localhost",
+				Port:     5432,
+				Database: "testdb",
+				Username: "testuser",
+			},
+			db: db,
+		}
+
+		tmpFile, err := os.CreateTemp("", "test*.sql")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+
+		_, err = tmpFile.WriteString("UPDATE accounts SET balance = 0")
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		mock.ExpectExec("UPDATE accounts").
+			WillReturnError(&pq.Error{Code: "40001", Message: "could not serialize access"})
+
+		result, err := executor.Execute(context.Background(), ExecutionFile{
+			Path: tmpFile.Name(),
+		})
+
+		assert.NoError(t, err)
+		assert.False(t, result.Success)
+		assert.Equal(t, "40001", result.SQLState)
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err)
+	})
+
 	t.Run("Execute with nonexistent file", func(t *testing.T) {
 		// Create executor with valid config to avoid connection error
 		executor := &SQLExecutor{
@@ -417,7 +491,7 @@ func TestSQLExecutorTransactions(t *testing.T) {
 
 		// Execute
 		sql := "INSERT INTO users (name) VALUES ('test'); UPDATE users SET active = true WHERE id = 1"
-		output, err := executor.executeInTransaction(context.Background(), sql)
+		output, _, err := executor.executeInTransaction(context.Background(), sql)
 
 		// Verify
 		assert.NoError(t, err)
@@ -446,7 +520,7 @@ func TestSQLExecutorTransactions(t *testing.T) {
 
 		// Execute
 		sql := "INSERT INTO users (name) VALUES ('test'); INSERT INTO invalid_table VALUES (1)"
-		output, err := executor.executeInTransaction(context.Background(), sql)
+		output, _, err := executor.executeInTransaction(context.Background(), sql)
 
 		// Verify
 		assert.Error(t, err)
@@ -458,6 +532,147 @@ func TestSQLExecutorTransactions(t *testing.T) {
 	})
 }
 
+func TestSQLExecutorOutputs(t *testing.T) {
+	t.Run("SELECT results become outputs", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		executor := &SQLExecutor{db: db}
+
+		tmpFile, err := os.CreateTemp("", "test*.sql")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+		_, err = tmpFile.WriteString(`INSERT INTO tenants (name) VALUES ('{{ steps.setup.outputs.tenant }}');
+SELECT id AS tenant_id, name, deleted_at FROM tenants WHERE name = '${TENANT}'`)
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		mock.ExpectExec("INSERT INTO tenants \\(name\\) VALUES \\('acme'\\)").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT id AS tenant_id, name, deleted_at FROM tenants WHERE name = 'acme'").
+			WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "name", "deleted_at"}).
+				AddRow(42, []byte("acme"), nil).
+				AddRow(43, []byte("acme"), nil))
+
+		result, err := executor.Execute(context.Background(), ExecutionFile{
+			Path: tmpFile.Name(),
+			Env: map[string]string{
+				"PLEXR_STEP_SETUP_TENANT": "acme",
+				"TENANT":                  "acme",
+			},
+		})
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.Contains(t, result.Output, "Statement 2: 2 rows returned")
+		assert.Equal(t, map[string]string{"tenant_id": "42", "name": "acme", "deleted_at": ""}, result.Outputs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("outputs are discarded when the transaction fails", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		executor := &SQLExecutor{db: db}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT 1 AS ready").
+			WillReturnRows(sqlmock.NewRows([]string{"ready"}).AddRow(1))
+		mock.ExpectExec("DROP TABLE users").
+			WillReturnError(fmt.Errorf("permission denied"))
+		mock.ExpectRollback()
+
+		output, outputs, err := executor.executeInTransaction(context.Background(), "SELECT 1 AS ready; DROP TABLE users")
+		assert.Error(t, err)
+		assert.Nil(t, outputs)
+		assert.Contains(t, output, "Statement 1: 1 rows returned")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("undefined output reference", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		executor := &SQLExecutor{db: db}
+
+		tmpFile, err := os.CreateTemp("", "test*.sql")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+		_, err = tmpFile.WriteString("SELECT '{{ steps.setup.outputs.tenant }}'")
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		result, err := executor.Execute(context.Background(), ExecutionFile{Path: tmpFile.Name()})
+		require.NoError(t, err)
+		assert.False(t, result.Success)
+		assert.Contains(t, result.Error.Error(), "undefined output: steps.setup.outputs.tenant")
+	})
+}
+
+func TestSQLExecutorEnvExpansion(t *testing.T) {
+	writeSQL := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "test.sql")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	t.Run("references and defaults are expanded", func(t *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		defer db.Close()
+
+		executor := &SQLExecutor{db: db}
+		t.Setenv("TENANT", "acme")
+
+		// Dollar quoting and $NAME are not environment references
+		mock.ExpectExec("UPDATE settings SET schema = 'public', note = $$costs $5$$ WHERE tenant = 'acme' AND owner = '$USER'").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		result, err := executor.Execute(context.Background(), ExecutionFile{
+			Path: writeSQL(t, "UPDATE settings SET schema = '${SCHEMA:-public}', note = $$costs $5$$ WHERE tenant = '${TENANT}' AND owner = '$USER'"),
+		})
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("file environment takes precedence", func(t *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		defer db.Close()
+
+		executor := &SQLExecutor{db: db}
+		t.Setenv("SCHEMA", "public")
+
+		mock.ExpectExec("CREATE SCHEMA tenant_acme").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		result, err := executor.Execute(context.Background(), ExecutionFile{
+			Path: writeSQL(t, "CREATE SCHEMA ${SCHEMA}"),
+			Env:  map[string]string{"SCHEMA": "tenant_acme"},
+		})
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("required variable", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		executor := &SQLExecutor{db: db}
+
+		result, err := executor.Execute(context.Background(), ExecutionFile{
+			Path: writeSQL(t, "CREATE SCHEMA ${PLEXR_TEST_SCHEMA:?schema name required}"),
+		})
+		require.NoError(t, err)
+		assert.False(t, result.Success)
+		assert.Contains(t, result.Error.Error(), "PLEXR_TEST_SCHEMA: schema name required")
+	})
+}
+
 // Helper function to test database connection
 func TestSQLExecutorConnection(t *testing.T) {
 	t.Run("connect with invalid credentials", func(t *testing.T) {
@@ -477,4 +692,8 @@ func TestSQLExecutorConnection(t *testing.T) {
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "failed to connect to database")
 	})
+	t.Run("OpenDatabase validates the configuration", func(t *testing.T) {
+		_, err := OpenDatabase(map[string]interface{}{"type": "sql", "driver": "postgres", "database": "testdb"})
+		assert.ErrorContains(t, err, "host is required")
+	})
 }
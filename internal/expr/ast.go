@@ -0,0 +1,264 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// value is the runtime value of an expression node
+type value struct {
+	typ Type
+	b   bool
+	s   string
+}
+
+func boolValue(b bool) value {
+	return value{typ: TypeBool, b: b}
+}
+
+func stringValue(s string) value {
+	return value{typ: TypeString, s: s}
+}
+
+// node is a node of the expression tree
+type node interface {
+	check(scope Scope) (Type, error)
+	eval(ctx *Context) (value, error)
+}
+
+// literalNode is a boolean or string literal
+type literalNode struct {
+	value value
+}
+
+func (n *literalNode) check(scope Scope) (Type, error) {
+	return n.value.typ, nil
+}
+
+func (n *literalNode) eval(ctx *Context) (value, error) {
+	return n.value, nil
+}
+
+// nameKind identifies what a name refers to
+type nameKind int
+
+const (
+	nameOS nameKind = iota
+	nameArch
+	nameEnv
+	nameVar
+	nameStep
+)
+
+// nameNode is a reference to a built-in value, an environment variable,
+// a plan variable or a step
+type nameNode struct {
+	kind nameKind
+	name string
+	pos  int
+}
+
+func newNameNode(tok token) (node, error) {
+	switch {
+	case tok.text == "os":
+		return &nameNode{kind: nameOS, name: tok.text, pos: tok.pos}, nil
+	case tok.text == "arch":
+		return &nameNode{kind: nameArch, name: tok.text, pos: tok.pos}, nil
+	case strings.HasPrefix(tok.text, "env."):
+		name := strings.TrimPrefix(tok.text, "env.")
+		if name == "" || strings.Contains(name, ".") {
+			return nil, fmt.Errorf("invalid environment variable reference '%s' at position %d", tok.text, tok.pos)
+		}
+		return &nameNode{kind: nameEnv, name: name, pos: tok.pos}, nil
+	case strings.HasPrefix(tok.text, "vars."):
+		name := strings.TrimPrefix(tok.text, "vars.")
+		if name == "" || strings.Contains(name, ".") {
+			return nil, fmt.Errorf("invalid variable reference '%s' at position %d", tok.text, tok.pos)
+		}
+		return &nameNode{kind: nameVar, name: name, pos: tok.pos}, nil
+	case strings.Contains(tok.text, "."):
+		return nil, fmt.Errorf("unknown name '%s' at position %d", tok.text, tok.pos)
+	}
+	return &nameNode{kind: nameStep, name: tok.text, pos: tok.pos}, nil
+}
+
+func (n *nameNode) check(scope Scope) (Type, error) {
+	switch n.kind {
+	case nameVar:
+		if scope.Vars != nil && !scope.Vars[n.name] {
+			return "", fmt.Errorf("undefined variable '%s' at position %d", n.name, n.pos)
+		}
+		return TypeString, nil
+	case nameStep:
+		if scope.Steps != nil && !scope.Steps[n.name] {
+			return "", fmt.Errorf("unknown step or name '%s' at position %d", n.name, n.pos)
+		}
+		return TypeBool, nil
+	}
+	return TypeString, nil
+}
+
+func (n *nameNode) eval(ctx *Context) (value, error) {
+	switch n.kind {
+	case nameOS:
+		return stringValue(ctx.OS), nil
+	case nameArch:
+		return stringValue(ctx.Arch), nil
+	case nameEnv:
+		v, _ := ctx.lookupEnv(n.name)
+		return stringValue(v), nil
+	case nameVar:
+		v, ok := ctx.Vars[n.name]
+		if !ok {
+			return value{}, fmt.Errorf("undefined variable '%s'", n.name)
+		}
+		return stringValue(v), nil
+	}
+	return boolValue(ctx.stepCompleted(n.name)), nil
+}
+
+// notNode negates a boolean
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) check(scope Scope) (Type, error) {
+	t, err := n.operand.check(scope)
+	if err != nil {
+		return "", err
+	}
+	if t != TypeBool {
+		return "", fmt.Errorf("operator '!' requires a bool, got %s", t)
+	}
+	return TypeBool, nil
+}
+
+func (n *notNode) eval(ctx *Context) (value, error) {
+	v, err := n.operand.eval(ctx)
+	if err != nil {
+		return value{}, err
+	}
+	return boolValue(!v.b), nil
+}
+
+// logicalNode combines two booleans with && or ||, short-circuiting the right side
+type logicalNode struct {
+	op          tokenKind
+	left, right node
+}
+
+func (n *logicalNode) operator() string {
+	if n.op == tokAnd {
+		return "&&"
+	}
+	return "||"
+}
+
+func (n *logicalNode) check(scope Scope) (Type, error) {
+	for _, operand := range []node{n.left, n.right} {
+		t, err := operand.check(scope)
+		if err != nil {
+			return "", err
+		}
+		if t != TypeBool {
+			return "", fmt.Errorf("operator '%s' requires bools, got %s", n.operator(), t)
+		}
+	}
+	return TypeBool, nil
+}
+
+func (n *logicalNode) eval(ctx *Context) (value, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return value{}, err
+	}
+	if n.op == tokAnd && !left.b {
+		return boolValue(false), nil
+	}
+	if n.op == tokOr && left.b {
+		return boolValue(true), nil
+	}
+	return n.right.eval(ctx)
+}
+
+// compareNode compares two values of the same type
+type compareNode struct {
+	op          tokenKind
+	left, right node
+}
+
+func (n *compareNode) check(scope Scope) (Type, error) {
+	left, err := n.left.check(scope)
+	if err != nil {
+		return "", err
+	}
+	right, err := n.right.check(scope)
+	if err != nil {
+		return "", err
+	}
+	if left != right {
+		return "", fmt.Errorf("cannot compare %s with %s", left, right)
+	}
+	return TypeBool, nil
+}
+
+func (n *compareNode) eval(ctx *Context) (value, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return value{}, err
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return value{}, err
+	}
+	if left.typ != right.typ {
+		return value{}, fmt.Errorf("cannot compare %s with %s", left.typ, right.typ)
+	}
+
+	equal := left == right
+	if n.op == tokNeq {
+		return boolValue(!equal), nil
+	}
+	return boolValue(equal), nil
+}
+
+// callNode calls a built-in function
+type callNode struct {
+	name string
+	fn   function
+	args []node
+	pos  int
+}
+
+func (n *callNode) check(scope Scope) (Type, error) {
+	for i, arg := range n.args {
+		t, err := arg.check(scope)
+		if err != nil {
+			return "", err
+		}
+		if t != n.fn.args[i] {
+			return "", fmt.Errorf("argument %d of '%s' must be a %s, got %s", i+1, n.name, n.fn.args[i], t)
+		}
+	}
+	return n.fn.result, nil
+}
+
+func (n *callNode) eval(ctx *Context) (value, error) {
+	args := make([]string, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(ctx)
+		if err != nil {
+			return value{}, err
+		}
+		if v.typ != TypeString {
+			return value{}, fmt.Errorf("argument %d of '%s' must be a string, got %s", i+1, n.name, v.typ)
+		}
+		args[i] = v.s
+	}
+
+	result, err := n.fn.call(ctx, args)
+	if err != nil {
+		return value{}, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,214 @@
+// Package expr implements the small, sandboxed expression language used by
+// skip_if conditions.
+//
+// An expression combines values with the boolean operators !, && and ||,
+// compares strings with == and !=, and groups with parentheses:
+//
+//	os == "darwin" && !file_exists("/usr/local/bin/brew")
+//	env.CI == "true" || command("docker info")
+//	install_tools
+//
+// Available values:
+//
+//	true, false         boolean literals
+//	"text", 'text'      string literals
+//	os, arch            target operating system and architecture
+//	env.NAME            environment variable (empty string if unset)
+//	vars.NAME           plan variable
+//	<step id>           true if the step has been completed
+//
+// Available functions:
+//
+//	env(name)           environment variable (empty string if unset)
+//	has_env(name)       true if the environment variable is set
+//	file_exists(path)   true if path exists and is not a directory
+//	dir_exists(path)    true if path is an existing directory
+//	command(cmd)        true if the shell command exits with status 0
+//	completed(step)     true if the step has been completed
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Type is the static type of an expression
+type Type string
+
+const (
+	TypeBool   Type = "bool"
+	TypeString Type = "string"
+)
+
+// Expr is a parsed expression
+type Expr struct {
+	source string
+	root   node
+}
+
+// Scope describes the names known when an expression is type-checked.
+// A nil map disables the existence check for that kind of name.
+type Scope struct {
+	Steps map[string]bool // Known step IDs
+	Vars  map[string]bool // Known plan variables
+}
+
+// Context supplies the values an expression is evaluated against
+type Context struct {
+	OS            string
+	Arch          string
+	Vars          map[string]string
+	LookupEnv     func(name string) (string, bool)
+	StepCompleted func(stepID string) bool
+	FileExists    func(path string) bool
+	DirExists     func(path string) bool
+	RunCommand    func(command string) (bool, error)
+}
+
+// function describes a built-in function
+type function struct {
+	args   []Type
+	result Type
+	call   func(ctx *Context, args []string) (value, error)
+}
+
+var functions = map[string]function{
+	"env": {
+		args:   []Type{TypeString},
+		result: TypeString,
+		call: func(ctx *Context, args []string) (value, error) {
+			v, _ := ctx.lookupEnv(args[0])
+			return stringValue(v), nil
+		},
+	},
+	"has_env": {
+		args:   []Type{TypeString},
+		result: TypeBool,
+		call: func(ctx *Context, args []string) (value, error) {
+			_, ok := ctx.lookupEnv(args[0])
+			return boolValue(ok), nil
+		},
+	},
+	"file_exists": {
+		args:   []Type{TypeString},
+		result: TypeBool,
+		call: func(ctx *Context, args []string) (value, error) {
+			if ctx.FileExists == nil {
+				return value{}, fmt.Errorf("file_exists is not available")
+			}
+			return boolValue(ctx.FileExists(args[0])), nil
+		},
+	},
+	"dir_exists": {
+		args:   []Type{TypeString},
+		result: TypeBool,
+		call: func(ctx *Context, args []string) (value, error) {
+			if ctx.DirExists == nil {
+				return value{}, fmt.Errorf("dir_exists is not available")
+			}
+			return boolValue(ctx.DirExists(args[0])), nil
+		},
+	},
+	"command": {
+		args:   []Type{TypeString},
+		result: TypeBool,
+		call: func(ctx *Context, args []string) (value, error) {
+			if ctx.RunCommand == nil {
+				return value{}, fmt.Errorf("command is not available")
+			}
+			ok, err := ctx.RunCommand(args[0])
+			return boolValue(ok), err
+		},
+	},
+	"completed": {
+		args:   []Type{TypeString},
+		result: TypeBool,
+		call: func(ctx *Context, args []string) (value, error) {
+			return boolValue(ctx.stepCompleted(args[0])), nil
+		},
+	},
+}
+
+// Parse parses an expression
+func Parse(source string) (*Expr, error) {
+	p := &parser{lexer: newLexer(source)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokEOF {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", p.tok, p.tok.pos)
+	}
+
+	return &Expr{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Check type-checks the expression and verifies that it yields a boolean
+func (e *Expr) Check(scope Scope) error {
+	t, err := e.root.check(scope)
+	if err != nil {
+		return err
+	}
+	if t != TypeBool {
+		return fmt.Errorf("expression must be a bool, got %s", t)
+	}
+	return nil
+}
+
+// Eval evaluates the expression
+func (e *Expr) Eval(ctx *Context) (bool, error) {
+	v, err := e.root.eval(ctx)
+	if err != nil {
+		return false, err
+	}
+	if v.typ != TypeBool {
+		return false, fmt.Errorf("expression must be a bool, got %s", v.typ)
+	}
+	return v.b, nil
+}
+
+// Evaluate parses and evaluates an expression in one go
+func Evaluate(source string, ctx *Context) (bool, error) {
+	e, err := Parse(source)
+	if err != nil {
+		return false, err
+	}
+	return e.Eval(ctx)
+}
+
+func (ctx *Context) lookupEnv(name string) (string, bool) {
+	if ctx.LookupEnv == nil {
+		return "", false
+	}
+	return ctx.LookupEnv(name)
+}
+
+func (ctx *Context) stepCompleted(stepID string) bool {
+	if ctx.StepCompleted == nil {
+		return false
+	}
+	return ctx.StepCompleted(stepID)
+}
+
+// functionNames returns the sorted names of all built-in functions
+func functionNames() string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
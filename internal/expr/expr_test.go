@@ -0,0 +1,171 @@
+package expr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testContext() *Context {
+	env := map[string]string{"CI": "true", "EMPTY": ""}
+	files := map[string]bool{"/etc/hosts": true}
+	dirs := map[string]bool{"/etc": true}
+	completed := map[string]bool{"install-tools": true}
+
+	return &Context{
+		OS:   "linux",
+		Arch: "amd64",
+		Vars: map[string]string{"PACKAGE_MANAGER": "apt"},
+		LookupEnv: func(name string) (string, bool) {
+			v, ok := env[name]
+			return v, ok
+		},
+		StepCompleted: func(stepID string) bool { return completed[stepID] },
+		FileExists:    func(path string) bool { return files[path] },
+		DirExists:     func(path string) bool { return dirs[path] },
+		RunCommand: func(command string) (bool, error) {
+			if command == "broken" {
+				return false, errors.New("cannot run")
+			}
+			return command == "true", nil
+		},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want bool
+	}{
+		{"true literal", "true", true},
+		{"false literal", "false", false},
+		{"not", "!false", true},
+		{"double not", "!!true", true},
+		{"and", "true && false", false},
+		{"or", "false || true", true},
+		{"precedence", "true || false && false", true},
+		{"parentheses", "(true || false) && false", false},
+		{"os comparison", `os == "linux"`, true},
+		{"arch comparison", `arch != 'arm64'`, true},
+		{"env reference", `env.CI == "true"`, true},
+		{"unset env reference", `env.MISSING == ""`, true},
+		{"env function", `env("CI") == "true"`, true},
+		{"has_env set", `has_env("EMPTY")`, true},
+		{"has_env unset", `has_env("MISSING")`, false},
+		{"plan variable", `vars.PACKAGE_MANAGER == "apt"`, true},
+		{"file exists", `file_exists("/etc/hosts")`, true},
+		{"file missing", `file_exists("/nope")`, false},
+		{"dir exists", `dir_exists("/etc")`, true},
+		{"command succeeds", `command("true")`, true},
+		{"command fails", `command("false")`, false},
+		{"completed step name", "install-tools", true},
+		{"pending step name", "configure", false},
+		{"completed function", `completed("install-tools")`, true},
+		{"escaped quote", `env("CI") != "say \"hi\""`, true},
+		{"complex", `os == "darwin" || (env.CI == "true" && !file_exists("/nope"))`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Evaluate(tt.expr, testContext())
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEvalShortCircuit(t *testing.T) {
+	// The broken command would fail if it were evaluated
+	got, err := Evaluate(`false && command("broken")`, testContext())
+	require.NoError(t, err)
+	assert.False(t, got)
+
+	got, err = Evaluate(`true || command("broken")`, testContext())
+	require.NoError(t, err)
+	assert.True(t, got)
+
+	_, err = Evaluate(`command("broken")`, testContext())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "command: cannot run")
+}
+
+func TestEvalUndefinedVariable(t *testing.T) {
+	_, err := Evaluate(`vars.MISSING == "x"`, testContext())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "undefined variable 'MISSING'")
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		expr   string
+		errMsg string
+	}{
+		{"empty", "  ", "empty expression"},
+		{"shell command", "test -f /tmp/file", "unexpected character '-'"},
+		{"unterminated string", `env("CI`, "unterminated string"},
+		{"missing operand", "true &&", "unexpected end of expression"},
+		{"missing paren", "(true", "expected ')'"},
+		{"trailing tokens", "true false", "unexpected 'false'"},
+		{"unknown function", `exists("x")`, "unknown function 'exists'"},
+		{"wrong arity", `file_exists("a", "b")`, "expects 1 argument(s), got 2"},
+		{"unknown dotted name", "steps.install", "unknown name 'steps.install'"},
+		{"empty env name", "env. == ''", "invalid environment variable reference"},
+		{"single ampersand", "true & false", "unexpected character '&'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	scope := Scope{
+		Steps: map[string]bool{"install": true},
+		Vars:  map[string]bool{"PREFIX": true},
+	}
+
+	tests := []struct {
+		name   string
+		expr   string
+		errMsg string
+	}{
+		{"valid step reference", "install && !completed('install')", ""},
+		{"valid comparison", `vars.PREFIX == "/usr" || os == "darwin"`, ""},
+		{"unknown step", "instal", "unknown step or name 'instal'"},
+		{"undefined variable", `vars.PREFX == ""`, "undefined variable 'PREFX'"},
+		{"string result", `env("HOME")`, "expression must be a bool, got string"},
+		{"not on string", `!os`, "operator '!' requires a bool, got string"},
+		{"and on string", `os && true`, "operator '&&' requires bools"},
+		{"mixed comparison", `os == true`, "cannot compare string with bool"},
+		{"bool argument", `file_exists(true)`, "argument 1 of 'file_exists' must be a string, got bool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.expr)
+			require.NoError(t, err)
+
+			err = e.Check(scope)
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+			}
+		})
+	}
+
+	t.Run("nil scope skips existence checks", func(t *testing.T) {
+		e, err := Parse(`anything && vars.WHATEVER == ""`)
+		require.NoError(t, err)
+		assert.NoError(t, e.Check(Scope{}))
+	})
+}
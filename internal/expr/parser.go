@@ -0,0 +1,305 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// tokenKind identifies the kind of a lexical token
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokLParen
+	tokRParen
+	tokComma
+	tokNot
+	tokAnd
+	tokOr
+	tokEq
+	tokNeq
+)
+
+// token is a lexical token
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("'%s'", t.text)
+	}
+}
+
+// lexer splits an expression into tokens
+type lexer struct {
+	src []rune
+	pos int
+}
+
+func newLexer(source string) *lexer {
+	return &lexer{src: []rune(source)}
+}
+
+// isIdentRune reports whether r may appear inside an identifier.
+// Step IDs commonly contain dashes, and env./vars. references contain dots.
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(l.src[l.pos]) {
+		l.pos++
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	r := l.src[l.pos]
+	two := ""
+	if l.pos+1 < len(l.src) {
+		two = string(l.src[l.pos : l.pos+2])
+	}
+
+	switch {
+	case two == "&&":
+		l.pos += 2
+		return token{kind: tokAnd, text: two, pos: start}, nil
+	case two == "||":
+		l.pos += 2
+		return token{kind: tokOr, text: two, pos: start}, nil
+	case two == "==":
+		l.pos += 2
+		return token{kind: tokEq, text: two, pos: start}, nil
+	case two == "!=":
+		l.pos += 2
+		return token{kind: tokNeq, text: two, pos: start}, nil
+	case r == '!':
+		l.pos++
+		return token{kind: tokNot, text: "!", pos: start}, nil
+	case r == '(':
+		l.pos++
+		return token{kind: tokLParen, text: "(", pos: start}, nil
+	case r == ')':
+		l.pos++
+		return token{kind: tokRParen, text: ")", pos: start}, nil
+	case r == ',':
+		l.pos++
+		return token{kind: tokComma, text: ",", pos: start}, nil
+	case r == '"' || r == '\'':
+		return l.lexString(r)
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+		for l.pos < len(l.src) && isIdentRune(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: string(l.src[start:l.pos]), pos: start}, nil
+	}
+
+	return token{}, fmt.Errorf("unexpected character '%c' at position %d", r, start)
+}
+
+// lexString reads a quoted string. Backslash escapes the quote and itself.
+func (l *lexer) lexString(quote rune) (token, error) {
+	start := l.pos
+	l.pos++
+
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		r := l.src[l.pos]
+		switch {
+		case r == '\\' && l.pos+1 < len(l.src) && (l.src[l.pos+1] == quote || l.src[l.pos+1] == '\\'):
+			sb.WriteRune(l.src[l.pos+1])
+			l.pos += 2
+		case r == quote:
+			l.pos++
+			return token{kind: tokString, text: sb.String(), pos: start}, nil
+		default:
+			sb.WriteRune(r)
+			l.pos++
+		}
+	}
+
+	return token{}, fmt.Errorf("unterminated string starting at position %d", start)
+}
+
+// parser is a recursive descent parser for expressions
+type parser struct {
+	lexer *lexer
+	tok   token
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) expect(kind tokenKind, what string) error {
+	if p.tok.kind != kind {
+		return fmt.Errorf("expected %s at position %d, got %s", what, p.tok.pos, p.tok)
+	}
+	return p.advance()
+}
+
+// parseOr parses: and ( "||" and )*
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOr {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: tokOr, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary ( "&&" unary )*
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokAnd {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: tokAnd, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary parses: "!" unary | comparison
+func (p *parser) parseUnary() (node, error) {
+	if p.tok.kind == tokNot {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses: primary ( ("==" | "!=") primary )?
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEq && p.tok.kind != tokNeq {
+		return left, nil
+	}
+
+	op := p.tok.kind
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return &compareNode{op: op, left: left, right: right}, nil
+}
+
+// parsePrimary parses literals, names, function calls and parenthesized expressions
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return &literalNode{value: stringValue(tok.text)}, nil
+
+	case tokLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+
+	case tokIdent:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		switch tok.text {
+		case "true":
+			return &literalNode{value: boolValue(true)}, nil
+		case "false":
+			return &literalNode{value: boolValue(false)}, nil
+		}
+		if p.tok.kind == tokLParen {
+			return p.parseCall(tok)
+		}
+		return newNameNode(tok)
+	}
+
+	return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+}
+
+// parseCall parses the argument list of a function call
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s' at position %d (available: %s)", name.text, name.pos, functionNames())
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	call := &callNode{name: name.text, fn: fn, pos: name.pos}
+	for p.tok.kind != tokRParen {
+		if len(call.args) > 0 {
+			if err := p.expect(tokComma, "','"); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if len(call.args) != len(fn.args) {
+		return nil, fmt.Errorf("function '%s' expects %d argument(s), got %d", name.text, len(fn.args), len(call.args))
+	}
+	return call, nil
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ExpandEnv expands POSIX-style ${NAME} references in s using lookup:
+//
+//	${NAME}           value of NAME, empty if unset
+//	${NAME:-default}  default if NAME is unset or empty (${NAME-default}: only if unset)
+//	${NAME:+other}    other if NAME is set and not empty (${NAME+other}: if set)
+//	${NAME:?message}  error if NAME is unset or empty (${NAME?message}: only if unset)
+//
+// Defaults and messages may contain references themselves. $${ produces a
+// literal ${. Anything else, including $NAME, $$ and shell-only forms such
+// as ${#NAME}, is left untouched.
+func ExpandEnv(s string, lookup func(name string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "$${"):
+			buf.WriteString("${")
+			i += 3
+			continue
+		case !strings.HasPrefix(s[i:], "${"):
+			buf.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		end := closingBrace(s, i+2)
+		if end < 0 {
+			buf.WriteString(s[i:])
+			break
+		}
+
+		value, ok, err := expandReference(s[i+2:end], lookup)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			buf.WriteString(value)
+		} else {
+			buf.WriteString(s[i : end+1])
+		}
+		i = end + 1
+	}
+	return buf.String(), nil
+}
+
+// LookupEnv looks variables up in env first and in the process environment otherwise
+func LookupEnv(env map[string]string) func(name string) (string, bool) {
+	return func(name string) (string, bool) {
+		if value, ok := env[name]; ok {
+			return value, true
+		}
+		return os.LookupEnv(name)
+	}
+}
+
+// closingBrace returns the index of the brace closing the reference that starts at start
+func closingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expandReference expands the inside of a ${...} reference. ok is false for
+// references that are not supported and must be kept as written.
+func expandReference(ref string, lookup func(name string) (string, bool)) (value string, ok bool, err error) {
+	n := 0
+	for n < len(ref) && isNameChar(ref[n], n == 0) {
+		n++
+	}
+	if n == 0 {
+		return "", false, nil
+	}
+
+	name, rest := ref[:n], ref[n:]
+	value, set := lookup(name)
+	if rest == "" {
+		return value, true, nil
+	}
+
+	// With a colon an empty value counts as unset
+	colon := strings.HasPrefix(rest, ":")
+	if colon {
+		rest = rest[1:]
+	}
+	if rest == "" {
+		return "", false, nil
+	}
+	missing := !set || (colon && value == "")
+
+	op, word := rest[0], rest[1:]
+	switch op {
+	case '-':
+		if !missing {
+			return value, true, nil
+		}
+	case '+':
+		if missing {
+			return "", true, nil
+		}
+	case '?':
+		if !missing {
+			return value, true, nil
+		}
+		message, err := ExpandEnv(word, lookup)
+		if err != nil {
+			return "", false, err
+		}
+		if message == "" {
+			message = "parameter not set"
+		}
+		return "", false, fmt.Errorf("%s: %s", name, message)
+	default:
+		return "", false, nil
+	}
+
+	value, err = ExpandEnv(word, lookup)
+	return value, err == nil, err
+}
+
+// isNameChar reports whether c can appear in an environment variable name
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
@@ -15,7 +15,9 @@ func InitLogger(debug bool) error {
 		config.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
 	}
 
-	logger, err := config.Build()
+	logger, err := config.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &maskingCore{Core: core}
+	}))
 	if err != nil {
 		return err
 	}
@@ -37,3 +39,44 @@ func GetLogger() *zap.Logger {
 	}
 	return Logger
 }
+
+// maskingCore masks secret values in log messages and string fields
+type maskingCore struct {
+	zapcore.Core
+}
+
+// With adds fields to the core, masking their secret values
+func (c *maskingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &maskingCore{Core: c.Core.With(maskFields(fields))}
+}
+
+// Check adds the core to the checked entry if the level is enabled
+func (c *maskingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+// Write writes the entry with secret values masked
+func (c *maskingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = MaskSecrets(entry.Message)
+	return c.Core.Write(entry, maskFields(fields))
+}
+
+// maskFields masks secret values in string and error fields
+func maskFields(fields []zapcore.Field) []zapcore.Field {
+	masked := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			field.String = MaskSecrets(field.String)
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok {
+				field = zap.String(field.Key, MaskSecrets(err.Error()))
+			}
+		}
+		masked[i] = field
+	}
+	return masked
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MaskPlaceholder replaces secret values in masked text
+const MaskPlaceholder = "********"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   = make(map[string]bool)
+	masker    *strings.Replacer
+)
+
+// AddSecret registers a value that is masked from now on. Every line of a
+// multi-line value is registered as well, as output is often split into lines.
+func AddSecret(value string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	values := append([]string{value}, strings.Split(value, "\n")...)
+	for _, v := range values {
+		v = strings.TrimRight(v, "\r")
+		if strings.TrimSpace(v) != "" {
+			secrets[v] = true
+		}
+	}
+
+	// Longer values are replaced first, so that a secret containing another
+	// one is masked as a whole
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, v := range sortedSecrets() {
+		pairs = append(pairs, v, MaskPlaceholder)
+	}
+	masker = strings.NewReplacer(pairs...)
+}
+
+// ResetSecrets forgets all registered secrets
+func ResetSecrets() {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	secrets = make(map[string]bool)
+	masker = nil
+}
+
+// MaskSecrets replaces registered secret values in s
+func MaskSecrets(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+
+	if masker == nil {
+		return s
+	}
+	return masker.Replace(s)
+}
+
+// MaskSecretsJSON replaces registered secret values in the strings of a JSON
+// document. JSON escapes characters one by one, so a secret within a string
+// appears in its escaped form.
+func MaskSecretsJSON(data []byte) []byte {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+
+	if masker == nil {
+		return data
+	}
+
+	masked := string(data)
+	for _, v := range sortedSecrets() {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		masked = strings.ReplaceAll(masked, string(encoded[1:len(encoded)-1]), MaskPlaceholder)
+	}
+	return []byte(masked)
+}
+
+// sortedSecrets returns the registered secrets, longest first. The caller must hold secretsMu.
+func sortedSecrets() []string {
+	sorted := make([]string, 0, len(secrets))
+	for v := range secrets {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// maskingWriter masks registered secret values in everything written to it
+type maskingWriter struct {
+	w io.Writer
+}
+
+// NewMaskingWriter returns a writer that masks secret values before writing to w
+func NewMaskingWriter(w io.Writer) io.Writer {
+	return &maskingWriter{w: w}
+}
+
+// Write writes p with secret values masked
+func (m *maskingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(m.w, MaskSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
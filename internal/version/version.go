@@ -0,0 +1,205 @@
+// Package version parses tool versions and checks them against semver constraints
+package version
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// versionPattern finds a dotted version number in a tool's version output
+var versionPattern = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// Version is a major.minor.patch version. Parts records how many components
+// were given, so that a constraint on 1.21 matches every 1.21.x release.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	Parts int
+}
+
+// Parse parses a version such as 1, 1.21 or v1.21.5
+func Parse(s string) (Version, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	fields := strings.Split(s, ".")
+	if s == "" || len(fields) > 3 {
+		return Version{}, fmt.Errorf("invalid version '%s'", s)
+	}
+
+	var parts [3]int
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid version '%s'", s)
+		}
+		parts[i] = n
+	}
+	return Version{Major: parts[0], Minor: parts[1], Patch: parts[2], Parts: len(fields)}, nil
+}
+
+// Extract finds the first version number in the output of a version command,
+// such as 1.21.5 in "go version go1.21.5 linux/amd64"
+func Extract(output string) (Version, bool) {
+	match := versionPattern.FindString(output)
+	if match == "" {
+		return Version{}, false
+	}
+	v, err := Parse(match)
+	return v, err == nil
+}
+
+// String formats the version with the components it was given
+func (v Version) String() string {
+	parts := []int{v.Major, v.Minor, v.Patch}
+	n := v.Parts
+	if n < 1 || n > 3 {
+		n = 3
+	}
+	s := make([]string, n)
+	for i := range s {
+		s[i] = strconv.Itoa(parts[i])
+	}
+	return strings.Join(s, ".")
+}
+
+// Compare returns -1, 0 or 1 if v is lower than, equal to or higher than other
+func (v Version) Compare(other Version) int {
+	for _, pair := range [][2]int{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		switch {
+		case pair[0] < pair[1]:
+			return -1
+		case pair[0] > pair[1]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// matches reports whether v equals other in the components other was given
+func (v Version) matches(other Version) bool {
+	parts := []int{v.Major, v.Minor, v.Patch}
+	want := []int{other.Major, other.Minor, other.Patch}
+	for i := 0; i < other.Parts && i < 3; i++ {
+		if parts[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// comparison is a single operator and version of a constraint
+type comparison struct {
+	op      string
+	version Version
+}
+
+// Constraint is a set of comparisons that a version must all satisfy
+type Constraint struct {
+	raw         string
+	comparisons []comparison
+}
+
+// operators are the supported comparison operators, longest first
+var operators = []string{">=", "<=", "!=", ">", "<", "=", "^", "~"}
+
+// ParseConstraint parses a constraint made of comparisons separated by commas
+// or spaces, such as ">=1.21", "^16", ">=1.2, <2" or "*" for any version.
+// A version without an operator must match in the components it gives.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{raw: strings.TrimSpace(s)}
+	if c.raw == "" || c.raw == "*" {
+		return c, nil
+	}
+
+	fields := strings.Fields(strings.ReplaceAll(c.raw, ",", " "))
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		op := "="
+		for _, candidate := range operators {
+			if strings.HasPrefix(field, candidate) {
+				op = candidate
+				field = field[len(candidate):]
+				break
+			}
+		}
+		// Allow a space between the operator and the version
+		if field == "" && i+1 < len(fields) {
+			i++
+			field = fields[i]
+		}
+
+		v, err := Parse(field)
+		if err != nil {
+			return Constraint{}, fmt.Errorf("invalid constraint '%s': %w", c.raw, err)
+		}
+		c.comparisons = append(c.comparisons, comparison{op: op, version: v})
+	}
+	return c, nil
+}
+
+// Any reports whether the constraint accepts every version
+func (c Constraint) Any() bool {
+	return len(c.comparisons) == 0
+}
+
+// String returns the constraint as written
+func (c Constraint) String() string {
+	if c.raw == "" {
+		return "*"
+	}
+	return c.raw
+}
+
+// Check reports whether v satisfies every comparison of the constraint
+func (c Constraint) Check(v Version) bool {
+	for _, cmp := range c.comparisons {
+		if !cmp.check(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// check reports whether v satisfies the comparison
+func (cmp comparison) check(v Version) bool {
+	want := cmp.version
+	switch cmp.op {
+	case "=":
+		return v.matches(want)
+	case "!=":
+		return !v.matches(want)
+	case ">":
+		return v.Compare(want) > 0 && !v.matches(want)
+	case ">=":
+		return v.Compare(want) >= 0
+	case "<":
+		return v.Compare(want) < 0
+	case "<=":
+		return v.Compare(want) <= 0 || v.matches(want)
+	case "^":
+		// Changes that do not modify the left-most non-zero component
+		if v.Compare(want) < 0 {
+			return false
+		}
+		switch {
+		case want.Major > 0 || want.Parts == 1:
+			return v.Major == want.Major
+		case want.Minor > 0 || want.Parts == 2:
+			return v.Major == 0 && v.Minor == want.Minor
+		default:
+			return v.Major == 0 && v.Minor == 0 && v.Patch == want.Patch
+		}
+	case "~":
+		// Patch changes if a minor version is given, minor changes otherwise
+		if v.Compare(want) < 0 {
+			return false
+		}
+		if want.Parts == 1 {
+			return v.Major == want.Major
+		}
+		return v.Major == want.Major && v.Minor == want.Minor
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package version
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Version
+		wantErr bool
+	}{
+		{"1", Version{Major: 1, Parts: 1}, false},
+		{"1.21", Version{Major: 1, Minor: 21, Parts: 2}, false},
+		{"v1.21.5", Version{Major: 1, Minor: 21, Patch: 5, Parts: 3}, false},
+		{"", Version{}, true},
+		{"1.2.3.4", Version{}, true},
+		{"1.x", Version{}, true},
+		{"-1", Version{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+		found  bool
+	}{
+		{"go version go1.21.5 linux/amd64", "1.21.5", true},
+		{"psql (PostgreSQL) 16.1 (Ubuntu 16.1-1.pgdg22.04+1)", "16.1", true},
+		{"Docker version 24.0.7, build afdd53b", "24.0.7", true},
+		{"v20.10.0\n", "20.10.0", true},
+		{"jq-1.6", "1.6", true},
+		{"unknown option --version", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			got, found := Extract(tt.output)
+			assert.Equal(t, tt.found, found)
+			if tt.found {
+				assert.Equal(t, tt.want, got.String())
+			}
+		})
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"*", "0.1.0", true},
+		{"", "3.0.0", true},
+		{">=1.21", "1.21.0", true},
+		{">=1.21", "1.22.3", true},
+		{">=1.21", "1.20.14", false},
+		{">1.21", "1.21.5", false},
+		{">1.21", "1.22.0", true},
+		{"<2", "1.99.0", true},
+		{"<2", "2.0.0", false},
+		{"<=1.21", "1.21.9", true},
+		{"<=1.21", "1.22.0", false},
+		{"1.21", "1.21.5", true},
+		{"=1.21.5", "1.21.6", false},
+		{"!=1.21", "1.21.3", false},
+		{"!=1.21", "1.22.0", true},
+		{"^16", "16.4.0", true},
+		{"^16", "17.0.0", false},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "1.2.2", false},
+		{"^0.4", "0.4.7", true},
+		{"^0.4", "0.5.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{"~1.2", "1.2.9", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{">=1.2, <2", "1.9.0", true},
+		{">=1.2 <2", "2.1.0", false},
+		{">= 1.2", "1.2.0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint+" "+tt.version, func(t *testing.T) {
+			c, err := ParseConstraint(tt.constraint)
+			require.NoError(t, err)
+			v, err := Parse(tt.version)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, c.Check(v))
+		})
+	}
+
+	t.Run("invalid constraints", func(t *testing.T) {
+		for _, constraint := range []string{">=", ">=1.x", "latest", "1.2.3.4"} {
+			_, err := ParseConstraint(constraint)
+			assert.Error(t, err, constraint)
+		}
+	})
+
+	t.Run("any", func(t *testing.T) {
+		c, err := ParseConstraint("*")
+		require.NoError(t, err)
+		assert.True(t, c.Any())
+		assert.Equal(t, "*", c.String())
+
+		c, err = ParseConstraint(">=1.21")
+		require.NoError(t, err)
+		assert.False(t, c.Any())
+		assert.Equal(t, ">=1.21", c.String())
+	})
+}